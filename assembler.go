@@ -1,31 +1,378 @@
-package main
+package mary
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
-// Assemble assembles src. It returns SyntaxError on syntax error.
-func Assemble(src io.Reader) ([]Word, error) {
+// Program is an assembled Marie program.
+type Program struct {
+	Origin  Word            // address of the first word, set with ORG; execution starts there
+	Words   []Word          // machine code, to be loaded at Origin
+	Lines   []int           // source line of each word of Words, in its file if assembled from several
+	Symbols map[string]Word // label to address
+	Consts  map[string]Word // name to value of the constants defined with EQU
+	Pragmas []Pragma        // tool directives found in comments, in source order
+
+	// Relocs holds the index in Words of each instruction whose operand is the address of a label,
+	// which Relocate patches when it moves the program.
+	Relocs []int
+
+	// Data reports for each word of Words whether it was written by a data directive, such as DEC or ASC,
+	// rather than assembled from an instruction.
+	Data []bool
+
+	// Source is where in the source each word of Words was assembled from.
+	Source []SourcePos
+
+	// Xrefs holds, for each label and constant, the source lines that define and use it.
+	Xrefs map[string]Xref
+
+	// Warnings are the likely mistakes found in the program, in source order.
+	Warnings []Warning
+}
+
+// SourcePos is where in the source a word of a program was assembled from. Unlike Lines, it
+// gives the line in the included file or macro the word was written in.
+type SourcePos struct {
+	File  string // the file, or "" for the source given to Assemble
+	Line  int    // the line in File
+	Label string // the nearest label at or above the word's statement, such as Main.loop, or ""
+	Text  string // the statement, as written
+}
+
+// String formats s as "file:line", or "line N" in the source given to Assemble.
+func (s SourcePos) String() string {
+	if s.File == "" {
+		return fmt.Sprintf("line %d", s.Line)
+	}
+	return fmt.Sprintf("%s:%d", s.File, s.Line)
+}
+
+// Xref is the cross reference of a label or constant: where it is defined and used.
+// Lines are as in Lines.
+type Xref struct {
+	Defined int
+	Used    []int // in order, once per line
+}
+
+// Pragma is a tool directive written in a comment, such as "/ mary:allow self-modify".
+// The assembler only records pragmas; tools such as linters decide what they mean.
+type Pragma struct {
+	Line int      // line the pragma applies to: its own if it follows code, otherwise the next line with code
+	Name string   // eg., "allow"
+	Args []string // eg., ["self-modify"]
+}
+
+// pragmaPrefix starts a comment that is a Pragma.
+const pragmaPrefix = "mary:"
+
+// parsePragmas returns the pragmas in the comments of lines.
+func parsePragmas(lines []string) []Pragma {
+	var out []Pragma
+	var pending []Pragma // pragmas on comment-only lines, waiting for a line with code
+	for i, line := range lines {
+		code, comment, _ := strings.Cut(line, "/")
+		hasCode := strings.TrimSpace(code) != ""
+		if hasCode {
+			for _, p := range pending {
+				p.Line = i + 1
+				out = append(out, p)
+			}
+			pending = nil
+		}
+		comment = strings.TrimSpace(comment)
+		if !strings.HasPrefix(comment, pragmaPrefix) {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(comment, pragmaPrefix))
+		if len(fields) == 0 {
+			continue
+		}
+		p := Pragma{i + 1, fields[0], fields[1:]}
+		if hasCode {
+			out = append(out, p)
+		} else {
+			pending = append(pending, p)
+		}
+	}
+	return out
+}
+
+// Relocate returns a copy of p moved to origin, with the operands listed in Relocs and the
+// addresses in Symbols adjusted to match. Operands that are numbers or EQU constants are left alone.
+func (p Program) Relocate(origin Word) (Program, error) {
+	if int(origin)+len(p.Words) > machineMemory {
+		return Program{}, fmt.Errorf("relocate: %d words do not fit at %03x", len(p.Words), origin)
+	}
+	delta := origin - p.Origin
+	q := p
+	q.Origin = origin
+	q.Words = append([]Word(nil), p.Words...)
+	for _, i := range p.Relocs {
+		w := q.Words[i]
+		q.Words[i] = w&0xF000 | (w+delta)&0xFFF
+	}
+	q.Symbols = make(map[string]Word, len(p.Symbols))
+	for label, addr := range p.Symbols {
+		q.Symbols[label] = addr + delta
+	}
+	return q, nil
+}
+
+// Line returns the source line of the word at addr, or 0 if the program has no word there.
+// Words included from other files, or expanded from macros, are on the line of the INCLUDE or macro call.
+func (p Program) Line(addr Word) int {
+	i := int(addr) - int(p.Origin)
+	if i < 0 || i >= len(p.Lines) {
+		return 0
+	}
+	return p.Lines[i]
+}
+
+// SourceAt returns where the word at addr was assembled from, or false if the program has no word there.
+func (p Program) SourceAt(addr Word) (SourcePos, bool) {
+	i := int(addr) - int(p.Origin)
+	if i < 0 || i >= len(p.Source) {
+		return SourcePos{}, false
+	}
+	return p.Source[i], true
+}
+
+// PragmasAt returns the pragmas that apply to line with the given name.
+func (p Program) PragmasAt(line int, name string) []Pragma {
+	var out []Pragma
+	for _, pr := range p.Pragmas {
+		if pr.Line == line && pr.Name == name {
+			out = append(out, pr)
+		}
+	}
+	return out
+}
+
+// Assembler assembles Marie programs. The zero Assembler is ready to use, and is what Assemble
+// and AssembleFile use.
+type Assembler struct {
+	// Defines are the names defined for conditional assembly, and their values.
+	// IFDEF and IFEQ blocks are assembled according to them.
+	Defines map[string]Word
+
+	// IgnoreCase accepts mnemonics and directives in any case, such as load or HALT.
+	// A label spelled like a mnemonic or directive in another case, such as end, is then taken for it.
+	IgnoreCase bool
+
+	// IgnoreLabelCase makes labels and constants that differ only in case the same.
+	// Each is in Symbols and Consts as it is first spelled.
+	IgnoreLabelCase bool
+
+	// Strict reports the warnings of a program as errors, so that it does not assemble.
+	Strict bool
+
+	// Listing, if set, is written the listing of each program assembled without error: the address
+	// and machine word of each statement beside its source line.
+	Listing io.Writer
+}
+
+// Assemble assembles src. It returns SyntaxError on syntax error, or SyntaxErrors listing
+// them all if there are several.
+// Files named by INCLUDE directives are read relative to the current directory.
+func Assemble(src io.Reader) (Program, error) {
+	return new(Assembler).Assemble(src)
+}
+
+// AssembleFile assembles the named file, reading the files it includes relative to its directory.
+func AssembleFile(name string) (Program, error) {
+	return new(Assembler).AssembleFile(name)
+}
+
+// AssembleFiles assembles the named files as one program, as Assembler.AssembleFiles does.
+func AssembleFiles(names ...string) (Program, error) {
+	return new(Assembler).AssembleFiles(names...)
+}
+
+// Assemble assembles src. It returns SyntaxError on syntax error, or SyntaxErrors listing
+// them all if there are several.
+// Files named by INCLUDE directives are read relative to the current directory.
+func (a *Assembler) Assemble(src io.Reader) (Program, error) {
+	return a.assemble(src, ".", "")
+}
+
+// AssembleFile assembles the named file, reading the files it includes relative to its directory.
+func (a *Assembler) AssembleFile(name string) (Program, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return Program{}, err
+	}
+	defer f.Close()
+	return a.assemble(f, filepath.Dir(name), name)
+}
+
+// AssembleFiles assembles the named files as one program, as if they were one file: in order,
+// sharing their labels, constants and macros. Each file ends at its own END, and the first holds
+// the program's ORG, if it has one. Lines, and the errors, of the files after the first are
+// numbered within their own files, which SyntaxError's File gives. Pragmas are taken from the first.
+func (a *Assembler) AssembleFiles(names ...string) (Program, error) {
+	var stmts []sourceLine
+	var lines []string
+	for i, name := range names {
+		raw, err := os.ReadFile(name)
+		if err != nil {
+			return Program{}, err
+		}
+		top := name
+		if i == 0 {
+			top = ""
+		}
+		s, l, err := a.readFile(raw, filepath.Dir(name), name, top)
+		if err != nil {
+			return Program{}, err
+		}
+		if i == 0 {
+			lines = l
+		}
+		stmts = append(stmts, s...)
+	}
+	return a.assembleLines(stmts, lines)
+}
+
+// assemble assembles src, the file named name if it has one, reading included files relative to dir.
+func (a *Assembler) assemble(src io.Reader, dir, name string) (Program, error) {
 	raw, err := io.ReadAll(src)
 	if err != nil {
-		return nil, err
+		return Program{}, err
+	}
+	stmts, lines, err := a.readFile(raw, dir, name, "")
+	if err != nil {
+		return Program{}, err
+	}
+	return a.assembleLines(stmts, lines)
+}
+
+// readFile returns the statements of raw, the source of the file named name if it has one, with
+// its conditional blocks resolved and the files it includes read relative to dir, and its lines.
+// top is the name for SyntaxError's File of the lines, "" for the first file of a program.
+func (a *Assembler) readFile(raw []byte, dir, name, top string) ([]sourceLine, []string, error) {
+	lines := untilEnd(a.split(raw))
+	stmts := make([]sourceLine, len(lines))
+	for i, line := range lines {
+		stmts[i] = sourceLine{lineNo: i + 1, text: line, file: name, fileLine: i + 1, top: top}
+	}
+	stmts, err := conditionals(stmts, a.Defines)
+	if err != nil {
+		return nil, nil, err
+	}
+	var including []string
+	if name != "" {
+		including = []string{name}
+	}
+	stmts, err = a.includeFiles(stmts, dir, including)
+	return stmts, lines, err
+}
+
+// assembleLines assembles the statements stmts, whose macros are not yet expanded. lines are the
+// lines of the first file, from which the pragmas are taken.
+func (a *Assembler) assembleLines(stmts []sourceLine, lines []string) (Program, error) {
+	stmts, err := expandMacros(stmts)
+	if err != nil {
+		return Program{}, err
 	}
-	lines := strings.Split(string(raw), "\n")
 
 	// symtab is mapping identifier to address of identifier label.
 	symtab := make(map[string]Word)
+	// consts is mapping identifier to value of EQU constant.
+	consts := make(map[string]Word)
+
+	// errs holds the error found in each statement, if any. Both passes go on past errors, so that
+	// all are reported at once; a statement with an error in the first pass is skipped by the second.
+	errs := make([]*SyntaxError, len(stmts))
+	fail := func(i int, e SyntaxError) {
+		errs[i] = &e
+	}
 
 	// First pass; fill symtab.
-	var addr Word
-	for i, line := range lines {
-		lineNo := i + 1
-		tokens, err := tokenize(line)
+	// An ORG directive sets the origin, and must come before any code.
+	var addr, origin Word
+	var scope localScope
+	spelling := make(map[string]string) // for IgnoreLabelCase, the first spelling of each name in lowercase
+	defined := make(map[string]int)     // index in stmts of the line defining each label and constant
+	w := &warnings{stmts: stmts, defined: defined, uses: make(map[string][]int)}
+	for i, l := range stmts {
+		tokens, err := tokenize(l.text)
 		if err != nil {
-			return nil, SyntaxError{lineNo, line}
+			fail(i, l.tokenSyntaxError(err))
+			continue
+		}
+		raw := tokens
+		tokens, err = scope.qualify(l, tokens)
+		if err != nil {
+			fail(i, err.(SyntaxError))
+			continue
+		}
+		if a.IgnoreLabelCase {
+			tokens = foldLabels(tokens, spelling)
+		}
+		if len(tokens) >= 3 && tokens[2].str == "ORG" {
+			// ORG cannot be labelled; it emits no word for the label to name.
+			fail(i, l.syntaxErrorAt(ErrBadDirective, "ORG cannot be labelled", raw, 0, ""))
+			continue
+		}
+		if len(tokens) >= 4 && tokens[1].str == "," && tokens[3].str == "EQU" {
+			// Nor can EQU; a constant takes no memory for the label to name.
+			fail(i, l.syntaxErrorAt(ErrBadDirective, "EQU cannot be labelled", raw, 0, ""))
+			continue
+		}
+		if len(tokens) > 0 && tokens[0].str == "ORG" {
+			if addr != origin {
+				fail(i, l.syntaxErrorAt(ErrBadDirective, "ORG must come before any code", raw, 0, ""))
+				continue
+			}
+			if hashTokens(tokens) != hashTokenTypes(TokenDirective, TokenNumber) {
+				fail(i, l.syntaxErrorAt(ErrBadDirective, "ORG needs an address", raw, 1, "a hex address"))
+				continue
+			}
+			n, err := parseWord(tokens[1].str, 16)
+			if err == nil && n >= machineMemory {
+				err = ErrValueOutOfRange
+			}
+			if err != nil {
+				fail(i, l.syntaxErrorAt(operandKind(err), "bad ORG address", raw, 1, "a hex address below 1000"))
+				continue
+			}
+			origin, addr = n, n
+			continue
+		}
+		if len(tokens) >= 2 && tokens[1].str == "EQU" {
+			// A constant takes no memory. Its value may only use the constants and labels defined above it.
+			if len(tokens) != 3 || hashTokens(tokens[:2]) != hashTokenTypes(TokenIdentifier, TokenDirective) {
+				fail(i, l.syntaxError(ErrBadDirective, ""))
+				continue
+			}
+			n, _, err := evalOperand(tokens[2], 16, symtab, consts)
+			if err != nil {
+				if err, ok := err.(undefinedError); ok {
+					fail(i, l.syntaxErrorAt(ErrUndefinedSymbol, err.Error()+"; a constant may only use names defined above it", raw, 2, ""))
+				} else {
+					fail(i, l.syntaxErrorAt(operandKind(err), "", raw, 2, "a number, name or expression"))
+				}
+				// Defined all the same, so that its uses are not reported as undefined too.
+				consts[tokens[0].str] = 0
+				continue
+			}
+			if d, ok := defined[tokens[0].str]; ok {
+				fail(i, l.syntaxErrorAt(ErrDuplicateSymbol, tokens[0].str+" is already defined at "+stmts[d].location(), raw, 0, "a new name"))
+				continue
+			}
+			defined[tokens[0].str] = i
+			w.use(tokens[2], l.lineNo)
+			consts[tokens[0].str] = n
+			continue
 		}
 		switch len(tokens) {
 		case 0:
@@ -35,31 +382,72 @@ func Assemble(src io.Reader) ([]Word, error) {
 			addr++
 			continue
 		}
+		stmt := tokens
 		switch hashTokens(tokens[:2]) {
 		case hashTokenTypes(TokenIdentifier, TokenComma):
 			identifier := tokens[0].str
+			if d, ok := defined[identifier]; ok {
+				fail(i, l.syntaxErrorAt(ErrDuplicateSymbol, identifier+" is already defined at "+stmts[d].location(), raw, 0, "a new label"))
+				continue
+			}
+			defined[identifier] = i
 			symtab[identifier] = addr
+			stmt = tokens[2:]
+		}
+		if hashTokens(stmt) == hashTokenTypes(TokenDirective, TokenString) {
+			// A string takes a word per character.
+			words, err := parseString(stmt[0].str, stmt[1].str)
+			if err != nil {
+				fail(i, l.syntaxError(ErrBadOperand, ""))
+				continue
+			}
+			addr += Word(len(words))
+			continue
 		}
 		addr++
 	}
 
-	// Second pass; write to out.
+	// Second pass; write to out, and the line of each word to lineOf.
 	var out []Word
-	for i, line := range lines {
-		lineNo := i + 1
-		tokens, err := tokenize(line)
+	var lineOf, relocs []int
+	var data []bool
+	var source []SourcePos
+	var label string // the last label defined, for source
+	scope = localScope{}
+	for i, l := range stmts {
+		if errs[i] != nil {
+			continue
+		}
+		lineNo := l.lineNo
+		raw, err := tokenize(l.text)
+		var tokens []Token
+		if err == nil {
+			tokens, err = scope.qualify(l, raw)
+		}
 		if err != nil {
 			// unreachable; already checked in first pass
 			panic(err)
 		}
+		if a.IgnoreLabelCase {
+			tokens = foldLabels(tokens, spelling)
+		}
+		at := 0 // index in raw of tokens[0]
 		if len(tokens) >= 2 {
 			switch hashTokens(tokens[:2]) {
 			case hashTokenTypes(TokenIdentifier, TokenComma):
-				tokens = tokens[2:]
+				label = tokens[0].str
+				tokens, at = tokens[2:], 2
 			}
 		}
 		switch hashTokens(tokens) {
 		case hashTokenTypes(): // empty (or comment) lines
+		case hashTokenTypes(TokenIdentifier, TokenDirective, TokenNumber),
+			hashTokenTypes(TokenIdentifier, TokenDirective, TokenIdentifier),
+			hashTokenTypes(TokenIdentifier, TokenDirective, TokenExpr): // EQU, handled in the first pass
+			if tokens[1].str != "EQU" {
+				fail(i, l.syntaxError(ErrBadStatement, ""))
+				continue
+			}
 		case hashTokenTypes(TokenInstruction):
 			instruction := tokens[0].str
 			switch opcode[instruction] {
@@ -68,12 +456,14 @@ func Assemble(src io.Reader) ([]Word, error) {
 			case OpHalt:
 			case OpClear:
 			default:
-				return nil, SyntaxError{lineNo, line}
+				fail(i, l.syntaxErrorAt(ErrBadOperand, instruction+" needs an operand", raw, at+1, "an operand"))
+				continue
 			}
 			out = append(out, Word(opcode[instruction]<<12))
-		case hashTokenTypes(TokenInstruction, TokenIdentifier):
+		case hashTokenTypes(TokenInstruction, TokenIdentifier),
+			hashTokenTypes(TokenInstruction, TokenNumber),
+			hashTokenTypes(TokenInstruction, TokenExpr):
 			instruction := tokens[0].str
-			identifier := tokens[1].str
 			switch opcode[instruction] {
 			case OpJnS:
 			case OpLoad:
@@ -88,81 +478,437 @@ func Assemble(src io.Reader) ([]Word, error) {
 			case OpStoreI:
 			case OpDump:
 			default:
-				return nil, SyntaxError{lineNo, line}
+				fail(i, l.syntaxErrorAt(ErrBadOperand, instruction+" takes no operand", raw, at+1, "no operand"))
+				continue
 			}
-			out = append(out, Word(opcode[instruction]<<12))
-			n, ok := symtab[identifier]
-			if !ok {
-				return nil, SyntaxError{lineNo, line}
+			n, reloc, err := evalOperand(tokens[1], 16, symtab, consts)
+			if _, ok := err.(undefinedError); ok {
+				fail(i, l.syntaxErrorAt(ErrUndefinedSymbol, err.Error(), raw, at+1, "a defined label or constant"))
+				continue
+			} else if err != nil {
+				fail(i, l.syntaxErrorAt(operandKind(err), "", raw, at+1, "an address, label or expression"))
+				continue
 			}
-			out[len(out)-1] |= n & 0xFFF
-		case hashTokenTypes(TokenInstruction, TokenNumber):
-			instruction := tokens[0].str
-			number := tokens[1].str
-			switch opcode[instruction] {
-			case OpJnS:
-			case OpLoad:
-			case OpStore:
-			case OpAdd:
-			case OpSubt:
-			case OpSkipcond:
-			case OpJump:
-			case OpAddI:
-			case OpJumpI:
-			case OpLoadI:
-			case OpStoreI:
-			case OpDump:
-			default:
-				return nil, SyntaxError{lineNo, line}
+			if reloc {
+				relocs = append(relocs, len(out))
 			}
-			out = append(out, Word(opcode[instruction]<<12))
-			n, err := parseWord(number, 16)
-			if err != nil {
-				return nil, SyntaxError{lineNo, line}
+			w.use(tokens[1], lineNo)
+			if op := opcode[instruction]; op == OpJump || op == OpJnS {
+				w.jumps = append(w.jumps, jump{i, op, n & 0xFFF})
 			}
-			out[len(out)-1] |= n & 0xFFF
-		case hashTokenTypes(TokenDirective, TokenNumber):
+			out = append(out, Word(opcode[instruction]<<12)|n&0xFFF)
+		case hashTokenTypes(TokenDirective, TokenNumber),
+			hashTokenTypes(TokenDirective, TokenIdentifier),
+			hashTokenTypes(TokenDirective, TokenExpr):
 			directive := tokens[0].str
-			number := tokens[1].str
 			var base int
 			switch directive {
+			case "ORG":
+				// Handled in the first pass.
+				continue
 			case "HEX":
 				base = 16
 			case "DEC":
 				base = 10
+			case "OCT":
+				base = 8
+			case "BIN":
+				base = 2
 			default:
-				panic("unreachable")
+				fail(i, l.syntaxError(ErrBadDirective, ""))
+				continue
 			}
-			n, err := parseWord(number, base)
-			if err != nil {
-				return nil, SyntaxError{lineNo, line}
+			n, reloc, err := evalOperand(tokens[1], base, symtab, consts)
+			if _, ok := err.(undefinedError); ok {
+				fail(i, l.syntaxErrorAt(ErrUndefinedSymbol, err.Error(), raw, at+1, "a defined label or constant"))
+				continue
+			} else if err != nil {
+				fail(i, l.syntaxErrorAt(operandKind(err), "", raw, at+1, "a number, label or expression"))
+				continue
+			}
+			if reloc {
+				relocs = append(relocs, len(out))
 			}
+			w.use(tokens[1], lineNo)
 			out = append(out, n)
+		case hashTokenTypes(TokenDirective, TokenString):
+			words, err := parseString(tokens[0].str, tokens[1].str)
+			if err != nil {
+				fail(i, l.syntaxError(ErrBadOperand, ""))
+				continue
+			}
+			out = append(out, words...)
 		default:
-			return nil, SyntaxError{lineNo, line}
+			if len(tokens) > 2 && hashTokens(tokens[:1]) == hashTokenTypes(TokenInstruction) {
+				switch opcode[tokens[0].str] {
+				case OpInput, OpOutput, OpHalt, OpClear:
+					fail(i, l.syntaxErrorAt(ErrBadOperand, tokens[0].str+" takes no operand", raw, at+1, "no operand"))
+					continue
+				}
+				fail(i, l.syntaxErrorAt(ErrBadOperand, tokens[0].str+" takes one operand", raw, at+2, "the end of the line"))
+				continue
+			}
+			if len(tokens) > 0 && hashTokens(tokens[:1]) == hashTokenTypes(TokenIdentifier) {
+				// The name must have been meant as a mnemonic.
+				fail(i, l.syntaxErrorAt(ErrIllegalOpcode, tokens[0].str+" is not an instruction", raw, at, "a mnemonic such as Load"))
+				continue
+			}
+			fail(i, l.syntaxError(ErrBadStatement, ""))
+			continue
+		}
+		// Directives that emit words, such as DEC and ASC, emit data.
+		isData := len(tokens) > 0 && hashTokens(tokens[:1]) == hashTokenTypes(TokenDirective)
+		for len(lineOf) < len(out) {
+			lineOf = append(lineOf, lineNo)
+			data = append(data, isData)
+			w.stmtOf = append(w.stmtOf, i)
+			source = append(source, SourcePos{l.file, l.fileLine, label, strings.TrimSpace(l.text)})
 		}
 	}
-	return out, nil
+	var all SyntaxErrors
+	for _, e := range errs {
+		if e != nil {
+			all = append(all, *e)
+		}
+	}
+	switch len(all) {
+	case 0:
+	case 1:
+		return Program{}, all[0]
+	default:
+		return Program{}, all
+	}
+	p := Program{origin, out, lineOf, symtab, consts, parsePragmas(lines), relocs, data, source, w.xrefs(), nil}
+	p.Warnings = w.find(p)
+	if a.Strict && len(p.Warnings) > 0 {
+		if len(p.Warnings) == 1 {
+			return Program{}, p.Warnings[0].SyntaxError
+		}
+		errs := make(SyntaxErrors, len(p.Warnings))
+		for i, w := range p.Warnings {
+			errs[i] = w.SyntaxError
+		}
+		return Program{}, errs
+	}
+	if a.Listing != nil {
+		if err := writeListing(a.Listing, p, stmts, w.stmtOf); err != nil {
+			return Program{}, err
+		}
+	}
+	return p, nil
 }
 
+// parseWord parses num in base, unless it has a 0x prefix, or outside base 16 a 0b or 0o prefix,
+// naming another base, or as the code of the character of a character literal such as 'A'.
 func parseWord(num string, base int) (Word, error) {
-	out, err := strconv.ParseInt(num, base, 0)
+	if strings.HasPrefix(num, "'") {
+		c, _ := utf8.DecodeRuneInString(num[1:])
+		if c == utf8.RuneError || c > 0xFFFF {
+			return 0, fmt.Errorf("parseWord: parsing %s: bad character", num)
+		}
+		return Word(c), nil
+	}
+	sign, digits := "", num
+	if strings.HasPrefix(digits, "-") || strings.HasPrefix(digits, "+") {
+		sign, digits = digits[:1], digits[1:]
+	}
+	// In base 16, 0b1 is a number rather than a prefix: only 0x names a base there.
+	switch {
+	case strings.HasPrefix(digits, "0x"):
+		base, digits = 16, digits[2:]
+	case base != 16 && strings.HasPrefix(digits, "0b"):
+		base, digits = 2, digits[2:]
+	case base != 16 && strings.HasPrefix(digits, "0o"):
+		base, digits = 8, digits[2:]
+	}
+	out, err := strconv.ParseInt(sign+digits, base, 0)
+	if errors.Is(err, strconv.ErrRange) || err == nil && (out < -1<<15 || out > 0xFFFF) {
+		return 0, fmt.Errorf("parseWord: parsing %q: %w", num, ErrValueOutOfRange)
+	}
 	if err != nil {
 		return 0, err
 	}
-	if out < -1<<15 || out > 0xFFFF {
-		return 0, fmt.Errorf("parseWord: parsing %q: out of range", num)
-	}
 	return Word(out), nil
 }
 
+// evalOperand evaluates the operand tok: a number in base, a label or constant, or an expression
+// such as Table+2 or MAX*2 combining them with +, - and *. Constants are taken from consts and
+// labels from symtab. It also reports whether the value is a label's address plus a constant,
+// which Relocate must patch when the program moves.
+func evalOperand(tok Token, base int, symtab, consts map[string]Word) (Word, bool, error) {
+	if hashTokens([]Token{tok}) == hashTokenTypes(TokenNumber) {
+		n, err := parseWord(tok.str, base)
+		return n, false, err
+	}
+	p := &operandParser{s: tok.str, base: base, symtab: symtab, consts: consts}
+	v, labels, err := p.expr()
+	if err == nil && p.s != "" {
+		err = fmt.Errorf("unexpected %q", p.s)
+	}
+	if err == nil && len(p.undefined) > 0 {
+		return 0, false, undefinedError(p.undefined)
+	}
+	if err == nil && (v < -1<<15 || v > 0xFFFF) {
+		err = fmt.Errorf("%d does not fit in a word: %w", v, ErrValueOutOfRange)
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("evalOperand: %s: %w", tok.str, err)
+	}
+	return Word(v), labels == 1, nil
+}
+
+// undefinedError is the error of evalOperand for an operand using names that are not defined.
+type undefinedError []string
+
+func (e undefinedError) Error() string {
+	if len(e) == 1 {
+		return "undefined symbol " + e[0]
+	}
+	return "undefined symbols " + strings.Join(e, ", ")
+}
+
+// operandParser parses the expression s of evalOperand. Each production returns the value,
+// and the number of label addresses added into it, less those subtracted.
+type operandParser struct {
+	s              string
+	base           int
+	symtab, consts map[string]Word
+	undefined      []string // names used but not defined, taken as 0 so that all are found
+}
+
+// expr parses term (('+' | '-') term)*.
+func (p *operandParser) expr() (int, int, error) {
+	v, labels, err := p.term()
+	for err == nil && p.s != "" && (p.s[0] == '+' || p.s[0] == '-') {
+		op := p.s[0]
+		p.s = p.s[1:]
+		var v2, labels2 int
+		v2, labels2, err = p.term()
+		if op == '+' {
+			v, labels = v+v2, labels+labels2
+		} else {
+			v, labels = v-v2, labels-labels2
+		}
+	}
+	return v, labels, err
+}
+
+// term parses unary ('*' unary)*.
+func (p *operandParser) term() (int, int, error) {
+	v, labels, err := p.unary()
+	for err == nil && p.s != "" && p.s[0] == '*' {
+		p.s = p.s[1:]
+		var v2, labels2 int
+		v2, labels2, err = p.unary()
+		if labels != 0 && labels2 != 0 {
+			return 0, 0, fmt.Errorf("multiplies two addresses")
+		}
+		v, labels = v*v2, labels*v2+labels2*v
+	}
+	return v, labels, err
+}
+
+// unary parses '-' unary | '(' expr ')' | number | name.
+func (p *operandParser) unary() (int, int, error) {
+	if strings.HasPrefix(p.s, "-") {
+		p.s = p.s[1:]
+		v, labels, err := p.unary()
+		return -v, -labels, err
+	}
+	if strings.HasPrefix(p.s, "(") {
+		p.s = p.s[1:]
+		v, labels, err := p.expr()
+		if err == nil && !strings.HasPrefix(p.s, ")") {
+			err = fmt.Errorf("missing )")
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		p.s = p.s[1:]
+		return v, labels, nil
+	}
+	i := strings.IndexAny(p.s, "+-*()")
+	if i < 0 {
+		i = len(p.s)
+	}
+	atom := p.s[:i]
+	p.s = p.s[i:]
+	switch {
+	case TokenNumber(atom):
+		n, err := parseWord(atom, p.base)
+		return int(n), 0, err
+	case TokenIdentifier(atom):
+		if n, ok := p.consts[atom]; ok {
+			return int(n), 0, nil
+		}
+		if n, ok := p.symtab[atom]; ok {
+			return int(n), 1, nil
+		}
+		p.undefined = append(p.undefined, atom)
+		return 0, 0, nil
+	}
+	return 0, 0, fmt.Errorf("bad operand %q", atom)
+}
+
+// parseString returns the words of the string literal lit given to directive:
+// the code of each character for ASC, followed by a 0 for ASCZ.
+func parseString(directive, lit string) ([]Word, error) {
+	if directive != "ASC" && directive != "ASCZ" {
+		return nil, fmt.Errorf("parseString: %s does not take a string", directive)
+	}
+	var out []Word
+	for _, c := range lit[1 : len(lit)-1] {
+		if c == utf8.RuneError || c > 0xFFFF {
+			return nil, fmt.Errorf("parseString: %q: bad character", lit)
+		}
+		out = append(out, Word(c))
+	}
+	if directive == "ASCZ" {
+		out = append(out, 0)
+	}
+	return out, nil
+}
+
+// Kinds of SyntaxError, for telling errors apart with errors.Is.
+var (
+	ErrBadToken        = errors.New("bad token")          // text that is not a token, such as an unterminated string
+	ErrBadStatement    = errors.New("bad statement")      // tokens that do not make an instruction or directive
+	ErrIllegalOpcode   = errors.New("illegal opcode")     // a statement that starts with a name that is not a mnemonic
+	ErrBadOperand      = errors.New("bad operand")        // a missing, extra or malformed operand
+	ErrUndefinedSymbol = errors.New("undefined symbol")   // an operand naming a label or constant that is not defined
+	ErrDuplicateSymbol = errors.New("duplicate symbol")   // a label or constant defined twice
+	ErrValueOutOfRange = errors.New("value out of range") // a number or address too large for a word or memory
+	ErrBadDirective    = errors.New("bad directive")      // misuse of ORG, EQU, INCLUDE, macros or conditionals
+)
+
+// operandKind returns the kind of error for an operand that does not evaluate because of err.
+func operandKind(err error) error {
+	if errors.Is(err, ErrValueOutOfRange) {
+		return ErrValueOutOfRange
+	}
+	return ErrBadOperand
+}
+
+// SyntaxError is an error in a line of a program. Its kind, such as ErrUndefinedSymbol,
+// can be tested with errors.Is.
 type SyntaxError struct {
 	lineNo int
+	file   string // the file lineNo is a line of, if it is not the first of the program
 	line   string
+	reason string // what is wrong with line, if known
+
+	// col is the column of line, counting from 1, where the offending token starts, or 0 if the error
+	// is not about one token. expected, if known, is what should have been there.
+	col      int
+	token    string
+	expected string
+
+	kind error // returned by Unwrap
+
+	// where is where line came from if it is not on line lineNo of the source, innermost first.
+	// eg., ["macro Inc at lib.mas:3", "line 12"].
+	where []string
 }
 
 func (s SyntaxError) Error() string {
-	return fmt.Sprintf("syntax: line %d: %s", s.lineNo, s.line)
+	return fmt.Sprintf("syntax: %s: %s", s.position(), s.detail())
+}
+
+// position formats the line of the error, with its file if it is not the first of the program.
+// eg., "line 3" or "lib.mas:3".
+func (s SyntaxError) position() string {
+	if s.file != "" {
+		return fmt.Sprintf("%s:%d", s.file, s.lineNo)
+	}
+	return fmt.Sprintf("line %d", s.lineNo)
+}
+
+// detail is the line, followed by the reason it is wrong if known and where it came from.
+func (s SyntaxError) detail() string {
+	d := s.line
+	if s.reason != "" {
+		d += ": " + s.reason
+	}
+	if len(s.where) > 0 {
+		d += " (in " + strings.Join(s.where, ", from ") + ")"
+	}
+	return d
+}
+
+func (s SyntaxError) Unwrap() error {
+	return s.kind
+}
+
+// Line returns the line of the source the error is on. For a line that was included or expanded from
+// a macro, it is the line of the INCLUDE or macro call.
+func (s SyntaxError) Line() int {
+	return s.lineNo
+}
+
+// File returns the file the error's Line is in, if the program was assembled from several files
+// with AssembleFiles and it is not the first, or "" otherwise.
+func (s SyntaxError) File() string {
+	return s.file
+}
+
+// Column returns the column, counting from 1, where the offending token starts in the text of the
+// statement, or 0 if the error is not about one token.
+func (s SyntaxError) Column() int {
+	return s.col
+}
+
+// Token returns the offending token, or "" if the error is not about one token.
+func (s SyntaxError) Token() string {
+	return s.token
+}
+
+// Expected describes what should have been in place of the offending token, such as "an operand",
+// or returns "" if that is not known.
+func (s SyntaxError) Expected() string {
+	return s.expected
+}
+
+// Caret returns the statement with a caret under the offending token on the following line, both
+// indented by a tab, followed by what was expected. It returns "" if the error is not about one token.
+//
+//	Halt 5
+//	     ^ expected no operand
+func (s SyntaxError) Caret() string {
+	if s.col == 0 {
+		return ""
+	}
+	pad := []rune(s.line[:s.col-1])
+	for i, r := range pad {
+		if r != '\t' {
+			pad[i] = ' '
+		}
+	}
+	c := "\t" + s.line + "\n\t" + string(pad) + "^"
+	if s.expected != "" {
+		c += " expected " + s.expected
+	}
+	return c + "\n"
+}
+
+// SyntaxErrors is a list of syntax errors in source order, returned when the assembler finds
+// several, so that they can all be fixed at once. Errors in INCLUDE, MACRO and conditional
+// directives stop the assembler at the first.
+type SyntaxErrors []SyntaxError
+
+func (e SyntaxErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, s := range e {
+		lines[i] = s.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e SyntaxErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, s := range e {
+		errs[i] = s
+	}
+	return errs
 }
 
 // Token is the smallest sub-string unit of the src.
@@ -180,19 +926,27 @@ func TokenInstruction(s string) bool {
 	return ok
 }
 
-// TokenDirective is a TokenType for directives. eg., "DEC" or "HEX".
+// TokenDirective is a TokenType for directives. eg., "DEC", "HEX", "BIN", "ORG", "END", "EQU" or "ASC".
 func TokenDirective(s string) bool {
-	return regexp.MustCompile(`^(DEC|HEX)$`).FindStringIndex(s) != nil
+	return regexp.MustCompile(`^(DEC|HEX|OCT|BIN|ORG|END|EQU|ASCZ?|MACRO|ENDM|INCLUDE|IFDEF|IFNDEF|IFEQ|ELSE|ENDIF)$`).FindStringIndex(s) != nil
 }
 
-// TokenNumber is a TokenType for numbers. eg., "15" or "0xF".
+// TokenNumber is a TokenType for numbers. eg., "15", "0xF", "0b1010", "0o17" or the character literal "'A'".
 func TokenNumber(s string) bool {
-	return regexp.MustCompile(`^[-+]?[0-9][0-9A-Fa-f]*$`).FindStringIndex(s) != nil
+	return regexp.MustCompile(`^([-+]?([0-9][0-9A-Fa-f]*|0[box][0-9A-Fa-f]+)|'.')$`).FindStringIndex(s) != nil
 }
 
-// TokenIdentifier is a TokenType for identifiers. eg., "var" or "x1".
+// TokenIdentifier is a TokenType for identifiers. eg., "var", "x1", the local label ".loop"
+// or the qualified local label "Main.loop".
 func TokenIdentifier(s string) bool {
-	return regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`).FindStringIndex(s) != nil
+	return regexp.MustCompile(`^([A-Za-z][A-Za-z0-9]*)?\.?[A-Za-z][A-Za-z0-9]*$`).FindStringIndex(s) != nil
+}
+
+// TokenExpr is a TokenType for operand expressions, written without spaces. eg., "Table+2", "MAX*2",
+// "-MAX" or "(N+1)*2".
+func TokenExpr(s string) bool {
+	return regexp.MustCompile(`^-?\(*-?[A-Za-z0-9.]+\)*([-+*]-?\(*-?[A-Za-z0-9.]+\)*)*$`).FindStringIndex(s) != nil &&
+		!TokenIdentifier(s) && !TokenNumber(s)
 }
 
 // TokenComma is a TokenType for commas. eg., ",".
@@ -200,7 +954,59 @@ func TokenComma(s string) bool {
 	return s == ","
 }
 
+// TokenString is a TokenType for string literals. eg., "\"Hello, world\"".
+// A string may hold any character but a double quote, including / and ,.
+func TokenString(s string) bool {
+	return len(s) >= 2 && s[0] == '"' && strings.IndexByte(s[1:], '"') == len(s)-2
+}
+
 func tokenize(line string) ([]Token, error) {
+	var out []Token
+	for {
+		// Strings and characters are split out first, so that the spaces, / and , inside them are left alone.
+		i := strings.IndexAny(line, `/"'`)
+		if i < 0 || line[i] == '/' {
+			break
+		}
+		var tok Token
+		if line[i] == '"' {
+			j := strings.IndexByte(line[i+1:], '"')
+			if j < 0 {
+				return nil, &tokenError{"unterminated string: " + line[i:], line[i:], true}
+			}
+			tok = Token{TokenString, line[i : i+j+2]}
+		} else {
+			_, n := utf8.DecodeRuneInString(line[i+1:])
+			if n == 0 || !strings.HasPrefix(line[i+1+n:], "'") {
+				return nil, &tokenError{"bad character literal: " + line[i:], line[i:], true}
+			}
+			tok = Token{TokenNumber, line[i : i+n+2]}
+		}
+		tokens, err := tokenizeWords(line[:i])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, tokens...)
+		out = append(out, tok)
+		line = line[i+len(tok.str):]
+	}
+	tokens, err := tokenizeWords(line)
+	return append(out, tokens...), err
+}
+
+// tokenError is the error of tokenize for a line that is not made of tokens.
+type tokenError struct {
+	msg    string
+	text   string // the text that is not a token
+	suffix bool   // whether text runs to the end of the line
+}
+
+func (e *tokenError) Error() string {
+	return e.msg
+}
+
+// tokenizeWords tokenizes line, which holds no strings.
+func tokenizeWords(line string) ([]Token, error) {
 	var out []Token
 	line = strings.Split(line, "/")[0]
 	line = strings.ReplaceAll(line, ",", " , ")
@@ -221,8 +1027,10 @@ func tokenize(line string) ([]Token, error) {
 			out = append(out, Token{TokenIdentifier, s})
 		case TokenComma(s):
 			out = append(out, Token{TokenComma, s})
+		case TokenExpr(s):
+			out = append(out, Token{TokenExpr, s})
 		default:
-			return nil, fmt.Errorf("bad token: %q", s)
+			return nil, &tokenError{fmt.Sprintf("bad token: %q", s), s, false}
 		}
 	}
 	return out, nil
@@ -0,0 +1,303 @@
+package mary
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssembleEnd(t *testing.T) {
+	p, err := Assemble(strings.NewReader("Load X\nHalt\nX, DEC 5\nEND\nthis is not assembly: 12 + 5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Word{0x1002, 0x7000, 5}; !reflect.DeepEqual(p.Words, want) {
+		t.Errorf("Words = %04x, want %04x", p.Words, want)
+	}
+}
+
+func TestAssembleEqu(t *testing.T) {
+	p, err := Assemble(strings.NewReader("Port EQU 0FF\nLoad Port\nStore Port\nHalt\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Word{0x10FF, 0x20FF, 0x7000}; !reflect.DeepEqual(p.Words, want) {
+		t.Errorf("Words = %04x, want %04x", p.Words, want)
+	}
+	if p.Consts["Port"] != 0xFF {
+		t.Errorf("Consts = %v, want Port=0x0FF", p.Consts)
+	}
+	for _, src := range []string{"X, EQU 5\n", "X EQU\n", "END 5\n"} {
+		if _, err := Assemble(strings.NewReader(src)); err == nil {
+			t.Errorf("Assemble(%q) succeeded, want error", src)
+		}
+	}
+	_, err = Assemble(strings.NewReader("Load X\nA, Y EQU 3\nX, DEC 7\n"))
+	if want := "syntax: line 2: A, Y EQU 3: EQU cannot be labelled"; !errors.Is(err, ErrBadDirective) || err.Error() != want {
+		t.Errorf("Assemble = %v, want %q", err, want)
+	}
+}
+
+func TestAssembleAsc(t *testing.T) {
+	p, err := Assemble(strings.NewReader("Load Msg\nHalt\nMsg, ASC \"Hi, a/b\" / comment\nEnd, ASCZ \"!\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Word{0x1002, 0x7000, 'H', 'i', ',', ' ', 'a', '/', 'b', '!', 0}
+	if !reflect.DeepEqual(p.Words, want) {
+		t.Errorf("Words = %04x, want %04x", p.Words, want)
+	}
+	if p.Symbols["End"] != 9 {
+		t.Errorf("End = %03x, want 009", p.Symbols["End"])
+	}
+	if p.Line(8) != 3 || p.Line(10) != 4 {
+		t.Errorf("Lines = %v, want the characters on the line of their string", p.Lines)
+	}
+	if want := []bool{false, false, true, true, true, true, true, true, true, true, true}; !reflect.DeepEqual(p.Data, want) {
+		t.Errorf("Data = %v, want %v", p.Data, want)
+	}
+	for _, src := range []string{"ASC \"open\n", "DEC \"1\"\n", "ASC\n"} {
+		if _, err := Assemble(strings.NewReader(src)); err == nil {
+			t.Errorf("Assemble(%q) succeeded, want error", src)
+		}
+	}
+}
+
+func TestAssembleCharLiteral(t *testing.T) {
+	p, err := Assemble(strings.NewReader("Load ' '\nDEC 'A'\nHEX '/' / slash\nSpace EQU ' '\nAdd Space\nDEC '''\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Word{0x1020, 'A', '/', 0x3020, '\''}; !reflect.DeepEqual(p.Words, want) {
+		t.Errorf("Words = %04x, want %04x", p.Words, want)
+	}
+	for _, src := range []string{"DEC 'AB'\n", "DEC '\n", "DEC ''\n"} {
+		if _, err := Assemble(strings.NewReader(src)); err == nil {
+			t.Errorf("Assemble(%q) succeeded, want error", src)
+		}
+	}
+}
+
+func TestAssembleBases(t *testing.T) {
+	p, err := Assemble(strings.NewReader("BIN 1010\nOCT 17\nBIN -1\nDEC 0x10\nDEC 0o17\nLoad 0x00F\nAnd EQU 0x3\nAdd And\nHEX 0B1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Word{10, 15, 0xFFFF, 16, 15, 0x100F, 0x3003, 0x00B1}
+	if !reflect.DeepEqual(p.Words, want) {
+		t.Errorf("Words = %04x, want %04x", p.Words, want)
+	}
+	// Where numbers are hex, 0b is a number, not a prefix.
+	p, err = Assemble(strings.NewReader("Load 0b1\nHEX 0b10\nLoad 0x0b1\nDEC 0b10\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Word{0x10B1, 0x0B10, 0x10B1, 2}; !reflect.DeepEqual(p.Words, want) {
+		t.Errorf("Words = %04x, want %04x", p.Words, want)
+	}
+	for _, src := range []string{"BIN 12\n", "OCT 8\n", "DEC 0b\n", "DEC 0b2\n", "HEX 0o17\n"} {
+		if _, err := Assemble(strings.NewReader(src)); err == nil {
+			t.Errorf("Assemble(%q) succeeded, want error", src)
+		}
+	}
+}
+
+func TestAssembleExpr(t *testing.T) {
+	p, err := Assemble(strings.NewReader(`
+Max	EQU 5
+Twice	EQU Max*2+1
+Start,	Load Table+2
+	Jump Start-1
+	DEC Max*2
+	DEC -Max
+	HEX Table
+	Add Twice
+	DEC Table-Start
+	DEC (Max+1)*-(2)
+Table,	DEC 0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Word{0x100A, 0x9FFF, 10, 0xFFFB, 8, 0x300B, 8, 0xFFF4, 0}
+	if !reflect.DeepEqual(p.Words, want) {
+		t.Errorf("Words = %04x, want %04x", p.Words, want)
+	}
+	if want := []int{0, 1, 4}; !reflect.DeepEqual(p.Relocs, want) {
+		t.Errorf("Relocs = %v, want %v", p.Relocs, want)
+	}
+	for _, src := range []string{"Load X+1\n", "X, DEC X*X\n", "Load 1+\n", "DEC (1+2\n", "DEC 1+2)\n", "A EQU B\nB EQU 1\n", "DEC 40000*2\n"} {
+		if _, err := Assemble(strings.NewReader(src)); err == nil {
+			t.Errorf("Assemble(%q) succeeded, want error", src)
+		}
+	}
+}
+
+func TestAssembleOperandErrors(t *testing.T) {
+	for _, c := range []struct{ src, want string }{
+		{"Halt 5", "Halt takes no operand"},
+		{"Output X", "Output takes no operand"},
+		{"Clear 1 2", "Clear takes no operand"},
+		{"Load", "Load needs an operand"},
+		{"Y, Skipcond", "Skipcond needs an operand"},
+		{"Store X Y", "Store takes one operand"},
+	} {
+		_, err := Assemble(strings.NewReader("X, DEC 0\n" + c.src + "\n"))
+		if want := "syntax: line 2: " + c.src + ": " + c.want; err == nil || err.Error() != want {
+			t.Errorf("Assemble(%q) = %v, want %s", c.src, err, want)
+		}
+	}
+}
+
+func TestAssembleUndefined(t *testing.T) {
+	_, err := Assemble(strings.NewReader("Load X\nHalt\nAdd Y+Z\nT, DEC T+X\nJump T\n"))
+	want := "syntax: line 1: Load X: undefined symbol X\n" +
+		"syntax: line 3: Add Y+Z: undefined symbols Y, Z\n" +
+		"syntax: line 4: T, DEC T+X: undefined symbol X"
+	if _, ok := err.(SyntaxErrors); !ok || err.Error() != want {
+		t.Errorf("Assemble = %v, want\n%s", err, want)
+	}
+	_, err = Assemble(strings.NewReader("A EQU B\nB EQU 1\n"))
+	if want := "syntax: line 1: A EQU B: undefined symbol B; a constant may only use names defined above it"; err == nil || err.Error() != want {
+		t.Errorf("Assemble = %v, want %s", err, want)
+	}
+}
+
+func TestAssembleDuplicate(t *testing.T) {
+	for _, c := range []struct{ src, want string }{
+		{"X, DEC 1\nHalt\nX, DEC 2\n", "syntax: line 3: X, DEC 2: X is already defined at line 1"},
+		{"N EQU 1\nN, DEC 2\n", "syntax: line 2: N, DEC 2: N is already defined at line 1"},
+		{"N, DEC 2\nN EQU 1\n", "syntax: line 2: N EQU 1: N is already defined at line 1"},
+	} {
+		_, err := Assemble(strings.NewReader(c.src))
+		if err == nil || err.Error() != c.want {
+			t.Errorf("Assemble(%q) = %v, want %s", c.src, err, c.want)
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	_, err := Assemble(strings.NewReader("Load X\nHalt 1\nX, DEC 1\nX, DEC 2\nA EQU B\nLoad A\nStore Y\nLoad @\n"))
+	want := "syntax: line 2: Halt 1: Halt takes no operand\n" +
+		"syntax: line 4: X, DEC 2: X is already defined at line 3\n" +
+		"syntax: line 5: A EQU B: undefined symbol B; a constant may only use names defined above it\n" +
+		"syntax: line 7: Store Y: undefined symbol Y\n" +
+		"syntax: line 8: Load @: bad token: \"@\""
+	if _, ok := err.(SyntaxErrors); !ok || err.Error() != want {
+		t.Errorf("Assemble = %v, want\n%s", err, want)
+	}
+}
+
+func TestSyntaxErrorColumn(t *testing.T) {
+	for _, c := range []struct {
+		src      string
+		col      int
+		token    string
+		expected string
+		caret    string
+	}{
+		{"\tHalt 5", 7, "5", "no operand", "\t\tHalt 5\n\t\t     ^ expected no operand\n"},
+		{"Load / later", 5, "", "an operand", "\tLoad / later\n\t    ^ expected an operand\n"},
+		{"X, Store X Y", 12, "Y", "the end of the line", "\tX, Store X Y\n\t           ^ expected the end of the line\n"},
+		{"Load Nope", 6, "Nope", "a defined label or constant", "\tLoad Nope\n\t     ^ expected a defined label or constant\n"},
+		{"LoadX, Load Nope", 13, "Nope", "a defined label or constant", "\tLoadX, Load Nope\n\t            ^ expected a defined label or constant\n"},
+		{"Load 1 @", 8, "@", "", "\tLoad 1 @\n\t       ^\n"},
+		{"Jump .x", 6, ".x", "", "\tJump .x\n\t     ^\n"},
+	} {
+		_, err := Assemble(strings.NewReader(c.src + "\n"))
+		s, ok := err.(SyntaxError)
+		if !ok {
+			t.Errorf("Assemble(%q) = %v, want a SyntaxError", c.src, err)
+			continue
+		}
+		if s.Line() != 1 || s.Column() != c.col || s.Token() != c.token || s.Expected() != c.expected {
+			t.Errorf("Assemble(%q): line %d column %d token %q expected %q, want line 1 column %d token %q expected %q",
+				c.src, s.Line(), s.Column(), s.Token(), s.Expected(), c.col, c.token, c.expected)
+		}
+		if got := s.Caret(); got != c.caret {
+			t.Errorf("Assemble(%q).Caret() = %q, want %q", c.src, got, c.caret)
+		}
+	}
+	if _, err := Assemble(strings.NewReader("HEX \"1\"\n")); err.(SyntaxError).Caret() != "" {
+		t.Errorf("Caret of an error about no token = %q, want \"\"", err.(SyntaxError).Caret())
+	}
+}
+
+func TestSyntaxErrorKind(t *testing.T) {
+	for _, c := range []struct {
+		src  string
+		kind error
+	}{
+		{"Load \"x\n", ErrBadToken},
+		{"Load @\n", ErrBadToken},
+		{"Lod X\nX, DEC 1\n", ErrIllegalOpcode},
+		{"X DEC 1\n", ErrBadStatement},
+		{"Halt 1\n", ErrBadOperand},
+		{"Load\n", ErrBadOperand},
+		{"Load X\n", ErrUndefinedSymbol},
+		{"X, DEC 1\nX, DEC 2\n", ErrDuplicateSymbol},
+		{"DEC 70000\n", ErrValueOutOfRange},
+		{"N EQU 8000\nDEC N*2\n", ErrValueOutOfRange},
+		{"ORG 2000\n", ErrValueOutOfRange},
+		{"Halt\nORG 100\n", ErrBadDirective},
+		{"ENDM\n", ErrBadDirective},
+		{"INCLUDE \"does-not-exist.mas\"\n", fs.ErrNotExist},
+	} {
+		_, err := Assemble(strings.NewReader(c.src))
+		var s SyntaxError
+		if !errors.Is(err, c.kind) || !errors.As(err, &s) {
+			t.Errorf("Assemble(%q) = %v, want a SyntaxError of kind %v", c.src, err, c.kind)
+		}
+	}
+	_, err := Assemble(strings.NewReader("Load X\nHalt 1\n"))
+	if !errors.Is(err, ErrUndefinedSymbol) || !errors.Is(err, ErrBadOperand) || errors.Is(err, ErrBadToken) {
+		t.Errorf("Assemble = %v, want SyntaxErrors of kinds ErrUndefinedSymbol and ErrBadOperand", err)
+	}
+
+	dir := writeFiles(t, map[string]string{"bad.mas": "Load X\n"})
+	f, err := os.Open(filepath.Join(dir, "bad.mas"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	err = new(Machine).Load(f)
+	var s SyntaxError
+	if !errors.Is(err, ErrUndefinedSymbol) || !errors.As(err, &s) || s.Line() != 1 {
+		t.Errorf("Load = %v, want the SyntaxError of line 1", err)
+	}
+}
+
+func TestPragmas(t *testing.T) {
+	p, err := Assemble(strings.NewReader(`Load X	/ mary:allow self-modify jump-data
+/ mary:allow data-exec
+/ not a pragma: mary:allow
+	Store X
+Halt	/ mary:noreturn
+/ mary:frobnicate 1 2
+X,	DEC 0	/ mary:
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		line int
+		name string
+		want []Pragma
+	}{
+		{1, "allow", []Pragma{{1, "allow", []string{"self-modify", "jump-data"}}}},
+		{2, "allow", nil},
+		{4, "allow", []Pragma{{4, "allow", []string{"data-exec"}}}},
+		{5, "noreturn", []Pragma{{5, "noreturn", []string{}}}},
+		{5, "allow", nil},
+		{7, "frobnicate", []Pragma{{7, "frobnicate", []string{"1", "2"}}}},
+		{7, "", nil},
+	} {
+		if got := p.PragmasAt(c.line, c.name); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("PragmasAt(%d, %q) = %v, want %v", c.line, c.name, got, c.want)
+		}
+	}
+}
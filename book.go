@@ -0,0 +1,194 @@
+package mary
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// BookExample is a worked example from chapter 4 of the book, with what the book says running it does.
+type BookExample struct {
+	Name   string
+	Source string
+
+	// Trace is the registers the book tabulates after each of the first instructions executed.
+	// It is nil for examples the book gives no trace of.
+	Trace []BookStep
+
+	// Want holds the values the book gives for labelled words once the program halts.
+	Want map[string]Word
+}
+
+// BookStep is the registers the book shows after an instruction has executed.
+type BookStep struct {
+	PC, IR, MAR, MBR, AC Word
+}
+
+// BookExamples are the example programs of chapter 4. The book traces the register transfers of
+// the first three instructions of the addition program; the other examples are checked by their results.
+var BookExamples = []BookExample{
+	{
+		Name: "Add two numbers",
+		Source: `/ The program of section 4.8, as it is laid out in memory.
+	ORG 100
+	Load 104
+	Add 105
+	Store 106
+	Halt
+	DEC 35	/ 0023
+	DEC -23	/ FFE9
+Sum,	DEC 0	/ 0000
+	END
+`,
+		Trace: []BookStep{
+			{PC: 0x101, IR: 0x1104, MAR: 0x104, MBR: 0x0023, AC: 0x0023},
+			{PC: 0x102, IR: 0x3105, MAR: 0x105, MBR: 0xFFE9, AC: 0x000C},
+			{PC: 0x103, IR: 0x2106, MAR: 0x106, MBR: 0x000C, AC: 0x000C},
+		},
+		Want: map[string]Word{"Sum": 0x000C},
+	},
+	{
+		Name: "If/Else",
+		Source: `/ Example 4.1: if X = Y then X := X * 2 else Y := Y - X
+	ORG 100
+If,	Load X		/ Load the first value
+	Subt Y		/ Subtract the value of Y, store result in AC
+	Skipcond 400	/ If AC = 0, skip the next instruction
+	Jump Else	/ Jump to the Else part if AC is not equal to 0
+Then,	Load X		/ Reload X so it can be doubled
+	Add X		/ Double X
+	Store X		/ Store the new value
+	Jump Endif	/ Skip over the Else part to the end of the If
+Else,	Load Y		/ Start the Else part by loading Y
+	Subt X		/ Subtract X from Y
+	Store Y		/ Store Y - X in Y
+Endif,	Halt		/ Terminate the program
+X,	DEC 12
+Y,	DEC 20
+	END
+`,
+		Want: map[string]Word{"X": 12, "Y": 8},
+	},
+	{
+		Name: "Loop",
+		Source: `/ Example 4.2: add the five numbers that follow the program.
+	ORG 100
+	Load Addr	/ Load the address of the first number to be added
+	Store Next	/ Store this address as our Next pointer
+	Load Num	/ Load the number of items to be added
+	Subt One	/ Decrement
+	Store Ctr	/ Store this value in Ctr to control looping
+Loop,	Load Sum	/ Load the Sum into AC
+	AddI Next	/ Add the value pointed to by location Next
+	Store Sum	/ Store this sum
+	Load Next	/ Load Next
+	Add One		/ Increment by one to point to the next address
+	Store Next	/ Store in our pointer Next
+	Load Ctr	/ Load the loop control variable
+	Subt One	/ Subtract one from the loop control variable
+	Store Ctr	/ Store this new value in the loop control variable
+	Skipcond 000	/ If the control variable < 0, skip the next instruction
+	Jump Loop	/ Otherwise, go to Loop
+	Halt		/ Terminate the program
+Addr,	HEX 117		/ Numbers to be summed start at location 117
+Next,	HEX 0		/ A pointer to the next number to add
+Num,	DEC 5		/ The number of values to add
+Sum,	DEC 0		/ The sum
+Ctr,	HEX 0		/ The loop control variable
+One,	DEC 1		/ Used to increment and decrement by 1
+	DEC 10		/ The values to be added together
+	DEC 15
+	DEC 20
+	DEC 25
+	DEC 30
+	END
+`,
+		Want: map[string]Word{"Sum": 100, "Next": 0x11C, "Ctr": 0xFFFF},
+	},
+	{
+		Name: "Subroutine",
+		Source: `/ Example 4.3: double two numbers with a subroutine.
+	ORG 100
+	Load X		/ Load the first number to be doubled
+	Store Temp	/ Use Temp as a parameter to pass the value to Subr
+	JnS Subr	/ Store the return address, and jump to the procedure
+	Store X		/ Store the first number, doubled
+	Load Y		/ Load the second number to be doubled
+	Store Temp	/ Use Temp as a parameter to pass the value to Subr
+	JnS Subr	/ Store the return address, and jump to the procedure
+	Store Y		/ Store the second number, doubled
+	Halt		/ End the program
+X,	DEC 20
+Y,	DEC 48
+Temp,	DEC 0
+Subr,	HEX 0		/ Store the return address here
+	Load Temp	/ Subroutine to double numbers
+	Add Temp
+	JumpI Subr
+	END
+`,
+		Want: map[string]Word{"X": 40, "Y": 96},
+	},
+}
+
+// CheckBook runs every example in BookExamples and returns an error describing each
+// trace step and result that differs from the book.
+func CheckBook() []error {
+	var errs []error
+	for _, ex := range BookExamples {
+		errs = append(errs, ex.check()...)
+	}
+	return errs
+}
+
+// bookMaxSteps bounds the run of an example, so that a broken instruction cannot loop forever.
+const bookMaxSteps = 10000
+
+func (ex BookExample) check() []error {
+	p, err := Assemble(strings.NewReader(ex.Source))
+	if err != nil {
+		return []error{fmt.Errorf("%s: %v", ex.Name, err)}
+	}
+	m := &Machine{Stdin: strings.NewReader(""), Stdout: io.Discard, MaxSteps: bookMaxSteps}
+	if err := m.LoadProgram(p); err != nil {
+		return []error{fmt.Errorf("%s: %v", ex.Name, err)}
+	}
+	var errs []error
+	for i, want := range ex.Trace {
+		if _, err := m.Step(); err != nil {
+			return append(errs, fmt.Errorf("%s: step %d: %v", ex.Name, i+1, err))
+		}
+		got := BookStep{m.PC, m.IR, m.MAR, m.MBR, m.AC}
+		if got != want {
+			errs = append(errs, fmt.Errorf("%s: step %d: %s, want %s", ex.Name, i+1, got, want))
+		}
+	}
+	if err := m.Run(); err != nil {
+		return append(errs, fmt.Errorf("%s: %v", ex.Name, err))
+	}
+	for _, label := range sortedLabels(ex.Want) {
+		addr, ok := p.Symbols[label]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: undefined label %s", ex.Name, label))
+			continue
+		}
+		if got, want := m.peek(addr), ex.Want[label]; got != want {
+			errs = append(errs, fmt.Errorf("%s: %s = %04X, want %04X", ex.Name, label, got, want))
+		}
+	}
+	return errs
+}
+
+func (s BookStep) String() string {
+	return fmt.Sprintf("PC=%03X IR=%04X MAR=%03X MBR=%04X AC=%04X", s.PC, s.IR, s.MAR, s.MBR, s.AC)
+}
+
+func sortedLabels(m map[string]Word) []string {
+	var labels []string
+	for label := range m {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
@@ -0,0 +1,9 @@
+package mary
+
+import "testing"
+
+func TestBook(t *testing.T) {
+	for _, err := range CheckBook() {
+		t.Error(err)
+	}
+}
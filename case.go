@@ -0,0 +1,105 @@
+package mary
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// split returns the lines of the source raw, with their mnemonics and directives in the
+// assembler's spelling if a.IgnoreCase is set.
+func (a *Assembler) split(raw []byte) []string {
+	lines := strings.Split(string(raw), "\n")
+	if a.IgnoreCase {
+		for i, line := range lines {
+			lines[i] = foldKeywords(line)
+		}
+	}
+	return lines
+}
+
+// keywords maps the lowercase spelling of each mnemonic and directive to its spelling.
+var keywords = func() map[string]string {
+	out := make(map[string]string)
+	for s := range opcode {
+		out[strings.ToLower(s)] = s
+	}
+	for _, s := range []string{"DEC", "HEX", "OCT", "BIN", "ORG", "END", "EQU", "ASC", "ASCZ", "MACRO", "ENDM",
+		"INCLUDE", "IFDEF", "IFNDEF", "IFEQ", "ELSE", "ENDIF"} {
+		out[strings.ToLower(s)] = s
+	}
+	return out
+}()
+
+// wordRE matches the words of a line that may be a mnemonic or directive, and the names
+// that cannot, so that the end of Main.end is left alone.
+var wordRE = regexp.MustCompile(`[.A-Za-z0-9]+`)
+
+// foldKeywords returns line with each mnemonic and directive, in whatever case, spelled as the
+// assembler expects. Strings, characters and comments are left as they are.
+func foldKeywords(line string) string {
+	var b strings.Builder
+	for line != "" {
+		i := strings.IndexAny(line, `/"'`)
+		if i < 0 {
+			i = len(line)
+		}
+		b.WriteString(wordRE.ReplaceAllStringFunc(line[:i], func(w string) string {
+			if k, ok := keywords[strings.ToLower(w)]; ok {
+				return k
+			}
+			return w
+		}))
+		line = line[i:]
+		if line == "" || line[0] == '/' {
+			b.WriteString(line)
+			break
+		}
+		// Copy the string or character; if it is unterminated tokenize reports it.
+		n := len(line)
+		if line[0] == '"' {
+			if j := strings.IndexByte(line[1:], '"'); j >= 0 {
+				n = j + 2
+			}
+		} else {
+			_, size := utf8.DecodeRuneInString(line[1:])
+			if 2+size <= len(line) {
+				n = 2 + size
+			}
+		}
+		b.WriteString(line[:n])
+		line = line[n:]
+	}
+	return b.String()
+}
+
+// atomRE matches the numbers and names of an expression whole, as operandParser reads them
+// between its operators and parentheses, so that the name FF is not found in the number 0FF.
+var atomRE = regexp.MustCompile(`[.A-Za-z0-9]+`)
+
+// foldLabels replaces each name in tokens with its first spelling in spelling, ignoring case,
+// recording the names it has not seen.
+func foldLabels(tokens []Token, spelling map[string]string) []Token {
+	fold := func(name string) string {
+		lower := strings.ToLower(name)
+		if s, ok := spelling[lower]; ok {
+			return s
+		}
+		spelling[lower] = name
+		return name
+	}
+	out := make([]Token, len(tokens))
+	for i, t := range tokens {
+		out[i] = t
+		switch hashTokens([]Token{t}) {
+		case hashTokenTypes(TokenIdentifier), hashTokenTypes(TokenExpr):
+			out[i].str = atomRE.ReplaceAllStringFunc(t.str, func(atom string) string {
+				if TokenIdentifier(atom) {
+					return fold(atom)
+				}
+				return atom
+			})
+		}
+	}
+	return out
+}
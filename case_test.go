@@ -0,0 +1,56 @@
+package mary
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssembleIgnoreCase(t *testing.T) {
+	src := "load x / load HALT\nOUTPUT\nhalt\nX, dec 5\nMsg, asc \"halt\"\nend\nnot assembled\n"
+	if _, err := Assemble(strings.NewReader(src)); err == nil {
+		t.Errorf("Assemble succeeded without IgnoreCase, want error")
+	}
+	a := &Assembler{IgnoreCase: true}
+	if _, err := a.Assemble(strings.NewReader(src)); err == nil || !strings.HasPrefix(err.Error(), "syntax: line 1: ") {
+		t.Errorf("Assemble with IgnoreCase = %v, want x undefined on line 1", err)
+	}
+	a.IgnoreLabelCase = true
+	p, err := a.Assemble(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Word{0x1003, 0x6000, 0x7000, 5, 'h', 'a', 'l', 't'}
+	if !reflect.DeepEqual(p.Words, want) {
+		t.Errorf("Words = %04x, want %04x", p.Words, want)
+	}
+	if _, ok := p.Symbols["x"]; !ok || len(p.Symbols) != 2 {
+		t.Errorf("Symbols = %v, want x, as first spelled, and Msg", p.Symbols)
+	}
+}
+
+func TestAssembleIgnoreLabelCase(t *testing.T) {
+	a := &Assembler{IgnoreLabelCase: true}
+	p, err := a.Assemble(strings.NewReader("Main, Load count\n.loop, Jump main.LOOP+1\nCount, DEC 1\nMAX EQU 2\nDEC max\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Word{0x1002, 0x9002, 1, 2}
+	if !reflect.DeepEqual(p.Words, want) {
+		t.Errorf("Words = %04x, want %04x", p.Words, want)
+	}
+	if _, ok := p.Symbols["count"]; !ok {
+		t.Errorf("Symbols = %v, want count as first spelled", p.Symbols)
+	}
+	// Names in parentheses, as macros put their expression arguments, are folded too.
+	p, err = a.Assemble(strings.NewReader("Load (foo+1)*2\nHalt\nFOO, DEC 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Word{0x1006, 0x7000, 0}; !reflect.DeepEqual(p.Words, want) {
+		t.Errorf("Words = %04x, want %04x", p.Words, want)
+	}
+	if _, err := Assemble(strings.NewReader("Load count\nCount, DEC 1\n")); err == nil {
+		t.Errorf("labels differing in case are the same without IgnoreLabelCase")
+	}
+}
@@ -0,0 +1,61 @@
+package mary
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a machine's source of time, used for its Deadline.
+// Swapping the clock lets tests and simulations advance time deterministically.
+type Clock interface {
+	Now() time.Time
+
+	// Tick is called after every instruction the machine executes.
+	Tick()
+}
+
+// RealClock is a Clock that follows the wall clock. It is the clock of a Machine with a nil Clock.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) Tick() {}
+
+// VirtualClock is a Clock that advances by CycleTime for every instruction executed,
+// so time is a function of the work done rather than how fast the host is.
+type VirtualClock struct {
+	CycleTime time.Duration
+	t         time.Time
+}
+
+func (c *VirtualClock) Now() time.Time {
+	return c.t
+}
+
+func (c *VirtualClock) Tick() {
+	c.t = c.t.Add(c.CycleTime)
+}
+
+// ManualClock is a Clock that only moves when Advance is called.
+// It is safe to advance from another goroutine while the machine runs.
+type ManualClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *ManualClock) Tick() {}
+
+// Advance moves the clock forward by d.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bbriano/mary"
+)
+
+// outputFormats maps the extension of an output file to the format mary assemble writes.
+// Files with other extensions are object files.
+var outputFormats = map[string]func(io.Writer, mary.Program) error{
+	".srec": mary.WriteSRecord,
+	".s19":  mary.WriteSRecord,
+	".mem":  mary.WriteMemh,
+	".vhd":  mary.WriteVHDL,
+}
+
+// assemble assembles a program to an object file, which mary runs without assembling it again,
+// or to the format its extension names.
+func assemble(args []string) error {
+	fs := flag.NewFlagSet("assemble", flag.ContinueOnError)
+	af := addAssemblerFlags(fs)
+	out := fs.String("o", "", "write the object to `file`, or S-records for .srec or .s19, $readmemh hex for .mem or VHDL for .vhd (default the source's name with .mo)")
+	strip := fs.Bool("strip", false, "leave the labels out of the object")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: mary assemble [flags] file...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	file := fs.Arg(0)
+	a, err := af.assembler()
+	if err != nil {
+		return err
+	}
+	p, err := a.AssembleFiles(fs.Args()...)
+	if cerr := af.close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return assemblyError(file, err)
+	}
+	printWarnings(file, p)
+	if err := af.writeReports(p); err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(file, filepath.Ext(file)) + ".mo"
+	}
+	if err := checkOutput(*out, fs.Args()); err != nil {
+		return err
+	}
+	if *strip {
+		p.Symbols = nil
+	}
+	write, ok := outputFormats[filepath.Ext(*out)]
+	if !ok {
+		write = mary.WriteObject
+	}
+	w, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	err = write(w, p)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// checkOutput returns an error if out is one of the files inputs, which writing it would destroy,
+// such as an object file assembled again without -o.
+func checkOutput(out string, inputs []string) error {
+	fo, err := os.Stat(out)
+	if err != nil {
+		return nil // out does not exist yet, so it is no input
+	}
+	for _, in := range inputs {
+		if fi, err := os.Stat(in); err == nil && os.SameFile(fo, fi) {
+			return fmt.Errorf("%s: the output would overwrite this input; name another with -o", in)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/bbriano/mary"
+)
+
+// benchWorkload is a program that bench runs repeatedly.
+type benchWorkload struct {
+	name string
+	src  string
+}
+
+// benchWorkloads are the programs measured by bench. Changing them makes the history incomparable,
+// so add new workloads rather than editing old ones.
+var benchWorkloads = []benchWorkload{
+	{"countdown", `
+Loop,	Load N
+	Subt One
+	Store N
+	Skipcond 400
+	Jump Loop
+	Halt
+N,	DEC 65535
+One,	DEC 1
+`},
+	{"multiply", `
+Outer,	JnS Mul
+	Load Count
+	Subt One
+	Store Count
+	Skipcond 400
+	Jump Outer
+	Halt
+Mul,	HEX 0
+	Clear
+	Store Prod
+	Load B
+	Store I
+Inner,	Load Prod
+	Add A
+	Store Prod
+	Load I
+	Subt One
+	Store I
+	Skipcond 400
+	Jump Inner
+	JumpI Mul
+Count,	DEC 1000
+A,	DEC 7
+B,	DEC 100
+I,	DEC 0
+Prod,	DEC 0
+One,	DEC 1
+`},
+	{"copy", `
+Loop,	LoadI Src
+	StoreI Dst
+	Load Src
+	Add One
+	Store Src
+	Load Dst
+	Add One
+	Store Dst
+	Load N
+	Subt One
+	Store N
+	Skipcond 400
+	Jump Loop
+	Halt
+Src,	HEX 100
+Dst,	HEX 800
+N,	HEX 700
+One,	DEC 1
+`},
+}
+
+// benchResult is the measurement of one workload.
+type benchResult struct {
+	Name   string  `json:"name"`
+	Unit   string  `json:"unit"` // what ops counts: steps, or lines for the assembler
+	Ops    int     `json:"ops"`
+	NS     int64   `json:"ns"`
+	Allocs uint64  `json:"allocs"`
+	Rate   float64 `json:"rate"` // ops per second
+}
+
+// benchRun is a line of the history file.
+type benchRun struct {
+	Time    time.Time     `json:"time"`
+	Go      string        `json:"go"`
+	Results []benchResult `json:"results"`
+}
+
+// bench measures the embedded workloads, and the assembler, appending the results to a
+// history file and comparing them with the previous run recorded there.
+func bench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	benchtime := fs.Duration("time", time.Second, "run each workload for at least `duration`")
+	history := fs.String("history", "mary-bench.jsonl", "append results to the JSON Lines history `file` (empty for none)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: mary bench [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	run := benchRun{Time: time.Now().UTC(), Go: runtime.Version()}
+	for _, w := range benchWorkloads {
+		r, err := benchProgram(w, *benchtime)
+		if err != nil {
+			return fmt.Errorf("bench %s: %v", w.name, err)
+		}
+		run.Results = append(run.Results, r)
+	}
+	r, err := benchAssembler(*benchtime)
+	if err != nil {
+		return fmt.Errorf("bench assemble: %v", err)
+	}
+	run.Results = append(run.Results, r)
+
+	var prev *benchRun
+	if *history != "" {
+		var err error
+		prev, err = lastBenchRun(*history)
+		if err != nil {
+			return err
+		}
+	}
+	for _, r := range run.Results {
+		line := fmt.Sprintf("%-10s %12d %-5s %14.0f %s/s %8.3f allocs/%s", r.Name, r.Ops, r.Unit, r.Rate, r.Unit, float64(r.Allocs)/float64(r.Ops), strings.TrimSuffix(r.Unit, "s"))
+		if p := prev.result(r.Name); p != nil && p.Rate > 0 {
+			line += fmt.Sprintf(" %+6.1f%%", (r.Rate/p.Rate-1)*100)
+		}
+		fmt.Println(line)
+	}
+	if *history == "" {
+		return nil
+	}
+	return appendBenchRun(*history, run)
+}
+
+// benchProgram runs w repeatedly for at least d.
+func benchProgram(w benchWorkload, d time.Duration) (benchResult, error) {
+	p, err := mary.Assemble(strings.NewReader(w.src))
+	if err != nil {
+		return benchResult{}, err
+	}
+	m := &mary.Machine{Stdout: io.Discard}
+	if err := m.LoadProgram(p); err != nil {
+		return benchResult{}, err
+	}
+	r := benchResult{Name: w.name, Unit: "steps"}
+	err = measure(&r, d, func() (int, error) {
+		m.ResetKeepProgram()
+		err := m.Run()
+		return m.Steps, err
+	})
+	return r, err
+}
+
+// benchAssembler assembles a large generated program repeatedly for at least d.
+func benchAssembler(d time.Duration) (benchResult, error) {
+	var src strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&src, "L%d,\tLoad X%d\t/ load\n\tAdd One\n\tStore X%d\nX%d,\tDEC %d\n", i, i, i, i, i)
+	}
+	src.WriteString("One,\tDEC 1\n")
+	text := src.String()
+	lines := strings.Count(text, "\n")
+	r := benchResult{Name: "assemble", Unit: "lines"}
+	err := measure(&r, d, func() (int, error) {
+		_, err := mary.Assemble(strings.NewReader(text))
+		return lines, err
+	})
+	return r, err
+}
+
+// measure calls f until d has passed, accumulating the ops it reports and the time and allocations it takes into r.
+func measure(r *benchResult, d time.Duration, f func() (int, error)) error {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for time.Since(start) < d {
+		n, err := f()
+		if err != nil {
+			return err
+		}
+		r.Ops += n
+	}
+	r.NS = time.Since(start).Nanoseconds()
+	runtime.ReadMemStats(&after)
+	r.Allocs = after.Mallocs - before.Mallocs
+	r.Rate = float64(r.Ops) / (float64(r.NS) / 1e9)
+	return nil
+}
+
+// result returns the result of the named workload in run, or nil if there is none.
+func (run *benchRun) result(name string) *benchResult {
+	if run == nil {
+		return nil
+	}
+	for i := range run.Results {
+		if run.Results[i].Name == name {
+			return &run.Results[i]
+		}
+	}
+	return nil
+}
+
+// lastBenchRun returns the last run recorded in the history file, or nil if there is none.
+func lastBenchRun(name string) (*benchRun, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var last *benchRun
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var run benchRun
+		if err := json.Unmarshal(sc.Bytes(), &run); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		last = &run
+	}
+	return last, sc.Err()
+}
+
+func appendBenchRun(name string, run benchRun) error {
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(run)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
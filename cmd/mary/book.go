@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bbriano/mary"
+)
+
+// bookCheck runs the worked examples of chapter 4 and checks that they do what the book says.
+func bookCheck(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("Usage: mary book-check")
+	}
+	errs := mary.CheckBook()
+	for _, err := range errs {
+		fmt.Println("FAIL", err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("book-check: %d failures", len(errs))
+	}
+	fmt.Printf("ok %d examples\n", len(mary.BookExamples))
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bbriano/mary"
+)
+
+// conformance checks mary's instructions against the table of reference semantics.
+func conformance(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("Usage: mary conformance")
+	}
+	errs := mary.CheckReference()
+	for _, err := range errs {
+		fmt.Println("FAIL", err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("conformance: %d/%d cases failed", len(errs), len(mary.ConformanceCases))
+	}
+	fmt.Printf("ok %d cases\n", len(mary.ConformanceCases))
+	return nil
+}
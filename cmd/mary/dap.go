@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bbriano/mary"
+)
+
+// dap serves the Debug Adapter Protocol on stdin and stdout, for debugging from an editor.
+// The machine flags configure the machine of each program launched.
+func dap(args []string) error {
+	fs := flag.NewFlagSet("dap", flag.ContinueOnError)
+	mf := addMachineFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: mary dap [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	s := &mary.DAPServer{Load: func(path string) (*mary.Machine, error) {
+		return mf.load(path)
+	}}
+	err := s.Serve(os.Stdin, os.Stdout)
+	if cerr := mf.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bbriano/mary"
+)
+
+// debug runs a program under the interactive debugger.
+func debug(args []string) error {
+	fs := flag.NewFlagSet("debug", flag.ContinueOnError)
+	mf := addMachineFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: mary debug [flags] file...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	m, err := mf.load(fs.Args()...)
+	if err != nil {
+		return err
+	}
+	d := &mary.Debugger{M: m, In: os.Stdin, Out: os.Stdout}
+	err = d.Run()
+	if cerr := mf.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
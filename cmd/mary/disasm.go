@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bbriano/mary"
+)
+
+// disasm prints Marie assembly for the machine words in a file, such as a memory dump.
+func disasm(args []string) error {
+	fs := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	origin := fs.String("origin", "", "hex `address` the words are loaded at, unless the file gives it (default 0)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: mary disasm [flags] file")
+		fmt.Fprintln(os.Stderr, "The file (- for stdin) holds hex words. A line may start with the address")
+		fmt.Fprintln(os.Stderr, "of its first word, as in the \"100: 1104 3105\" of Dump and the debugger's x.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	var r io.Reader = os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	start, words, err := readWords(r)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+	if start < 0 {
+		start = 0
+		if *origin != "" {
+			o, err := parseHex(*origin)
+			if err != nil || o >= memoryWords {
+				return fmt.Errorf("-origin: bad address %q", *origin)
+			}
+			start = int(o)
+		}
+	}
+	p := mary.Program{Origin: mary.Word(start), Words: words}
+	if start+len(words) > memoryWords {
+		return fmt.Errorf("%s: the words run past the end of memory", fs.Arg(0))
+	}
+	fmt.Print(p.Disassemble())
+	return nil
+}
+
+// readWords reads the hex words of r, returning the address of the first, or -1 if r does not
+// give it. Words are separated by spaces and newlines, and a line may start with the address of its
+// first word followed by a colon. Addresses must follow on from the words before them.
+func readWords(r io.Reader) (int, []mary.Word, error) {
+	start := -1
+	var words []mary.Word
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(strings.Split(sc.Text(), "/")[0])
+		if addr, rest, ok := strings.Cut(line, ":"); ok {
+			a, err := parseHex(strings.TrimSpace(addr))
+			switch {
+			case err != nil:
+				return 0, nil, fmt.Errorf("line %d: bad address %q", n, addr)
+			case start < 0 && len(words) == 0:
+				start = int(a)
+			case start < 0 || int(a) != start+len(words):
+				return 0, nil, fmt.Errorf("line %d: address %s does not follow on from the words above", n, addr)
+			}
+			line = rest
+		}
+		for _, f := range strings.Fields(line) {
+			w, err := parseHex(f)
+			if err != nil {
+				return 0, nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			words = append(words, w)
+		}
+	}
+	return start, words, sc.Err()
+}
@@ -0,0 +1,633 @@
+// Mary is a simulation of the Marie machine described in chapter 4 of
+// "Computer Organization and Architecture" by Linda Null and Julia Lobur.
+//
+// Usage:
+//
+//	mary [run] [flags] file...
+//	mary debug [flags] file...
+//	mary replay [flags] trace file...
+//	mary replay-io [flags] session file...
+//	mary conformance
+//	mary book-check
+//	mary stress [flags]
+//	mary bench [flags]
+//	mary map [flags] file...
+//	mary disasm [flags] file
+//	mary assemble [flags] file...
+//	mary vet [flags] file...
+//	mary dap [flags]
+//	mary tui [flags] file...
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bbriano/mary"
+)
+
+// commands maps subcommand names to their implementations.
+// Each is given the arguments following its name.
+var commands = map[string]func(args []string) error{
+	"run":         run,
+	"debug":       debug,
+	"replay":      replay,
+	"replay-io":   replayIO,
+	"conformance": conformance,
+	"book-check":  bookCheck,
+	"stress":      stress,
+	"bench":       bench,
+	"map":         memoryMap,
+	"disasm":      disasm,
+	"assemble":    assemble,
+	"vet":         vet,
+	"dap":         dap,
+	"tui":         tui,
+}
+
+func main() {
+	args := os.Args[1:]
+	cmd := run
+	if len(args) > 0 {
+		if c, ok := commands[args[0]]; ok {
+			cmd = c
+			args = args[1:]
+		}
+	}
+	err := cmd(args)
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	var status exitStatus
+	if errors.As(err, &status) {
+		os.Exit(int(status))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	mf := addMachineFlags(fs)
+	var expects listFlag
+	fs.Var(&expects, "expect", "check `expr=value` once the program halts, as in M[Result]=50 (repeatable)")
+	exitAC := fs.Bool("exit-ac", false, "exit with the low byte of AC as the status once the program halts")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: mary [run] [flags] file...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	m, err := mf.load(fs.Args()...)
+	if err != nil {
+		return err
+	}
+	var checks []*mary.Expectation
+	for _, src := range expects {
+		e, err := mary.ParseExpectation(src, m.Program().Symbols)
+		if err != nil {
+			return err
+		}
+		checks = append(checks, e)
+	}
+	err = m.Run()
+	if cerr := mf.close(); err == nil {
+		err = cerr
+	}
+	if err == nil && len(checks) > 0 {
+		err = check(m, checks)
+	}
+	if err == nil && *exitAC {
+		err = acStatus(m)
+	}
+	return err
+}
+
+// acStatus returns the exitStatus of -exit-ac for the halted machine m: the low byte of AC,
+// or nil if it is 0.
+func acStatus(m *mary.Machine) error {
+	if m.AC&0xFF == 0 {
+		return nil
+	}
+	return exitStatus(m.AC & 0xFF)
+}
+
+// exitStatus is an error that makes mary exit with the status it holds, and print nothing.
+type exitStatus int
+
+func (s exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
+
+// check reports on stderr whether each expectation holds for the halted machine m.
+func check(m *mary.Machine, checks []*mary.Expectation) error {
+	failed := 0
+	for _, e := range checks {
+		if err := e.Check(m); err != nil {
+			fmt.Fprintln(os.Stderr, "FAIL", err)
+			failed++
+		} else {
+			fmt.Fprintln(os.Stderr, "ok", e)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("expect: %d/%d failed", failed, len(checks))
+	}
+	return nil
+}
+
+// machineFlags are the flags that configure a machine, shared by the commands that run programs.
+type machineFlags struct {
+	maxSteps  *int
+	timeout   *time.Duration
+	eof       *string
+	prompt    *string
+	quiet     *bool
+	trace     *bool
+	jsonFile  *string
+	input     *string
+	recordIO  *string
+	events    *string
+	format    *string
+	output    *string
+	inputMode *string
+	args      listFlag
+	data      listFlag
+	asm       *assemblerFlags
+
+	// closers release what load set up, such as trace files, once the machine has finished.
+	closers []func() error
+}
+
+func addMachineFlags(fs *flag.FlagSet) *machineFlags {
+	mf := &machineFlags{
+		maxSteps:  fs.Int("max-steps", 0, "stop after executing `n` instructions (0 for no limit)"),
+		timeout:   fs.Duration("timeout", 0, "stop after running for `duration` (0 for no limit)"),
+		eof:       fs.String("eof", "fault", "what Input does at end of input: fault, trap to stop before the Input as at a breakpoint, or a hex `value` to load"),
+		prompt:    fs.String("prompt", "> ", "`text` printed before Input reads from a terminal"),
+		quiet:     fs.Bool("quiet", false, "never print the Input prompt"),
+		trace:     fs.Bool("trace", false, "print a line for every instruction executed to stderr: its address, word, mnemonic and operand, and AC and PC after it"),
+		jsonFile:  fs.String("trace-json", "", "write a JSON Lines trace of every instruction to `file` (- for stderr)"),
+		input:     fs.String("input", "", "read the values for Input from the script `file` instead of stdin"),
+		recordIO:  fs.String("record-io", "", "record every value input and output to the session `file` (- for stderr)"),
+		events:    fs.String("events", "", "write a JSON Lines timeline of the run to `file` (- for stderr), or POST it to an http(s) URL"),
+		format:    fs.String("format", "default", "print numbers in `profile` book (00FF), signed (-1) or c (0x00ff); default is book, but Dump prints its registers in decimal"),
+		inputMode: fs.String("input-mode", "hex", "read Input from stdin in `mode` hex (1F), dec (31) or ascii (a character)"),
+		output:    fs.String("output", "hex", "print Output values in `mode` hex (fffe), dec (-2), udec (65534) or ascii (a character)"),
+	}
+	mf.asm = addAssemblerFlags(fs)
+	fs.Var(&mf.data, "data", "fill memory from the image `file[@addr]` before running: $readmemh hex, or big-endian words if it ends in .bin, placed from the hex addr, or 0 (repeatable)")
+	fs.Var(&mf.args, "arg", "set the word at `loc=value` before running, loc a label or hex address and value a hex word (repeatable)")
+	return mf
+}
+
+// listFlag is a flag that may be repeated, collecting every value.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// setArgs writes the -arg loc=value arguments to m. Like an operand, loc is a label if it
+// starts with a letter and otherwise a hex address; value is a hex word, as Input reads it.
+// Either may have a 0x prefix.
+func setArgs(m *mary.Machine, args []string) error {
+	for _, arg := range args {
+		loc, value, ok := strings.Cut(arg, "=")
+		if !ok || loc == "" {
+			return fmt.Errorf("-arg %s: want label=value or address=value", arg)
+		}
+		w, err := parseHex(value)
+		if err != nil {
+			return fmt.Errorf("-arg %s: %v", arg, err)
+		}
+		if mary.TokenIdentifier(loc) {
+			err = m.SetSymbol(loc, w)
+		} else if addr, aerr := parseAddr(loc); aerr != nil {
+			err = aerr
+		} else {
+			err = m.WriteMemory(addr, []mary.Word{w})
+		}
+		if err != nil {
+			return fmt.Errorf("-arg %s: %v", arg, err)
+		}
+	}
+	return nil
+}
+
+// loadData writes the memory image named by the -data argument spec, file or file@addr, to m.
+// Like the addresses of -arg, addr is hex.
+func loadData(m *mary.Machine, spec string) error {
+	file, start := spec, mary.Word(0)
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		addr, err := parseAddr(spec[i+1:])
+		if err != nil {
+			return fmt.Errorf("-data %s: %v", spec, err)
+		}
+		file, start = spec[:i], addr
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("-data: %v", err)
+	}
+	var blocks []mary.MemoryBlock
+	if filepath.Ext(file) == ".bin" {
+		if len(b)%2 != 0 {
+			return fmt.Errorf("-data %s: odd number of bytes, want 16-bit words", spec)
+		}
+		words := make([]mary.Word, len(b)/2)
+		for i := range words {
+			words[i] = mary.Word(binary.BigEndian.Uint16(b[2*i:]))
+		}
+		blocks = []mary.MemoryBlock{{Addr: start, Words: words}}
+	} else if blocks, err = mary.ReadMemh(bytes.NewReader(b), start); err != nil {
+		return fmt.Errorf("-data %s: %v", spec, err)
+	}
+	for _, block := range blocks {
+		if err := m.WriteMemory(block.Addr, block.Words); err != nil {
+			return fmt.Errorf("-data %s: %v", spec, err)
+		}
+	}
+	return nil
+}
+
+// assemblerFlags are the flags that configure the assembler.
+type assemblerFlags struct {
+	defines         listFlag
+	ignoreCase      *bool
+	ignoreLabelCase *bool
+	strict          *bool
+	listing         *string
+	symbols         *string
+	xref            *string
+
+	listingFile *bufferedFile // opened by assembler, closed by close
+}
+
+func addAssemblerFlags(fs *flag.FlagSet) *assemblerFlags {
+	af := &assemblerFlags{
+		ignoreCase:      fs.Bool("ignore-case", false, "accept mnemonics and directives in any case, such as load or HALT"),
+		ignoreLabelCase: fs.Bool("ignore-label-case", false, "treat labels that differ only in case as the same label"),
+		strict:          fs.Bool("strict", false, "fail on assembler warnings, such as unused labels, as if they were errors"),
+		listing:         fs.String("listing", "", "write a listing of each address, word and source line to `file` (- for stderr)"),
+		symbols:         fs.String("symbols", "", "write the labels and constants to `file` (- for stderr), as JSON if it ends in .json"),
+		xref:            fs.String("xref", "", "write the lines defining and using each label and constant to `file` (- for stderr)"),
+	}
+	fs.Var(&af.defines, "D", "define `name` or name=value for IFDEF and IFEQ, value hex (repeatable)")
+	return af
+}
+
+// assembler returns the assembler configured by the flags, creating the -listing file,
+// which close closes once the program has been assembled.
+// A -D name without a value is defined as 1.
+func (af *assemblerFlags) assembler() (mary.Assembler, error) {
+	a := mary.Assembler{
+		Defines:         make(map[string]mary.Word),
+		IgnoreCase:      *af.ignoreCase,
+		IgnoreLabelCase: *af.ignoreLabelCase,
+		Strict:          *af.strict,
+	}
+	for _, d := range af.defines {
+		name, value, ok := strings.Cut(d, "=")
+		if !mary.TokenIdentifier(name) {
+			return a, fmt.Errorf("-D %s: want name or name=value", d)
+		}
+		a.Defines[name] = 1
+		if ok {
+			v, err := parseHex(value)
+			if err != nil {
+				return a, fmt.Errorf("-D %s: %v", d, err)
+			}
+			a.Defines[name] = v
+		}
+	}
+	if *af.listing != "" {
+		w, err := create(*af.listing)
+		if err != nil {
+			return a, err
+		}
+		af.listingFile, a.Listing = w, w
+	}
+	return a, nil
+}
+
+// close closes the -listing file, if assembler created one.
+func (af *assemblerFlags) close() error {
+	if af.listingFile == nil {
+		return nil
+	}
+	err := af.listingFile.Close()
+	af.listingFile = nil
+	return err
+}
+
+// writeReports writes the reports on p asked for by the flags: the -symbols and -xref files.
+func (af *assemblerFlags) writeReports(p mary.Program) error {
+	symbols := mary.WriteSymbols
+	if strings.HasSuffix(*af.symbols, ".json") {
+		symbols = mary.WriteSymbolsJSON
+	}
+	for _, r := range []struct {
+		file  string
+		write func(io.Writer, mary.Program) error
+	}{
+		{*af.symbols, symbols},
+		{*af.xref, mary.WriteCrossReference},
+	} {
+		if r.file == "" {
+			continue
+		}
+		w, err := create(r.file)
+		if err != nil {
+			return err
+		}
+		err = r.write(w, p)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printWarnings prints the assembler's warnings about p, the program whose first file is file, to stderr.
+func printWarnings(file string, p mary.Program) {
+	for _, w := range p.Warnings {
+		fmt.Fprintf(os.Stderr, "%s\n%s", inFile(file, w), w.Caret())
+	}
+}
+
+// assemblyError formats the assembler's error err about the program whose first file is file,
+// with a caret under each offending token.
+func assemblyError(file string, err error) error {
+	var errs mary.SyntaxErrors
+	var e mary.SyntaxError
+	switch {
+	case errors.As(err, &errs):
+	case errors.As(err, &e):
+		errs = mary.SyntaxErrors{e}
+	default:
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	var b strings.Builder
+	for _, s := range errs {
+		fmt.Fprintf(&b, "%s\n%s", inFile(file, s), s.Caret())
+	}
+	return errors.New(strings.TrimSuffix(b.String(), "\n"))
+}
+
+// inFile formats err, a SyntaxError or Warning of the program whose first file is file, with
+// the name of its file. Those in other files than the first name their file already.
+func inFile(file string, err interface {
+	error
+	File() string
+}) string {
+	if err.File() != "" {
+		return err.Error()
+	}
+	return file + ": " + err.Error()
+}
+
+// parseHex parses a hex word, which may be negative and may have a 0x prefix.
+func parseHex(s string) (mary.Word, error) {
+	sign, digits := "", s
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	n, err := strconv.ParseInt(sign+strings.TrimPrefix(digits, "0x"), 16, 32)
+	if err != nil || n < -1<<15 || n > 0xFFFF {
+		return 0, fmt.Errorf("bad hex word %q", s)
+	}
+	return mary.Word(n), nil
+}
+
+// parseAddr parses a hex address, which may have a 0x prefix.
+func parseAddr(s string) (mary.Word, error) {
+	n, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 16)
+	if err != nil || n >= memoryWords {
+		return 0, fmt.Errorf("bad address %q", s)
+	}
+	return mary.Word(n), nil
+}
+
+// load returns a machine configured by the flags with the program in files loaded.
+func (mf *machineFlags) load(files ...string) (*mary.Machine, error) {
+	m := new(mary.Machine)
+	err := mf.configure(m)
+	if err != nil {
+		return nil, err
+	}
+	m.Assembler, err = mf.asm.assembler()
+	if err != nil {
+		return nil, err
+	}
+	if *mf.input != "" {
+		f, err := os.Open(*mf.input)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		m.Source, err = mary.ParseInputScript(f)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(files) == 1 {
+		// Load also takes object files.
+		var f *os.File
+		f, err = os.Open(files[0])
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		err = m.Load(f)
+	} else {
+		err = m.LoadFiles(files...)
+	}
+	if cerr := mf.asm.close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	printWarnings(files[0], m.Program())
+	if err := mf.asm.writeReports(m.Program()); err != nil {
+		return nil, err
+	}
+	for _, spec := range mf.data {
+		if err := loadData(m, spec); err != nil {
+			return nil, err
+		}
+	}
+	if err := setArgs(m, mf.args); err != nil {
+		return nil, err
+	}
+	if *mf.trace {
+		t := mary.NewTextTracer(m, os.Stderr)
+		mf.closers = append(mf.closers, t.Close)
+	}
+	if *mf.jsonFile != "" {
+		w, err := create(*mf.jsonFile)
+		if err != nil {
+			return nil, err
+		}
+		t := mary.NewJSONTracer(m, w)
+		mf.closers = append(mf.closers, t.Close, w.Close)
+	}
+	if *mf.recordIO != "" {
+		w, err := create(*mf.recordIO)
+		if err != nil {
+			return nil, err
+		}
+		r := mary.RecordSession(m)
+		mf.closers = append(mf.closers, func() error {
+			r.Close()
+			return mary.WriteSession(w, r.Events)
+		}, w.Close)
+	}
+	if *mf.events != "" {
+		r := mary.RecordTimeline(m)
+		dest := *mf.events
+		mf.closers = append(mf.closers, func() error {
+			r.Close()
+			return writeTimeline(dest, r.Events)
+		})
+	}
+	return m, nil
+}
+
+// configure sets the settings of m given by the flags that need no program or files.
+func (mf *machineFlags) configure(m *mary.Machine) error {
+	var err error
+	m.MaxSteps = *mf.maxSteps
+	if *mf.timeout > 0 {
+		m.Deadline = time.Now().Add(*mf.timeout)
+	}
+	m.Prompt = *mf.prompt
+	m.NoPrompt = *mf.quiet || *mf.prompt == ""
+	m.Format, err = mary.ParseNumberFormat(*mf.format)
+	if err != nil {
+		return fmt.Errorf("-format: %v", err)
+	}
+	m.OutputMode, err = mary.ParseOutputMode(*mf.output)
+	if err != nil {
+		return fmt.Errorf("-output: %v", err)
+	}
+	m.InputMode, err = mary.ParseInputMode(*mf.inputMode)
+	if err != nil {
+		return fmt.Errorf("-input-mode: %v", err)
+	}
+	m.InputEOF, m.Sentinel, err = parseEOF(*mf.eof)
+	if err != nil {
+		return fmt.Errorf("-eof: %v", err)
+	}
+	return nil
+}
+
+// parseEOF parses the -eof argument s: fault, trap, or the hex value of a sentinel.
+func parseEOF(s string) (mary.EOFMode, mary.Word, error) {
+	switch s {
+	case "fault":
+		return mary.EOFFault, 0, nil
+	case "trap":
+		return mary.EOFTrap, 0, nil
+	}
+	v, err := parseHex(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	return mary.EOFSentinel, v, nil
+}
+
+// writeTimeline writes events to the file dest, or posts them to dest if it is an http or https URL.
+func writeTimeline(dest string, events []mary.TimelineEvent) error {
+	if !strings.HasPrefix(dest, "http://") && !strings.HasPrefix(dest, "https://") {
+		w, err := create(dest)
+		if err != nil {
+			return err
+		}
+		if err := mary.WriteTimeline(w, events); err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
+	}
+	var body bytes.Buffer
+	if err := mary.WriteTimeline(&body, events); err != nil {
+		return err
+	}
+	resp, err := http.Post(dest, "application/x-ndjson", &body)
+	if err != nil {
+		return fmt.Errorf("-events: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("-events: %s: %s", dest, resp.Status)
+	}
+	return nil
+}
+
+// close runs the closers in order, returning the first error.
+func (mf *machineFlags) close() error {
+	var first error
+	for _, c := range mf.closers {
+		if err := c(); err != nil && first == nil {
+			first = err
+		}
+	}
+	mf.closers = nil
+	return first
+}
+
+// bufferedFile is a buffered output file. Closing it flushes the buffer.
+type bufferedFile struct {
+	*bufio.Writer
+	f *os.File
+}
+
+// create creates the named output file, or returns a writer to stderr if name is "-".
+func create(name string) (*bufferedFile, error) {
+	if name == "-" {
+		return &bufferedFile{bufio.NewWriter(os.Stderr), nil}, nil
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &bufferedFile{bufio.NewWriter(f), f}, nil
+}
+
+func (b *bufferedFile) Close() error {
+	err := b.Flush()
+	if b.f != nil {
+		if cerr := b.f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
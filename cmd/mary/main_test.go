@@ -0,0 +1,249 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bbriano/mary"
+)
+
+// loadMachine returns a machine with the program src loaded.
+func loadMachine(t *testing.T, src string) *mary.Machine {
+	t.Helper()
+	p, err := mary.Assemble(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := new(mary.Machine)
+	if err := m.LoadProgram(p); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// word returns the word at addr of m.
+func word(t *testing.T, m *mary.Machine, addr mary.Word) mary.Word {
+	t.Helper()
+	ws, err := m.ReadMemory(addr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws[0]
+}
+
+func TestSetArgs(t *testing.T) {
+	const src = "Halt\nX, HEX 0\nff, HEX 0\n"
+	for _, tt := range []struct {
+		arg  string
+		addr mary.Word
+		want mary.Word
+		ok   bool
+	}{
+		{"X=10", 1, 0x10, true},
+		{"X=1a", 1, 0x1A, true},
+		{"X=ff", 1, 0xFF, true},
+		{"X=0xff", 1, 0xFF, true},
+		{"X=-1", 1, 0xFFFF, true},
+		{"ff=2", 2, 2, true},
+		{"0x200=ff", 0x200, 0xFF, true},
+		{"200=ff", 0x200, 0xFF, true},
+		{"0FFF=1", 0xFFF, 1, true},
+		{"X=X", 0, 0, false},
+		{"X=1+1", 0, 0, false},
+		{"X=10000", 0, 0, false},
+		{"Y=1", 0, 0, false},
+		{"1000=1", 0, 0, false},
+		{"0y2=1", 0, 0, false},
+		{"X", 0, 0, false},
+		{"=1", 0, 0, false},
+	} {
+		m := loadMachine(t, src)
+		err := setArgs(m, []string{tt.arg})
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("setArgs(%q) succeeded, want error", tt.arg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("setArgs(%q): %v", tt.arg, err)
+		} else if got := word(t, m, tt.addr); got != tt.want {
+			t.Errorf("setArgs(%q): M[%03x] = %04x, want %04x", tt.arg, tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestLoadData(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"img.hex": "1 2\n@10 3\n",
+		"img.bin": "\x00\x01\xab\xcd",
+		"odd.bin": "\x00",
+		"bad.hex": "xyz\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range []struct {
+		spec string
+		want map[mary.Word]mary.Word // the words written, or nil for an error
+	}{
+		{"img.hex", map[mary.Word]mary.Word{0: 1, 1: 2, 0x10: 3}},
+		{"img.hex@1F0", map[mary.Word]mary.Word{0x1F0: 1, 0x1F1: 2, 0x10: 3}},
+		{"img.bin@0x200", map[mary.Word]mary.Word{0x200: 1, 0x201: 0xABCD}},
+		{"img.bin@200", map[mary.Word]mary.Word{0x200: 1, 0x201: 0xABCD}},
+		{"img.hex@", nil},
+		{"img.hex@x", nil},
+		{"img.hex@1000", nil},
+		{"img.bin@FFF", nil},
+		{"odd.bin", nil},
+		{"bad.hex", nil},
+		{"missing.hex", nil},
+	} {
+		m := new(mary.Machine)
+		err := loadData(m, filepath.Join(dir, tt.spec))
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("loadData(%q) succeeded, want error", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("loadData(%q): %v", tt.spec, err)
+			continue
+		}
+		for addr, w := range tt.want {
+			if got := word(t, m, addr); got != w {
+				t.Errorf("loadData(%q): M[%03x] = %04x, want %04x", tt.spec, addr, got, w)
+			}
+		}
+	}
+}
+
+func TestParseEOF(t *testing.T) {
+	for _, tt := range []struct {
+		arg      string
+		mode     mary.EOFMode
+		sentinel mary.Word
+		ok       bool
+	}{
+		{"fault", mary.EOFFault, 0, true},
+		{"trap", mary.EOFTrap, 0, true},
+		{"0", mary.EOFSentinel, 0, true},
+		{"ffff", mary.EOFSentinel, 0xFFFF, true},
+		{"-1", mary.EOFSentinel, 0xFFFF, true},
+		{"0x1F", mary.EOFSentinel, 0x1F, true},
+		{"", 0, 0, false},
+		{"halt", 0, 0, false},
+		{"10000", 0, 0, false},
+	} {
+		mode, sentinel, err := parseEOF(tt.arg)
+		if tt.ok && (err != nil || mode != tt.mode || sentinel != tt.sentinel) {
+			t.Errorf("parseEOF(%q) = %v, %04x, %v; want %v, %04x", tt.arg, mode, sentinel, err, tt.mode, tt.sentinel)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("parseEOF(%q) succeeded, want error", tt.arg)
+		}
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want mary.Machine // the settings configure sets, or the zero Machine for an error
+	}{
+		{nil, mary.Machine{Prompt: "> "}},
+		{[]string{"-output", "dec", "-input-mode", "ascii"}, mary.Machine{Prompt: "> ", OutputMode: mary.OutputDecimal, InputMode: mary.InputASCII}},
+		{[]string{"-output", "ascii", "-input-mode", "dec"}, mary.Machine{Prompt: "> ", OutputMode: mary.OutputASCII, InputMode: mary.InputDecimal}},
+		{[]string{"-output", "udec", "-eof", "trap"}, mary.Machine{Prompt: "> ", OutputMode: mary.OutputUnsignedDecimal, InputEOF: mary.EOFTrap}},
+		{[]string{"-eof", "-1", "-max-steps", "10", "-quiet"}, mary.Machine{Prompt: "> ", NoPrompt: true, InputEOF: mary.EOFSentinel, Sentinel: 0xFFFF, MaxSteps: 10}},
+		{[]string{"-prompt", "", "-format", "signed"}, mary.Machine{NoPrompt: true, Format: mary.FormatSigned}},
+		{[]string{"-output", "uhex"}, mary.Machine{}},
+		{[]string{"-output", "HEX"}, mary.Machine{}},
+		{[]string{"-input-mode", "bin"}, mary.Machine{}},
+		{[]string{"-eof", "stop"}, mary.Machine{}},
+		{[]string{"-format", "octal"}, mary.Machine{}},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		mf := addMachineFlags(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		m := new(mary.Machine)
+		err := mf.configure(m)
+		if reflect.DeepEqual(tt.want, mary.Machine{}) {
+			if err == nil {
+				t.Errorf("%q: configure succeeded, want error", tt.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: configure: %v", tt.args, err)
+			continue
+		}
+		got := mary.Machine{
+			Prompt: m.Prompt, NoPrompt: m.NoPrompt, Format: m.Format, OutputMode: m.OutputMode, InputMode: m.InputMode,
+			InputEOF: m.InputEOF, Sentinel: m.Sentinel, MaxSteps: m.MaxSteps,
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: configured %+v, want %+v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestACStatus(t *testing.T) {
+	for ac, want := range map[mary.Word]error{0: nil, 0x100: nil, 7: exitStatus(7), 0xFFFF: exitStatus(255), 0x1234: exitStatus(0x34)} {
+		if got := acStatus(&mary.Machine{AC: ac, Halted: true}); got != want {
+			t.Errorf("acStatus with AC=%04x = %v, want %v", ac, got, want)
+		}
+	}
+}
+
+func TestMemoryMap(t *testing.T) {
+	p, err := mary.Assemble(strings.NewReader("ORG 100\nN EQU 103\nLoad X\nHalt\nX, DEC 1\nY, DEC 2\nHEX 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The assembler keeps N out of Symbols, but a constant that is there too is still not a label.
+	p.Symbols["N"] = p.Consts["N"]
+	var b strings.Builder
+	if err := writeMemoryMap(&b, "prog.mas", p); err != nil {
+		t.Fatal(err)
+	}
+	want := "000-0FF  free  256 words\n" +
+		"100-101  code  2 words\n" +
+		"102-104  data  3 words\n" +
+		"\t102  X            1 word\n" +
+		"\t103  Y            2 words\n" +
+		"105-FFF  free  3835 words\n"
+	if b.String() != want {
+		t.Errorf("map:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestCheckOutput(t *testing.T) {
+	dir := t.TempDir()
+	src, obj := filepath.Join(dir, "prog.mas"), filepath.Join(dir, "lib.mo")
+	for _, f := range []string{src, obj} {
+		if err := os.WriteFile(f, []byte("Halt\n"), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inputs := []string{src, obj}
+	for out, ok := range map[string]bool{
+		filepath.Join(dir, "prog.mo"):       true,
+		filepath.Join(dir, "lib.srec"):      true,
+		obj:                                 false,
+		src:                                 false,
+		filepath.Join(dir, ".", "prog.mas"): false,
+	} {
+		if err := checkOutput(out, inputs); (err == nil) != ok {
+			t.Errorf("checkOutput(%q) = %v, want ok %v", out, err, ok)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/bbriano/mary"
+)
+
+// memoryWords is the number of words of a Marie machine's memory.
+const memoryWords = 4096
+
+// memoryMap prints where the assembler places a program in memory: its runs of code and data,
+// the address and size of each data label, and the free space, without running it.
+func memoryMap(args []string) error {
+	fs := flag.NewFlagSet("map", flag.ContinueOnError)
+	af := addAssemblerFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: mary map [flags] file...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	a, err := af.assembler()
+	if err != nil {
+		return err
+	}
+	p, err := a.AssembleFiles(fs.Args()...)
+	if cerr := af.close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return assemblyError(fs.Arg(0), err)
+	}
+	printWarnings(fs.Arg(0), p)
+	if err := af.writeReports(p); err != nil {
+		return err
+	}
+	return writeMemoryMap(os.Stdout, fs.Arg(0), p)
+}
+
+// writeMemoryMap writes the memory map of p, assembled from name, to w.
+func writeMemoryMap(w io.Writer, name string, p mary.Program) error {
+	labels := make(map[int][]string) // index in p.Words to the data labels there
+	for label, addr := range p.Symbols {
+		if _, ok := p.Consts[label]; ok {
+			// A constant is a value, not an address, even one that falls inside the program.
+			continue
+		}
+		labels[int(addr)-int(p.Origin)] = append(labels[int(addr)-int(p.Origin)], label)
+	}
+	for _, l := range labels {
+		sort.Strings(l)
+	}
+
+	start, end := int(p.Origin), int(p.Origin)+len(p.Words)
+	if start > 0 {
+		printRange(w, "free", 0, start)
+	}
+	for i := 0; i < len(p.Words); {
+		j := i + 1
+		for j < len(p.Words) && p.Data[j] == p.Data[i] {
+			j++
+		}
+		if !p.Data[i] {
+			printRange(w, "code", start+i, start+j)
+		} else {
+			printRange(w, "data", start+i, start+j)
+			// A data label spans the words up to the next label or the end of the data.
+			for k := i; k < j; {
+				n := k + 1
+				for n < j && labels[n] == nil {
+					n++
+				}
+				for _, label := range labels[k] {
+					fmt.Fprintf(w, "\t%03X  %-12s %s\n", start+k, label, words(n-k))
+				}
+				k = n
+			}
+		}
+		i = j
+	}
+	switch {
+	case end < memoryWords:
+		printRange(w, "free", end, memoryWords)
+	case end > memoryWords:
+		return fmt.Errorf("%s: the program runs %s past the end of memory", name, words(end-memoryWords))
+	}
+	return nil
+}
+
+// printRange prints the addresses from start up to end, and what they hold, to w.
+func printRange(w io.Writer, what string, start, end int) {
+	fmt.Fprintf(w, "%03X-%03X  %s  %s\n", start, end-1, what, words(end-start))
+}
+
+func words(n int) string {
+	if n == 1 {
+		return "1 word"
+	}
+	return fmt.Sprintf("%d words", n)
+}
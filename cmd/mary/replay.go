@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bbriano/mary"
+)
+
+// replay re-executes a program against a trace recorded with -trace-json,
+// reporting the first step at which the machine no longer reproduces it, or with -all every such step.
+func replay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	mf := addMachineFlags(fs)
+	all := fs.Bool("all", false, "check every step, reporting each that differs, instead of stopping at the first")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: mary replay [flags] trace file...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	trace, err := mary.ReadTrace(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+	m, err := mf.load(fs.Args()[1:]...)
+	if err != nil {
+		return err
+	}
+	if *all {
+		ds := mary.VerifyTrace(m, trace)
+		for _, d := range ds {
+			fmt.Println("FAIL", d)
+		}
+		if len(ds) > 0 {
+			err = fmt.Errorf("replay: %d/%d steps differ", len(ds), len(trace))
+		}
+	} else {
+		err = mary.ReplayTrace(m, trace)
+	}
+	if cerr := mf.close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("ok %d steps\n", len(trace))
+	return nil
+}
+
+// replayIO re-runs a program with the inputs of a session recorded with -record-io,
+// reporting the first output that differs from the recorded one.
+func replayIO(args []string) error {
+	fs := flag.NewFlagSet("replay-io", flag.ContinueOnError)
+	mf := addMachineFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: mary replay-io [flags] session file...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	events, err := mary.ReadSession(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+	m, err := mf.load(fs.Args()[1:]...)
+	if err != nil {
+		return err
+	}
+	err = mary.ReplaySession(m, events)
+	if cerr := mf.close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("ok %d events\n", len(events))
+	return nil
+}
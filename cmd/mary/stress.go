@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bbriano/mary"
+)
+
+// stress runs random memory images, checking that every run ends in a defined state:
+// halted, faulted with a *mary.RuntimeError, or stopped at the step limit.
+func stress(args []string) error {
+	fs := flag.NewFlagSet("stress", flag.ContinueOnError)
+	n := fs.Int("n", 1000, "run `n` random images")
+	seed := fs.Int64("seed", 0, "seed the generator with `s` (0 for the current time)")
+	maxSteps := fs.Int("max-steps", 10000, "stop each run after `n` instructions")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: mary stress [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	var halted, faulted, limited, failed int
+	for i := 0; i < *n; i++ {
+		// Each image has its own seed so that a failure can be reproduced with -n 1.
+		s := *seed + int64(i)
+		m := randomMachine(rand.New(rand.NewSource(s)), *maxSteps)
+		err := stressRun(m)
+		var rerr *mary.RuntimeError
+		switch {
+		case errors.Is(err, errStressPanic):
+			fmt.Println("FAIL", "-seed", s, err)
+			failed++
+		case err == nil && m.Halted:
+			halted++
+		case err == nil:
+			fmt.Println("FAIL", "-seed", s, "run returned without halting")
+			failed++
+		case errors.As(err, &rerr):
+			faulted++
+		case m.Steps >= *maxSteps:
+			limited++
+		default:
+			fmt.Println("FAIL", "-seed", s, "undefined stop:", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("stress: %d/%d runs failed", failed, *n)
+	}
+	fmt.Printf("ok %d runs: %d halted, %d faulted, %d hit the step limit\n", *n, halted, faulted, limited)
+	return nil
+}
+
+var errStressPanic = errors.New("panic")
+
+// stressRun runs m, returning a panic as an error wrapping errStressPanic.
+func stressRun(m *mary.Machine) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v at %03x", errStressPanic, r, m.PC)
+		}
+	}()
+	return m.Run()
+}
+
+// randomMachine returns a machine with random registers, memory and input.
+func randomMachine(r *rand.Rand, maxSteps int) *mary.Machine {
+	var in strings.Builder
+	for i := r.Intn(20); i > 0; i-- {
+		if r.Intn(10) == 0 {
+			in.WriteString("junk\n")
+			continue
+		}
+		fmt.Fprintf(&in, "%x\n", r.Intn(0x10000))
+	}
+	m := &mary.Machine{
+		Stdin:    strings.NewReader(in.String()),
+		Stdout:   io.Discard,
+		Stderr:   io.Discard,
+		MaxSteps: maxSteps,
+	}
+	if r.Intn(2) == 0 {
+		m.M = mary.NewSparseMemory()
+	}
+	word := func() mary.Word { return mary.Word(r.Intn(0x10000)) }
+	m.SetRegisters(mary.Registers{AC: word(), PC: word(), MAR: word(), MBR: word(), IR: word(), IN: word(), OUT: word()})
+	mem := m.Memory()
+	for i := 0; i < 4096; i++ {
+		mem.Write(mary.Word(i), word())
+	}
+	return m
+}
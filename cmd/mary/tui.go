@@ -0,0 +1,436 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bbriano/mary"
+	"golang.org/x/term"
+)
+
+// tui runs a program in a full-screen terminal UI: the registers, the memory around PC
+// disassembled next to its source, and the program's output, stepped and run with single keys.
+func tui(args []string) error {
+	fs := flag.NewFlagSet("tui", flag.ContinueOnError)
+	mf := addMachineFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: mary tui [flags] file...")
+		fmt.Fprintln(os.Stderr, "Keys: s step, c continue (any key pauses), b toggle breakpoint, arrows and page up/down scroll, . back to PC, r reset, q quit")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("tui: stdin and stdout must be a terminal")
+	}
+	m, err := mf.load(fs.Args()...)
+	if err != nil {
+		return err
+	}
+	old, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	// Draw on the alternate screen with the cursor hidden, leaving the shell's screen as it was.
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	err = newScreen(m, fs.Arg(0)).loop()
+	fmt.Print("\x1b[?25h\x1b[?1049l")
+	term.Restore(fd, old)
+	if cerr := mf.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// tuiSlice is the number of instructions continue runs between checks for a key to pause it.
+const tuiSlice = 50000
+
+// screen is the state of the terminal UI.
+type screen struct {
+	m      *mary.Machine
+	name   string
+	keys   <-chan string
+	done   chan struct{} // closed on quit, to stop the key reader
+	breaks map[mary.Word]bool
+
+	cursor  mary.Word // the highlighted address, which the memory pane is centred on
+	console []string  // the program's output, a line each
+	partial bool      // the last line of console is not yet ended by a newline
+	status  string
+	prompt  string // the Input prompt with what has been typed so far, while Input waits
+	budget  int    // instructions left in the current slice of continue
+}
+
+func newScreen(m *mary.Machine, name string) *screen {
+	keys := make(chan string, 16)
+	done := make(chan struct{})
+	go readKeys(os.Stdin, keys, done)
+	s := &screen{m: m, name: name, keys: keys, done: done, breaks: make(map[mary.Word]bool), cursor: m.PC, status: "ready"}
+	m.Stdout = consoleWriter{s}
+	m.Stderr = consoleWriter{s}
+	// Output goes to the console in the machine's output mode, as WriterSink would print it.
+	m.Sink = mary.WriterSink{W: consoleWriter{s}, Mode: m.OutputMode}
+	if m.Source == nil {
+		m.Source = screenInput{s}
+	}
+	return s
+}
+
+// loop handles keys until q.
+func (s *screen) loop() error {
+	defer close(s.done)
+	for {
+		s.draw()
+		key, ok := <-s.keys
+		if !ok {
+			return nil
+		}
+		switch key {
+		case "q", "ctrl-c":
+			return nil
+		case "s", "n", " ":
+			s.step()
+		case "c":
+			s.cont()
+		case "b":
+			s.breaks[s.cursor] = !s.breaks[s.cursor]
+			if s.breaks[s.cursor] {
+				s.m.AddBreakpoint(s.cursor)
+			} else {
+				delete(s.breaks, s.cursor)
+				s.m.RemoveBreakpoint(s.cursor)
+			}
+		case "up", "k":
+			s.cursor = (s.cursor - 1) & (memoryWords - 1)
+		case "down", "j":
+			s.cursor = (s.cursor + 1) & (memoryWords - 1)
+		case "pgup":
+			s.cursor = (s.cursor - mary.Word(s.rows())) & (memoryWords - 1)
+		case "pgdn":
+			s.cursor = (s.cursor + mary.Word(s.rows())) & (memoryWords - 1)
+		case ".":
+			s.cursor = s.m.PC
+		case "r":
+			s.m.ResetKeepProgram()
+			s.console, s.partial = nil, false
+			s.cursor, s.status = s.m.PC, "reset"
+		}
+	}
+}
+
+func (s *screen) step() {
+	if s.m.Halted {
+		s.status = "halted; r to reset"
+		return
+	}
+	_, err := s.m.Step()
+	s.stopped(err)
+}
+
+// cont runs the machine until it halts, faults or reaches a breakpoint, or a key is pressed.
+func (s *screen) cont() {
+	if s.m.Halted {
+		s.status = "halted; r to reset"
+		return
+	}
+	s.status = "running; any key pauses"
+	// Run in slices of tuiSlice instructions, counted by a break condition; the screen adds no
+	// other, so clearing them removes it.
+	s.m.AddBreakCondition(func(*mary.Machine) bool {
+		s.budget--
+		return s.budget < 0
+	})
+	defer s.m.ClearBreakConditions()
+	for {
+		s.budget = tuiSlice
+		err := s.m.Run()
+		if !errors.Is(err, mary.ErrBreakpoint) || s.budget >= 0 || s.breaks[s.m.PC] {
+			s.stopped(err)
+			return
+		}
+		// The slice ran out.
+		select {
+		case <-s.keys:
+			s.status = "paused"
+			s.cursor = s.m.PC
+			return
+		default:
+		}
+		s.cursor = s.m.PC
+		s.draw()
+	}
+}
+
+// stopped reports why the machine stopped, err being what Step or Run returned.
+func (s *screen) stopped(err error) {
+	s.cursor = s.m.PC
+	switch {
+	case errors.Is(err, mary.ErrBreakpoint):
+		s.status = "breakpoint at " + s.m.Format.Addr(s.m.PC)
+	case err != nil:
+		s.status = err.Error()
+	case s.m.Halted:
+		s.status = "halted"
+	default:
+		s.status = "stopped at " + s.m.Format.Addr(s.m.PC)
+	}
+}
+
+// size returns the size of the terminal, or 80x24 if it cannot be found.
+func (s *screen) size() (width, height int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width < 40 || height < 10 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// rows returns the number of rows of the memory pane: the screen less the title and key lines.
+func (s *screen) rows() int {
+	_, height := s.size()
+	return height - 2
+}
+
+// draw redraws the screen: a title line, the memory pane on the left, the registers and the
+// output console on the right, and a line of keys, or the Input prompt while Input waits.
+func (s *screen) draw() {
+	width, height := s.size()
+	const right = 28
+	left := width - right - 1
+	rows := height - 2
+	m, f := s.m, s.m.Format
+
+	side := []string{
+		"AC   " + f.Word(m.AC),
+		"PC   " + f.Addr(m.PC),
+		"MAR  " + f.Addr(m.MAR),
+		"MBR  " + f.Word(m.MBR),
+		"IR   " + f.Word(m.IR),
+		"IN   " + f.Word(m.IN),
+		"OUT  " + f.Word(m.OUT),
+		fmt.Sprintf("Steps %d", m.Steps),
+		"",
+		"Output",
+	}
+	console := s.console
+	if n := rows - len(side); len(console) > n {
+		console = console[len(console)-n:]
+	}
+	side = append(side, console...)
+
+	start := int(s.cursor) - rows/2
+	if start > memoryWords-rows {
+		start = memoryWords - rows
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[7m")
+	b.WriteString(fit(fmt.Sprintf(" mary  %s  %s", s.name, s.status), width))
+	b.WriteString("\x1b[0m")
+	for i := 0; i < rows; i++ {
+		b.WriteString("\r\n")
+		addr := mary.Word(start + i)
+		row := fit(s.memoryRow(addr), left)
+		if addr == s.cursor {
+			row = "\x1b[7m" + row + "\x1b[0m"
+		}
+		b.WriteString(row)
+		b.WriteString("|")
+		if i < len(side) {
+			b.WriteString(fit(" "+side[i], right))
+		} else {
+			b.WriteString(fit("", right))
+		}
+	}
+	b.WriteString("\r\n")
+	if s.prompt != "" {
+		b.WriteString(fit(s.prompt, width))
+	} else {
+		b.WriteString(fit(" s step  c continue  b breakpoint  ↑↓ PgUp PgDn scroll  . PC  r reset  q quit", width))
+	}
+	fmt.Print(b.String())
+}
+
+// memoryRow formats the word at addr for the memory pane: markers for PC (>) and a breakpoint (*),
+// the address and word, the word disassembled, and the source it was assembled from.
+// eg., "> * 002  4004  Output      Output".
+func (s *screen) memoryRow(addr mary.Word) string {
+	m, f := s.m, s.m.Format
+	words, err := m.ReadMemory(addr, 1)
+	if err != nil {
+		return ""
+	}
+	w := words[0]
+	mark := []byte("    ")
+	if addr == m.PC {
+		mark[0] = '>'
+	}
+	if s.breaks[addr] {
+		mark[2] = '*'
+	}
+	p := m.Program()
+	op, operand := mary.Decode(w)
+	code := fmt.Sprintf("%s %s", op, f.Addr(operand))
+	switch op {
+	case mary.OpInput, mary.OpOutput, mary.OpHalt, mary.OpClear:
+		if operand == 0 {
+			code = op.String()
+		}
+	}
+	source := ""
+	if i := int(addr) - int(p.Origin); i >= 0 && i < len(p.Words) {
+		if i < len(p.Data) && p.Data[i] {
+			code = fmt.Sprintf("= %d", w.Signed())
+		}
+		if src, ok := p.SourceAt(addr); ok {
+			source = strings.ReplaceAll(strings.TrimSpace(src.Text), "\t", " ")
+		}
+	}
+	return fmt.Sprintf("%s%s  %s  %-12s %s", mark, f.Addr(addr), f.Word(w), code, source)
+}
+
+// fit pads or truncates s to n characters.
+func fit(s string, n int) string {
+	r := []rune(s)
+	if len(r) > n {
+		return string(r[:n])
+	}
+	return s + strings.Repeat(" ", n-len(r))
+}
+
+// consoleWriter appends what the machine writes to Stdout and Stderr, such as Dump's output,
+// to the console of the screen.
+type consoleWriter struct {
+	s *screen
+}
+
+func (w consoleWriter) Write(p []byte) (int, error) {
+	w.s.write(string(p))
+	return len(p), nil
+}
+
+// write appends text to the console, continuing its last line if that was not ended by a newline,
+// as the characters of ascii output are not.
+func (s *screen) write(text string) {
+	if text == "" {
+		return
+	}
+	lines := strings.Split(strings.ReplaceAll(text, "\t", " "), "\n")
+	if s.partial && len(s.console) > 0 {
+		s.console[len(s.console)-1] += lines[0]
+		lines = lines[1:]
+	}
+	s.partial = !strings.HasSuffix(text, "\n")
+	if !s.partial {
+		lines = lines[:len(lines)-1]
+	}
+	s.console = append(s.console, lines...)
+}
+
+// screenInput is the InputSource of a machine in the terminal UI. It reads a value on the prompt
+// line, parsed as Input parses Stdin in the machine's InputMode: in ascii mode each key is a
+// value, Enter a newline. Escape ends the input.
+type screenInput struct {
+	s *screen
+}
+
+func (in screenInput) ReadInput() (mary.Word, error) {
+	s := in.s
+	defer func() { s.prompt = "" }()
+	mode := s.m.InputMode
+	typed := ""
+	for {
+		s.prompt = " Input (" + mode.String() + ", Esc for end of input): " + typed
+		s.draw()
+		key, ok := <-s.keys
+		switch {
+		case !ok || key == "esc" || key == "ctrl-c":
+			return 0, io.EOF
+		case mode == mary.InputASCII && (key == "enter" || len(key) == 1):
+			if key == "enter" {
+				key = ""
+			}
+			w, err := mode.Parse(key)
+			if err == nil {
+				s.console = append(s.console, "> "+key)
+				return w, nil
+			}
+			s.status = err.Error()
+		case key == "enter":
+			w, err := mode.Parse(strings.TrimSpace(typed))
+			if err == nil {
+				s.console = append(s.console, "> "+typed)
+				return w, nil
+			}
+			s.status = err.Error()
+			typed = ""
+		case key == "backspace":
+			if typed != "" {
+				typed = typed[:len(typed)-1]
+			}
+		case len(key) == 1:
+			typed += key
+		}
+	}
+}
+
+// readKeys sends the keys pressed on r, which is in raw mode, to keys until r ends or done is
+// closed. A read of r cannot be interrupted, so after done is closed it returns with the next key.
+// Arrow and page keys are named up, down, pgup and pgdn, and Enter, Backspace, Escape and
+// Ctrl-C enter, backspace, esc and ctrl-c; other keys are sent as typed.
+func readKeys(r io.Reader, keys chan<- string, done <-chan struct{}) {
+	defer close(keys)
+	names := map[string]string{
+		"\x1b[A": "up", "\x1b[B": "down", "\x1b[5~": "pgup", "\x1b[6~": "pgdn",
+		"\r": "enter", "\n": "enter", "\x7f": "backspace", "\b": "backspace", "\x03": "ctrl-c",
+	}
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		for in := string(buf[:n]); in != ""; {
+			key := in[:1]
+			for seq := range names {
+				if len(seq) > len(key) && strings.HasPrefix(in, seq) {
+					key = seq
+				}
+			}
+			in = in[len(key):]
+			switch {
+			case names[key] != "":
+				key = names[key]
+			case key == "\x1b" && strings.HasPrefix(in, "["):
+				// The escape sequence of a key without a name, ended by a byte in @ to ~: skip it.
+				i := strings.IndexFunc(in[1:], func(r rune) bool { return r >= '@' && r <= '~' })
+				if i < 0 {
+					in = ""
+				} else {
+					in = in[i+2:]
+				}
+				continue
+			case key == "\x1b":
+				key = "esc"
+			}
+			select {
+			case keys <- key:
+			case <-done:
+				return
+			}
+		}
+	}
+}
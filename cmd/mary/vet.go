@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bbriano/mary"
+)
+
+// vet reports the likely bugs mary.Vet finds in a program, failing if there are any.
+func vet(args []string) error {
+	fs := flag.NewFlagSet("vet", flag.ContinueOnError)
+	af := addAssemblerFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: mary vet [flags] file...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flag.ErrHelp
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	a, err := af.assembler()
+	if err != nil {
+		return err
+	}
+	p, err := a.AssembleFiles(fs.Args()...)
+	if cerr := af.close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return assemblyError(fs.Arg(0), err)
+	}
+	// Vet's fallthrough check follows jumps, and so supersedes the warning about data before a Halt.
+	var warnings []mary.Warning
+	for _, w := range p.Warnings {
+		if !errors.Is(w, mary.WarnDataBeforeHalt) {
+			warnings = append(warnings, w)
+		}
+	}
+	p.Warnings = warnings
+	printWarnings(fs.Arg(0), p)
+	if err := af.writeReports(p); err != nil {
+		return err
+	}
+	ds := mary.Vet(p)
+	for _, d := range ds {
+		where := fmt.Sprintf("%s:%d", fs.Arg(0), d.Line)
+		if s, ok := p.SourceAt(d.Addr); ok {
+			where = s.String()
+		}
+		fmt.Fprintf(os.Stderr, "%s: %03X: %s (%s)\n", where, d.Addr, d.Msg, d.Check)
+	}
+	switch len(ds) {
+	case 0:
+	case 1:
+		return fmt.Errorf("%s: 1 problem", fs.Arg(0))
+	default:
+		return fmt.Errorf("%s: %d problems", fs.Arg(0), len(ds))
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+//go:build js && wasm
+
+// Marywasm exposes the assembler and machine to JavaScript, so that a web page can simulate
+// the Marie machine without a server. Build it with
+//
+//	GOOS=js GOARCH=wasm go build -o mary.wasm ./cmd/marywasm
+//
+// and run it with the wasm_exec.js of the Go distribution. It defines the global object mary:
+//
+//	mary.assemble(src)  {origin, words, symbols}
+//	mary.load(src)      a machine with the program assembled from src loaded
+//
+// A machine has the methods
+//
+//	step()              execute one instruction: {addr, opcode, operand, line, halted}
+//	run(maxSteps)       run until it halts or reaches a breakpoint, or for at most maxSteps
+//	                    instructions if maxSteps is positive: {halted, breakpoint, steps}
+//	registers()         {AC, PC, MAR, MBR, IR, IN, OUT, halted, steps}
+//	memory(start, n)    the n words of memory from start
+//	input(values...)    queue values for Input instructions
+//	output()            the values output since the last call
+//	text()              what Dump has printed since the last call
+//	setBreakpoint(addr) and clearBreakpoint(addr)
+//	reset()             restore the program and zero the registers
+//
+// Words are numbers from 0 to 0xFFFF. A result that failed has the property error, the error's
+// message; an assembly's also has errors, a {line, column, message} for each syntax error.
+// Input at the end of the queued values faults.
+package main
+
+import (
+	"errors"
+	"strings"
+	"syscall/js"
+
+	"github.com/bbriano/mary"
+)
+
+func main() {
+	js.Global().Set("mary", js.ValueOf(map[string]any{
+		"assemble": js.FuncOf(func(this js.Value, args []js.Value) any {
+			p, err := mary.Assemble(strings.NewReader(arg(args, 0).String()))
+			if err != nil {
+				return failure(err)
+			}
+			return program(p)
+		}),
+		"load": js.FuncOf(func(this js.Value, args []js.Value) any {
+			p, err := mary.Assemble(strings.NewReader(arg(args, 0).String()))
+			if err != nil {
+				return failure(err)
+			}
+			return newMachine(p)
+		}),
+	}))
+	// Keep the functions alive for the page to call.
+	select {}
+}
+
+// arg returns args[i], or undefined if there are fewer arguments.
+func arg(args []js.Value, i int) js.Value {
+	if i >= len(args) {
+		return js.Undefined()
+	}
+	return args[i]
+}
+
+// failure returns the result of an operation that failed with err.
+func failure(err error) map[string]any {
+	r := map[string]any{"error": err.Error()}
+	var errs mary.SyntaxErrors
+	var e mary.SyntaxError
+	switch {
+	case errors.As(err, &errs):
+	case errors.As(err, &e):
+		errs = mary.SyntaxErrors{e}
+	default:
+		return r
+	}
+	var list []any
+	for _, e := range errs {
+		list = append(list, map[string]any{"line": e.Line(), "column": e.Column(), "message": e.Error()})
+	}
+	r["errors"] = list
+	return r
+}
+
+func program(p mary.Program) map[string]any {
+	symbols := make(map[string]any)
+	for name, addr := range p.Symbols {
+		symbols[name] = int(addr)
+	}
+	return map[string]any{"origin": int(p.Origin), "words": words(p.Words), "symbols": symbols}
+}
+
+func words(ws []mary.Word) []any {
+	out := make([]any, len(ws))
+	for i, w := range ws {
+		out[i] = int(w)
+	}
+	return out
+}
+
+// newMachine returns the JavaScript object of a machine with p loaded.
+func newMachine(p mary.Program) any {
+	m := new(mary.Machine)
+	if err := m.LoadProgram(p); err != nil {
+		return failure(err)
+	}
+	script := new(mary.InputScript)
+	var outputs []mary.Word
+	var text strings.Builder
+	m.Source = script
+	m.Sink = mary.OutputFunc(func(r mary.OutputRecord) error {
+		outputs = append(outputs, r.Value)
+		return nil
+	})
+	m.Stdout, m.Stderr = &text, &text
+
+	method := func(fn func(args []js.Value) any) js.Func {
+		return js.FuncOf(func(this js.Value, args []js.Value) any { return fn(args) })
+	}
+	return js.ValueOf(map[string]any{
+		"step": method(func(args []js.Value) any {
+			if m.Halted {
+				return failure(errors.New("machine is halted"))
+			}
+			r, err := m.Step()
+			if err != nil {
+				return failure(err)
+			}
+			return map[string]any{
+				"addr":    int(r.Addr),
+				"opcode":  r.Opcode.String(),
+				"operand": int(r.Operand),
+				"line":    m.Program().Line(r.Addr),
+				"halted":  r.Halted,
+			}
+		}),
+		"run": method(func(args []js.Value) any {
+			m.MaxSteps = 0
+			if n := arg(args, 0); n.Type() == js.TypeNumber && n.Int() > 0 {
+				m.MaxSteps = m.Steps + n.Int()
+			}
+			start := m.Steps
+			err := m.Run()
+			limited := m.MaxSteps > 0 && m.Steps >= m.MaxSteps
+			m.MaxSteps = 0
+			breakpoint := errors.Is(err, mary.ErrBreakpoint)
+			if err != nil && !breakpoint && !limited {
+				return failure(err)
+			}
+			return map[string]any{"halted": m.Halted, "breakpoint": breakpoint, "steps": m.Steps - start}
+		}),
+		"registers": method(func(args []js.Value) any {
+			return map[string]any{
+				"AC": int(m.AC), "PC": int(m.PC), "MAR": int(m.MAR), "MBR": int(m.MBR),
+				"IR": int(m.IR), "IN": int(m.IN), "OUT": int(m.OUT),
+				"halted": m.Halted, "steps": m.Steps,
+			}
+		}),
+		"memory": method(func(args []js.Value) any {
+			ws, err := m.ReadMemory(mary.Word(arg(args, 0).Int()), arg(args, 1).Int())
+			if err != nil {
+				return failure(err)
+			}
+			return words(ws)
+		}),
+		"input": method(func(args []js.Value) any {
+			for _, v := range args {
+				script.Values = append(script.Values, mary.Word(v.Int()))
+			}
+			return nil
+		}),
+		"output": method(func(args []js.Value) any {
+			out := words(outputs)
+			outputs = nil
+			return out
+		}),
+		"text": method(func(args []js.Value) any {
+			s := text.String()
+			text.Reset()
+			return s
+		}),
+		"setBreakpoint": method(func(args []js.Value) any {
+			m.AddBreakpoint(mary.Word(arg(args, 0).Int()))
+			return nil
+		}),
+		"clearBreakpoint": method(func(args []js.Value) any {
+			m.RemoveBreakpoint(mary.Word(arg(args, 0).Int()))
+			return nil
+		}),
+		"reset": method(func(args []js.Value) any {
+			m.ResetKeepProgram()
+			script.Values = nil
+			outputs = nil
+			text.Reset()
+			return nil
+		}),
+	})
+}
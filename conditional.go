@@ -0,0 +1,92 @@
+package mary
+
+// cond is an open IFDEF, IFNDEF or IFEQ block.
+type cond struct {
+	line    sourceLine // the line that opened the block
+	name    string     // the directive that opened the block
+	on      bool       // whether the lines of the current branch are assembled
+	outer   bool       // whether the lines around the block are assembled
+	hasElse bool
+}
+
+// conditionals resolves the conditional blocks of lines by defines, returning the lines that are
+// assembled, without the directives. A block is written
+//
+//	IFDEF name      assembled if name is defined
+//	IFNDEF name     assembled if name is not defined
+//	IFEQ name, n    assembled if name is defined as the hex number n
+//
+// followed by the lines of the block, optionally ELSE and the lines assembled otherwise, and ENDIF.
+// Blocks may be nested.
+func conditionals(lines []sourceLine, defines map[string]Word) ([]sourceLine, error) {
+	var out []sourceLine
+	var open []cond
+	on := true
+	for _, l := range lines {
+		tokens, err := tokenize(l.text)
+		if err != nil || len(tokens) == 0 || hashTokens(tokens[:1]) != hashTokenTypes(TokenDirective) {
+			if err == nil && len(tokens) >= 3 && isConditional(tokens[2].str) {
+				return nil, l.syntaxError(ErrBadDirective, tokens[2].str+" cannot be labelled")
+			}
+			if on {
+				out = append(out, l)
+			}
+			continue
+		}
+		switch d := tokens[0].str; d {
+		case "IFDEF", "IFNDEF":
+			if hashTokens(tokens) != hashTokenTypes(TokenDirective, TokenIdentifier) {
+				return nil, l.syntaxError(ErrBadDirective, d+" needs a name")
+			}
+			_, defined := defines[tokens[1].str]
+			open = append(open, cond{l, d, on && defined == (d == "IFDEF"), on, false})
+		case "IFEQ":
+			if hashTokens(tokens) != hashTokenTypes(TokenDirective, TokenIdentifier, TokenComma, TokenNumber) {
+				return nil, l.syntaxError(ErrBadDirective, "IFEQ needs a name and a number, as in IFEQ LEVEL, 2")
+			}
+			n, err := parseWord(tokens[3].str, 16)
+			if err != nil {
+				return nil, l.syntaxError(operandKind(err), err.Error())
+			}
+			v, defined := defines[tokens[1].str]
+			open = append(open, cond{l, d, on && defined && v == n, on, false})
+		case "ELSE":
+			if len(open) == 0 || len(tokens) > 1 {
+				return nil, l.syntaxError(ErrBadDirective, "ELSE without IFDEF, IFNDEF or IFEQ")
+			}
+			c := &open[len(open)-1]
+			if c.hasElse {
+				return nil, l.syntaxError(ErrBadDirective, "second ELSE for "+c.line.location())
+			}
+			c.hasElse = true
+			c.on = c.outer && !c.on
+		case "ENDIF":
+			if len(open) == 0 || len(tokens) > 1 {
+				return nil, l.syntaxError(ErrBadDirective, "ENDIF without IFDEF, IFNDEF or IFEQ")
+			}
+			open = open[:len(open)-1]
+		default:
+			if on {
+				out = append(out, l)
+			}
+			continue
+		}
+		on = true
+		if len(open) > 0 {
+			on = open[len(open)-1].on
+		}
+	}
+	if len(open) > 0 {
+		c := open[len(open)-1]
+		return nil, c.line.syntaxError(ErrBadDirective, c.name+" without ENDIF")
+	}
+	return out, nil
+}
+
+func isConditional(s string) bool {
+	switch s {
+	case "IFDEF", "IFNDEF", "IFEQ", "ELSE", "ENDIF":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package mary
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const conditionalSrc = `
+	IFDEF DEBUG
+	Output
+	IFEQ LEVEL, 2
+	Output
+	ELSE
+	Clear
+	ENDIF
+	ENDIF
+	IFNDEF DEBUG
+	Add One
+	ENDIF
+	Halt
+One,	DEC 1
+`
+
+func TestAssembleConditional(t *testing.T) {
+	for _, c := range []struct {
+		defines map[string]Word
+		want    []Word
+	}{
+		{nil, []Word{0x3002, 0x7000, 1}},
+		{map[string]Word{"DEBUG": 1}, []Word{0x6000, 0xA000, 0x7000, 1}},
+		{map[string]Word{"DEBUG": 1, "LEVEL": 2}, []Word{0x6000, 0x6000, 0x7000, 1}},
+		{map[string]Word{"LEVEL": 2}, []Word{0x3002, 0x7000, 1}},
+	} {
+		a := &Assembler{Defines: c.defines}
+		p, err := a.Assemble(strings.NewReader(conditionalSrc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(p.Words, c.want) {
+			t.Errorf("with %v, Words = %04x, want %04x", c.defines, p.Words, c.want)
+		}
+		if i := len(p.Words) - 2; p.Line(Word(i)) != 13 {
+			t.Errorf("with %v, Halt is on line %d, want 13", c.defines, p.Line(Word(i)))
+		}
+	}
+}
+
+func TestAssembleConditionalErrors(t *testing.T) {
+	for _, c := range []struct{ src, want string }{
+		{"IFDEF X\nHalt\n", "syntax: line 1: IFDEF X: IFDEF without ENDIF"},
+		{"Halt\nENDIF\n", "syntax: line 2: ENDIF: ENDIF without IFDEF, IFNDEF or IFEQ"},
+		{"ELSE\n", "syntax: line 1: ELSE: ELSE without IFDEF, IFNDEF or IFEQ"},
+		{"IFDEF X\nELSE\nELSE\nENDIF\n", "syntax: line 3: ELSE: second ELSE for line 1"},
+		{"IFDEF\nENDIF\n", "syntax: line 1: IFDEF: IFDEF needs a name"},
+		{"IFEQ X 2\nENDIF\n", "syntax: line 1: IFEQ X 2: IFEQ needs a name and a number, as in IFEQ LEVEL, 2"},
+		{"L, IFDEF X\nENDIF\n", "syntax: line 1: L, IFDEF X: IFDEF cannot be labelled"},
+	} {
+		_, err := Assemble(strings.NewReader(c.src))
+		if err == nil || err.Error() != c.want {
+			t.Errorf("Assemble(%q) = %v, want %s", c.src, err, c.want)
+		}
+	}
+}
+
+func TestAssembleConditionalInclude(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.mas": "IFDEF LIB\nINCLUDE \"lib.mas\"\nENDIF\nHalt\n",
+		"lib.mas":  "IFEQ LIB, 2\nOutput\nENDIF\nClear\n",
+	})
+	for _, c := range []struct {
+		defines map[string]Word
+		want    []Word
+	}{
+		{nil, []Word{0x7000}},
+		{map[string]Word{"LIB": 1}, []Word{0xA000, 0x7000}},
+		{map[string]Word{"LIB": 2}, []Word{0x6000, 0xA000, 0x7000}},
+	} {
+		a := &Assembler{Defines: c.defines}
+		p, err := a.AssembleFile(filepath.Join(dir, "main.mas"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(p.Words, c.want) {
+			t.Errorf("with %v, Words = %04x, want %04x", c.defines, p.Words, c.want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package mary
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// State is the programmer-visible state of a Marie machine.
+type State struct {
+	AC, PC, MAR, MBR, IR, IN, OUT Word
+
+	Halted bool
+
+	// Mem holds the words at selected addresses. Addresses not listed are zero in a pre-state
+	// and unchecked in a post-state.
+	Mem map[Word]Word
+}
+
+// ConformanceCase is the expected state transition of a single fetch-decode-execute cycle.
+// The instruction to execute is the word at Pre.PC in Pre.Mem.
+type ConformanceCase struct {
+	Name   string
+	Pre    State
+	Input  string // text available to Input
+	Post   State
+	Output string // text expected from Output
+	Fault  bool   // whether the instruction must fault instead of reaching Post
+}
+
+// ConformanceCases is the reference behaviour of every instruction, encoded as data so that
+// alternative implementations can be validated against this one with CheckConformance.
+var ConformanceCases = []ConformanceCase{
+	{
+		Name: "Load",
+		Pre:  State{Mem: map[Word]Word{0: 0x1005, 5: 0x0042}},
+		Post: State{AC: 0x0042, PC: 1, MAR: 5, MBR: 0x0042, IR: 0x1005},
+	},
+	{
+		Name: "Store",
+		Pre:  State{AC: 7, Mem: map[Word]Word{0: 0x2006}},
+		Post: State{AC: 7, PC: 1, MAR: 6, MBR: 7, IR: 0x2006, Mem: map[Word]Word{6: 7}},
+	},
+	{
+		Name: "Add",
+		Pre:  State{AC: 2, Mem: map[Word]Word{0: 0x3005, 5: 3}},
+		Post: State{AC: 5, PC: 1, MAR: 5, MBR: 3, IR: 0x3005},
+	},
+	{
+		Name: "Add overflow",
+		Pre:  State{AC: 0xFFFF, Mem: map[Word]Word{0: 0x3005, 5: 1}},
+		Post: State{AC: 0, PC: 1, MAR: 5, MBR: 1, IR: 0x3005},
+	},
+	{
+		Name: "Subt",
+		Pre:  State{AC: 5, Mem: map[Word]Word{0: 0x4005, 5: 3}},
+		Post: State{AC: 2, PC: 1, MAR: 5, MBR: 3, IR: 0x4005},
+	},
+	{
+		Name: "Subt negative",
+		Pre:  State{AC: 5, Mem: map[Word]Word{0: 0x4005, 5: 6}},
+		Post: State{AC: 0xFFFF, PC: 1, MAR: 5, MBR: 6, IR: 0x4005},
+	},
+	{
+		Name:  "Input",
+		Pre:   State{Mem: map[Word]Word{0: 0x5000}},
+		Input: "1F\n",
+		Post:  State{AC: 0x001F, PC: 1, MBR: 0x5000, IR: 0x5000, IN: 0x001F},
+	},
+	{
+		Name:  "Input end of file",
+		Pre:   State{Mem: map[Word]Word{0: 0x5000}},
+		Fault: true,
+	},
+	{
+		Name:  "Input unparsable",
+		Pre:   State{Mem: map[Word]Word{0: 0x5000}},
+		Input: "xyz\n",
+		Fault: true,
+	},
+	{
+		Name:   "Output",
+		Pre:    State{AC: 0x002A, Mem: map[Word]Word{0: 0x6000}},
+		Post:   State{AC: 0x002A, PC: 1, MBR: 0x6000, IR: 0x6000, OUT: 0x002A},
+		Output: "002a\n",
+	},
+	{
+		Name:   "Output negative",
+		Pre:    State{AC: 0xFFFE, Mem: map[Word]Word{0: 0x6000}},
+		Post:   State{AC: 0xFFFE, PC: 1, MBR: 0x6000, IR: 0x6000, OUT: 0xFFFE},
+		Output: "fffe\n",
+	},
+	{
+		Name:  "Input negative",
+		Pre:   State{Mem: map[Word]Word{0: 0x5000}},
+		Input: "-2\n",
+		Post:  State{AC: 0xFFFE, PC: 1, MBR: 0x5000, IR: 0x5000, IN: 0xFFFE},
+	},
+	{
+		Name: "Halt",
+		Pre:  State{Mem: map[Word]Word{0: 0x7000}},
+		Post: State{PC: 1, MBR: 0x7000, IR: 0x7000, Halted: true},
+	},
+	{
+		Name: "Skipcond 000 negative",
+		Pre:  State{AC: 0xFFFB, Mem: map[Word]Word{0: 0x8000}},
+		Post: State{AC: 0xFFFB, PC: 2, MBR: 0x8000, IR: 0x8000},
+	},
+	{
+		Name: "Skipcond 000 largest positive",
+		Pre:  State{AC: 0x7FFF, Mem: map[Word]Word{0: 0x8000}},
+		Post: State{AC: 0x7FFF, PC: 1, MBR: 0x8000, IR: 0x8000},
+	},
+	{
+		Name: "Skipcond 000 zero",
+		Pre:  State{Mem: map[Word]Word{0: 0x8000}},
+		Post: State{PC: 1, MBR: 0x8000, IR: 0x8000},
+	},
+	{
+		Name: "Skipcond 400 zero",
+		Pre:  State{Mem: map[Word]Word{0: 0x8400}},
+		Post: State{PC: 2, MBR: 0x8400, IR: 0x8400},
+	},
+	{
+		Name: "Skipcond 400 nonzero",
+		Pre:  State{AC: 1, Mem: map[Word]Word{0: 0x8400}},
+		Post: State{AC: 1, PC: 1, MBR: 0x8400, IR: 0x8400},
+	},
+	{
+		Name: "Skipcond 800 positive",
+		Pre:  State{AC: 5, Mem: map[Word]Word{0: 0x8800}},
+		Post: State{AC: 5, PC: 2, MBR: 0x8800, IR: 0x8800},
+	},
+	{
+		Name: "Skipcond 800 negative",
+		Pre:  State{AC: 0x8000, Mem: map[Word]Word{0: 0x8800}},
+		Post: State{AC: 0x8000, PC: 1, MBR: 0x8800, IR: 0x8800},
+	},
+	{
+		Name: "Skipcond 800 zero",
+		Pre:  State{Mem: map[Word]Word{0: 0x8800}},
+		Post: State{PC: 1, MBR: 0x8800, IR: 0x8800},
+	},
+	{
+		Name:  "Skipcond C00",
+		Pre:   State{Mem: map[Word]Word{0: 0x8C00}},
+		Fault: true,
+	},
+	{
+		Name: "Jump",
+		Pre:  State{Mem: map[Word]Word{0: 0x9123}},
+		Post: State{PC: 0x0123, MBR: 0x9123, IR: 0x9123},
+	},
+	{
+		Name: "Clear",
+		Pre:  State{AC: 9, Mem: map[Word]Word{0: 0xA000}},
+		Post: State{PC: 1, MBR: 0xA000, IR: 0xA000},
+	},
+	{
+		Name: "JnS",
+		Pre:  State{Mem: map[Word]Word{0: 0x0010}},
+		Post: State{AC: 0x0011, PC: 0x0011, MAR: 0x0010, MBR: 0x0010, IR: 0x0010, Mem: map[Word]Word{0x0010: 1}},
+	},
+	{
+		Name: "AddI",
+		Pre:  State{AC: 1, Mem: map[Word]Word{0: 0xB005, 5: 8, 8: 3}},
+		Post: State{AC: 4, PC: 1, MAR: 8, MBR: 3, IR: 0xB005},
+	},
+	{
+		Name: "JumpI",
+		Pre:  State{Mem: map[Word]Word{0: 0xC005, 5: 0x0020}},
+		Post: State{PC: 0x0020, MAR: 5, MBR: 0x0020, IR: 0xC005},
+	},
+	{
+		Name: "LoadI",
+		Pre:  State{Mem: map[Word]Word{0: 0xD005, 5: 8, 8: 0x0099}},
+		Post: State{AC: 0x0099, PC: 1, MAR: 8, MBR: 0x0099, IR: 0xD005},
+	},
+	{
+		Name: "StoreI",
+		Pre:  State{AC: 0x0077, Mem: map[Word]Word{0: 0xE005, 5: 8}},
+		Post: State{AC: 0x0077, PC: 1, MAR: 8, MBR: 0x0077, IR: 0xE005, Mem: map[Word]Word{8: 0x0077}},
+	},
+	{
+		Name:   "Dump",
+		Pre:    State{AC: 0xFFFE, Mem: map[Word]Word{0: 0xF002, 1: 0xFFFE}},
+		Post:   State{AC: 0xFFFE, PC: 1, MBR: 0xF002, IR: 0xF002},
+		Output: "AC=-2 PC=1 MAR=0 MBR=-4094 IR=61442 IN=0 OUT=0\n0000: F002 FFFE\n",
+	},
+}
+
+// StepFunc executes one instruction starting from pre, reading Input from in and writing Output to out.
+// It returns the resulting state, with Mem holding at least the addresses listed in want.
+type StepFunc func(pre State, in io.Reader, out io.Writer, want []Word) (State, error)
+
+// CheckConformance runs every case in ConformanceCases through step and
+// returns an error describing each case whose outcome differs from the reference.
+func CheckConformance(step StepFunc) []error {
+	var errs []error
+	for _, c := range ConformanceCases {
+		var out bytes.Buffer
+		got, err := step(c.Pre, strings.NewReader(c.Input), &out, sortedAddrs(c.Post.Mem))
+		switch {
+		case c.Fault && err == nil:
+			errs = append(errs, fmt.Errorf("%s: want fault, got none", c.Name))
+		case c.Fault:
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: %v", c.Name, err))
+		default:
+			if diff := c.Post.diff(got); diff != "" {
+				errs = append(errs, fmt.Errorf("%s: %s", c.Name, diff))
+			}
+			if out.String() != c.Output {
+				errs = append(errs, fmt.Errorf("%s: output %q, want %q", c.Name, out.String(), c.Output))
+			}
+		}
+	}
+	return errs
+}
+
+// CheckReference runs the conformance suite against this implementation.
+func CheckReference() []error {
+	return CheckCPU(newMachineCPU)
+}
+
+// diff describes how got differs from the expected state s. It returns "" if they match.
+func (s State) diff(got State) string {
+	var diffs []string
+	reg := func(name string, want, got Word) {
+		if want != got {
+			diffs = append(diffs, fmt.Sprintf("%s=%04x, want %04x", name, got, want))
+		}
+	}
+	reg("AC", s.AC, got.AC)
+	reg("PC", s.PC, got.PC)
+	reg("MAR", s.MAR, got.MAR)
+	reg("MBR", s.MBR, got.MBR)
+	reg("IR", s.IR, got.IR)
+	reg("IN", s.IN, got.IN)
+	reg("OUT", s.OUT, got.OUT)
+	if s.Halted != got.Halted {
+		diffs = append(diffs, fmt.Sprintf("Halted=%t, want %t", got.Halted, s.Halted))
+	}
+	for _, addr := range sortedAddrs(s.Mem) {
+		reg(fmt.Sprintf("M[%03x]", addr), s.Mem[addr], got.Mem[addr])
+	}
+	return strings.Join(diffs, " ")
+}
+
+func sortedAddrs(mem map[Word]Word) []Word {
+	var addrs []Word
+	for addr := range mem {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
@@ -0,0 +1,9 @@
+package mary
+
+import "testing"
+
+func TestConformance(t *testing.T) {
+	for _, err := range CheckReference() {
+		t.Error(err)
+	}
+}
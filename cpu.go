@@ -0,0 +1,84 @@
+package mary
+
+import "io"
+
+// CPU is a Marie processor core. *Machine implements it.
+//
+// It lets other implementations, such as a student's own core written for a
+// "build your own CPU" project, be validated against this one with CheckCPU.
+type CPU interface {
+	// Step executes one fetch-decode-execute cycle.
+	Step() (StepResult, error)
+
+	Registers() Registers
+	SetRegisters(Registers)
+
+	// Memory returns the core's main memory.
+	Memory() Memory
+}
+
+// Registers is the register file of a CPU.
+type Registers struct {
+	AC  Word `json:"ac"`
+	PC  Word `json:"pc"`
+	MAR Word `json:"mar"`
+	MBR Word `json:"mbr"`
+	IR  Word `json:"ir"`
+	IN  Word `json:"in"`
+	OUT Word `json:"out"`
+
+	Halted bool `json:"halted"`
+}
+
+func (r Registers) String() string {
+	return FormatBook.Registers(r)
+}
+
+// NewCPUFunc returns a fresh CPU with zeroed registers and memory,
+// whose Input and Output instructions use in and out.
+type NewCPUFunc func(in io.Reader, out io.Writer) CPU
+
+// CheckCPU runs the conformance suite against the CPUs returned by newCPU,
+// one per case. See CheckConformance.
+func CheckCPU(newCPU NewCPUFunc) []error {
+	return CheckConformance(func(pre State, in io.Reader, out io.Writer, want []Word) (State, error) {
+		cpu := newCPU(in, out)
+		cpu.SetRegisters(Registers{pre.AC, pre.PC, pre.MAR, pre.MBR, pre.IR, pre.IN, pre.OUT, pre.Halted})
+		mem := cpu.Memory()
+		for addr, w := range pre.Mem {
+			mem.Write(addr, w)
+		}
+		_, err := cpu.Step()
+		r := cpu.Registers()
+		post := State{r.AC, r.PC, r.MAR, r.MBR, r.IR, r.IN, r.OUT, r.Halted, make(map[Word]Word)}
+		for _, addr := range want {
+			post.Mem[addr] = mem.Read(addr)
+		}
+		return post, err
+	})
+}
+
+// newMachineCPU is the NewCPUFunc of this implementation.
+func newMachineCPU(in io.Reader, out io.Writer) CPU {
+	return &Machine{Stdin: in, Stdout: out, Stderr: io.Discard}
+}
+
+// Registers returns the machine's registers.
+func (m *Machine) Registers() Registers {
+	return Registers{m.AC, m.PC, m.MAR, m.MBR, m.IR, m.IN, m.OUT, m.Halted}
+}
+
+// SetRegisters replaces the machine's registers with r.
+func (m *Machine) SetRegisters(r Registers) {
+	m.AC, m.PC, m.MAR, m.MBR, m.IR, m.IN, m.OUT = r.AC, r.PC, r.MAR, r.MBR, r.IR, r.IN, r.OUT
+	m.Halted = r.Halted
+}
+
+// Memory returns the machine's memory, allocating a DenseMemory if M is nil.
+// Writes made directly to it bypass OnMemWrite hooks.
+func (m *Machine) Memory() Memory {
+	if m.M == nil {
+		m.M = new(DenseMemory)
+	}
+	return m.M
+}
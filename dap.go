@@ -0,0 +1,571 @@
+package mary
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DAPServer serves the Debug Adapter Protocol, with which editors such as VS Code drive the
+// machine from their own debugging UI: breakpoints on source lines, stepping an instruction at
+// a time, continuing, pausing, the registers and the words at labels, and evaluating
+// expressions as in the debugger's conditions.
+//
+// The program is named by the "program" argument of the launch request. "stopOnEntry" stops
+// it before its first instruction, and "input" names an input script for its Input
+// instructions. The program cannot read the editor's console: without a script or a Source
+// set by Load, Input finds the end of input. Program output is sent as output events.
+type DAPServer struct {
+	// Load returns a machine with the program at path loaded.
+	Load func(path string) (*Machine, error)
+
+	w   io.Writer
+	wmu sync.Mutex // serializes the messages written to w
+	seq int
+
+	// mu guards the machine and the fields below. It is not held while the machine runs:
+	// running records that, and requests about the machine fail until it stops.
+	mu          sync.Mutex
+	m           *Machine
+	running     bool
+	quiet       bool // the run is being interrupted for a request, which resumes it
+	stopOnEntry bool
+	breakpoints map[string][]Word // addresses of the breakpoints set in each source file
+	run         sync.WaitGroup
+
+	// pause asks the running machine to stop, and paused records that it did.
+	pause  atomic.Bool
+	paused bool
+}
+
+// dapThread is the id of the one thread the server reports.
+const dapThread = 1
+
+// dapRegisters and dapLabels are the variables references of the scopes the server reports.
+const (
+	dapRegisters = 1
+	dapLabels    = 2
+)
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int    `json:"seq"`
+	Type       string `json:"type"`
+	RequestSeq int    `json:"request_seq"`
+	Success    bool   `json:"success"`
+	Command    string `json:"command"`
+	Message    string `json:"message,omitempty"`
+	Body       any    `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int    `json:"seq"`
+	Type  string `json:"type"`
+	Event string `json:"event"`
+	Body  any    `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+// dapHandlers maps the commands of requests to their implementations.
+// A handler sends its response with respond, or returns an error to fail the request.
+var dapHandlers = map[string]func(s *DAPServer, req *dapRequest) error{
+	"initialize":        (*DAPServer).initialize,
+	"launch":            (*DAPServer).launch,
+	"setBreakpoints":    (*DAPServer).setBreakpoints,
+	"configurationDone": (*DAPServer).configurationDone,
+	"threads":           (*DAPServer).threads,
+	"stackTrace":        (*DAPServer).stackTrace,
+	"scopes":            (*DAPServer).scopes,
+	"variables":         (*DAPServer).variables,
+	"evaluate":          (*DAPServer).evaluate,
+	"continue":          (*DAPServer).cont,
+	"next":              (*DAPServer).next,
+	"stepIn":            (*DAPServer).next,
+	"pause":             (*DAPServer).pauseCmd,
+}
+
+// Serve reads requests from r and writes responses and events to w until a disconnect
+// request or the end of r, which stop the machine.
+func (s *DAPServer) Serve(r io.Reader, w io.Writer) error {
+	s.w = w
+	defer s.stop()
+	tp := textproto.NewReader(bufio.NewReader(r))
+	for {
+		req, err := readDAPRequest(tp)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("dap: %v", err)
+		}
+		if req.Command == "disconnect" {
+			s.stop()
+			return s.respond(req, nil)
+		}
+		handler, ok := dapHandlers[req.Command]
+		if !ok {
+			err = fmt.Errorf("unsupported command %q", req.Command)
+		} else {
+			err = handler(s, req)
+		}
+		if err != nil {
+			if err := s.send(&dapResponse{Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: err.Error()}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readDAPRequest reads a request: a Content-Length header, a blank line and a JSON body.
+func readDAPRequest(tp *textproto.Reader) (*dapRequest, error) {
+	h, err := tp.ReadMIMEHeader()
+	if err == io.EOF && len(h) == 0 {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(h.Get("Content-Length"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("bad Content-Length %q", h.Get("Content-Length"))
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(tp.R, body); err != nil {
+		return nil, err
+	}
+	req := new(dapRequest)
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// send writes msg, a *dapResponse or *dapEvent, numbering it with the next sequence number.
+func (s *DAPServer) send(msg any) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.seq++
+	switch msg := msg.(type) {
+	case *dapResponse:
+		msg.Seq = s.seq
+	case *dapEvent:
+		msg.Seq = s.seq
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(b), b)
+	return err
+}
+
+func (s *DAPServer) respond(req *dapRequest, body any) error {
+	return s.send(&dapResponse{Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+}
+
+func (s *DAPServer) event(name string, body any) error {
+	return s.send(&dapEvent{Type: "event", Event: name, Body: body})
+}
+
+// dapOutput is an io.Writer that sends what is written to it as output events in category.
+type dapOutput struct {
+	s        *DAPServer
+	category string
+}
+
+func (o dapOutput) Write(p []byte) (int, error) {
+	err := o.s.event("output", map[string]string{"category": o.category, "output": string(p)})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// stop stops the machine if it is running and waits for it.
+func (s *DAPServer) stop() {
+	s.pause.Store(true)
+	s.run.Wait()
+}
+
+// interrupt stops the machine if it is running, without reporting the stop, and reports
+// whether the caller should resume it. It is false if the machine was not running, or stopped
+// by itself before it could be interrupted, in which case that stop is reported.
+func (s *DAPServer) interrupt() bool {
+	s.mu.Lock()
+	running := s.running
+	s.quiet = running
+	s.mu.Unlock()
+	if !running {
+		return false
+	}
+	s.stop()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resume := s.quiet
+	s.quiet = false
+	return resume
+}
+
+// machine returns the launched machine, locked, for a request about it. The caller unlocks s.mu.
+// It fails while the machine runs.
+func (s *DAPServer) machine() (*Machine, error) {
+	s.mu.Lock()
+	if s.m == nil {
+		s.mu.Unlock()
+		return nil, errors.New("no program is launched")
+	}
+	if s.running {
+		s.mu.Unlock()
+		return nil, errors.New("machine is running")
+	}
+	return s.m, nil
+}
+
+func (s *DAPServer) initialize(req *dapRequest) error {
+	return s.respond(req, map[string]bool{
+		"supportsConfigurationDoneRequest": true,
+		"supportsEvaluateForHovers":        true,
+	})
+}
+
+func (s *DAPServer) launch(req *dapRequest) error {
+	var args struct {
+		Program     string `json:"program"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+		Input       string `json:"input"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return err
+	}
+	if args.Program == "" {
+		return errors.New("launch: no program")
+	}
+	m, err := s.Load(args.Program)
+	if err != nil {
+		return err
+	}
+	if args.Input != "" {
+		f, err := os.Open(args.Input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		m.Source, err = ParseInputScript(f)
+		if err != nil {
+			return err
+		}
+	}
+	// The protocol has the server's stdin and stdout, so the program may not.
+	m.Stdin = strings.NewReader("")
+	m.Stdout = dapOutput{s, "stdout"}
+	m.Stderr = dapOutput{s, "stderr"}
+	m.AddBreakCondition(func(*Machine) bool {
+		if s.pause.Swap(false) {
+			s.paused = true
+			return true
+		}
+		return false
+	})
+	s.mu.Lock()
+	s.m, s.stopOnEntry = m, args.StopOnEntry
+	s.breakpoints = make(map[string][]Word)
+	s.mu.Unlock()
+	if err := s.respond(req, nil); err != nil {
+		return err
+	}
+	return s.event("initialized", nil)
+}
+
+func (s *DAPServer) setBreakpoints(req *dapRequest) error {
+	var args struct {
+		Source      dapSource `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return err
+	}
+	// Breakpoints may be set while the machine runs; it is stopped for the edit and resumed.
+	resume := s.interrupt()
+	m, err := s.machine()
+	if err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	path := filepath.Clean(args.Source.Path)
+	for _, addr := range s.breakpoints[path] {
+		m.RemoveBreakpoint(addr)
+	}
+	s.breakpoints[path] = nil
+	type breakpoint struct {
+		Verified bool   `json:"verified"`
+		Line     int    `json:"line"`
+		Message  string `json:"message,omitempty"`
+	}
+	out := []breakpoint{}
+	for _, b := range args.Breakpoints {
+		addr, ok := s.addrOf(path, b.Line)
+		if !ok {
+			out = append(out, breakpoint{false, b.Line, "no instruction on this line"})
+			continue
+		}
+		m.AddBreakpoint(addr)
+		s.breakpoints[path] = append(s.breakpoints[path], addr)
+		out = append(out, breakpoint{true, b.Line, ""})
+	}
+	err = s.respond(req, map[string]any{"breakpoints": out})
+	if resume {
+		s.resume(m)
+	}
+	return err
+}
+
+// addrOf returns the address of the first word assembled from line of the source file path.
+func (s *DAPServer) addrOf(path string, line int) (Word, bool) {
+	p := s.m.Program()
+	for i := range p.Words {
+		addr := p.Origin + Word(i)
+		if src, ok := p.SourceAt(addr); ok && src.Line == line && filepath.Clean(src.File) == path {
+			return addr, true
+		}
+	}
+	return 0, false
+}
+
+func (s *DAPServer) configurationDone(req *dapRequest) error {
+	m, err := s.machine()
+	if err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	if err := s.respond(req, nil); err != nil {
+		return err
+	}
+	if s.stopOnEntry {
+		return s.stopped("entry", "")
+	}
+	s.resume(m)
+	return nil
+}
+
+func (s *DAPServer) threads(req *dapRequest) error {
+	return s.respond(req, map[string]any{
+		"threads": []map[string]any{{"id": dapThread, "name": "main"}},
+	})
+}
+
+func (s *DAPServer) stackTrace(req *dapRequest) error {
+	m, err := s.machine()
+	if err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	frame := map[string]any{
+		"id":                          1,
+		"name":                        m.Format.Addr(m.PC),
+		"line":                        0,
+		"column":                      0,
+		"instructionPointerReference": fmt.Sprintf("0x%03X", m.PC),
+	}
+	if src, ok := m.Program().SourceAt(m.PC); ok {
+		if src.Label != "" {
+			frame["name"] = src.Label
+		}
+		frame["line"], frame["column"] = src.Line, 1
+		if src.File != "" {
+			frame["source"] = dapSource{filepath.Base(src.File), src.File}
+		}
+	}
+	return s.respond(req, map[string]any{"stackFrames": []any{frame}, "totalFrames": 1})
+}
+
+func (s *DAPServer) scopes(req *dapRequest) error {
+	return s.respond(req, map[string]any{
+		"scopes": []map[string]any{
+			{"name": "Registers", "variablesReference": dapRegisters, "expensive": false},
+			{"name": "Labels", "variablesReference": dapLabels, "expensive": false},
+		},
+	})
+}
+
+func (s *DAPServer) variables(req *dapRequest) error {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return err
+	}
+	m, err := s.machine()
+	if err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	f := m.Format
+	vars := []dapVariable{}
+	switch args.VariablesReference {
+	case dapRegisters:
+		for _, r := range []struct {
+			name  string
+			value string
+		}{
+			{"AC", f.Word(m.AC)}, {"PC", f.Addr(m.PC)}, {"MAR", f.Addr(m.MAR)}, {"MBR", f.Word(m.MBR)},
+			{"IR", f.Word(m.IR)}, {"IN", f.Word(m.IN)}, {"OUT", f.Word(m.OUT)},
+		} {
+			vars = append(vars, dapVariable{r.name, r.value, 0})
+		}
+	case dapLabels:
+		// The words at the labels, in address order.
+		for _, sym := range m.Program().SymbolTable() {
+			if sym.Kind == "label" {
+				vars = append(vars, dapVariable{sym.Name, f.Word(m.peek(sym.Value)), 0})
+			}
+		}
+	default:
+		return fmt.Errorf("no variables %d", args.VariablesReference)
+	}
+	return s.respond(req, map[string]any{"variables": vars})
+}
+
+// evaluate evaluates an Expr. Hovering over a label shows the word at it, rather than its address.
+func (s *DAPServer) evaluate(req *dapRequest) error {
+	var args struct {
+		Expression string `json:"expression"`
+		Context    string `json:"context"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return err
+	}
+	m, err := s.machine()
+	if err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	symbols := m.Program().Symbols
+	src := strings.TrimSpace(args.Expression)
+	if _, ok := symbols[src]; ok && args.Context == "hover" {
+		src = "M[" + src + "]"
+	}
+	e, err := ParseExpr(src, symbols)
+	if err != nil {
+		return err
+	}
+	return s.respond(req, map[string]any{"result": strconv.Itoa(e.Eval(m)), "variablesReference": 0})
+}
+
+func (s *DAPServer) cont(req *dapRequest) error {
+	m, err := s.machine()
+	if err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	if m.Halted {
+		return errors.New("machine is halted")
+	}
+	if err := s.respond(req, map[string]bool{"allThreadsContinued": true}); err != nil {
+		return err
+	}
+	s.resume(m)
+	return nil
+}
+
+// next executes one instruction.
+func (s *DAPServer) next(req *dapRequest) error {
+	m, err := s.machine()
+	if err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	if m.Halted {
+		return errors.New("machine is halted")
+	}
+	if err := s.respond(req, nil); err != nil {
+		return err
+	}
+	_, err = m.Step()
+	return s.report(err, "step")
+}
+
+func (s *DAPServer) pauseCmd(req *dapRequest) error {
+	s.pause.Store(true)
+	return s.respond(req, nil)
+}
+
+// resume runs m in the background until it stops, and reports why. It is called with s.mu
+// held, which the run takes again once m stops.
+func (s *DAPServer) resume(m *Machine) {
+	s.pause.Store(false)
+	s.paused = false
+	s.running = true
+	s.run.Add(1)
+	go func() {
+		defer s.run.Done()
+		err := m.Run()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.running = false
+		if s.quiet && s.paused && errors.Is(err, ErrBreakpoint) {
+			return
+		}
+		s.quiet = false
+		if errors.Is(err, ErrBreakpoint) {
+			reason := "breakpoint"
+			if s.paused {
+				reason = "pause"
+			}
+			s.stopped(reason, "")
+			return
+		}
+		s.report(err, "")
+	}()
+}
+
+// report sends the events for the machine having stopped by reason, faulted with err, or halted.
+// It is called with s.mu held.
+func (s *DAPServer) report(err error, reason string) error {
+	switch {
+	case err != nil:
+		fmt.Fprintln(s.m.stderr(), err)
+		return s.stopped("exception", err.Error())
+	case s.m.Halted:
+		if err := s.event("exited", map[string]int{"exitCode": 0}); err != nil {
+			return err
+		}
+		return s.event("terminated", nil)
+	default:
+		return s.stopped(reason, "")
+	}
+}
+
+func (s *DAPServer) stopped(reason, text string) error {
+	body := map[string]any{"reason": reason, "threadId": dapThread, "allThreadsStopped": true}
+	if text != "" {
+		body["text"] = text
+	}
+	return s.event("stopped", body)
+}
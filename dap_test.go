@@ -0,0 +1,223 @@
+package mary
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDAPServer(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"main.mas": "Load X\nAdd X\nOutput\nHalt\nX, DEC 2\n"})
+	main := filepath.Join(dir, "main.mas")
+	s := &DAPServer{Load: func(path string) (*Machine, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		m := new(Machine)
+		return m, m.Load(f)
+	}}
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.Serve(inR, outW)
+		outW.Close()
+	}()
+	c := &dapClient{t: t, w: inW, msgs: make(chan []byte, 64)}
+	go c.receive(textproto.NewReader(bufio.NewReader(outR)))
+
+	c.request("initialize", map[string]string{"adapterID": "mary"})
+	c.request("launch", map[string]string{"program": main})
+	c.request("setBreakpoints", map[string]any{"source": map[string]string{"path": main}, "breakpoints": []map[string]int{{"line": 3}, {"line": 6}}})
+	c.request("configurationDone", nil)
+	c.await("stopped")
+	c.request("stackTrace", map[string]int{"threadId": 1})
+	c.request("variables", map[string]int{"variablesReference": 1})
+	c.request("evaluate", map[string]string{"expression": "X", "context": "hover"})
+	c.request("next", map[string]int{"threadId": 1})
+	c.await("stopped")
+	c.request("continue", map[string]int{"threadId": 1})
+	c.await("terminated")
+	c.request("disconnect", nil)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"initialize",
+		"launch",
+		"initialized",
+		"setBreakpoints true false",
+		"configurationDone",
+		"stopped breakpoint",
+		"stackTrace 002:3",
+		"variables AC=0004",
+		"evaluate 2",
+		"next",
+		"output 0004",
+		"stopped step",
+		"continue",
+		"exited",
+		"terminated",
+		"disconnect",
+	}
+	if !reflect.DeepEqual(c.got, want) {
+		t.Errorf("messages:\n%s\nwant:\n%s", strings.Join(c.got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDAPServerWhileRunning(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"loop.mas": "Loop, Jump Loop\nHalt\n"})
+	loop := filepath.Join(dir, "loop.mas")
+	s := &DAPServer{Load: func(path string) (*Machine, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		m := new(Machine)
+		return m, m.Load(f)
+	}}
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.Serve(inR, outW)
+		outW.Close()
+	}()
+	c := &dapClient{t: t, w: inW, msgs: make(chan []byte, 64)}
+	go c.receive(textproto.NewReader(bufio.NewReader(outR)))
+
+	c.request("initialize", map[string]string{"adapterID": "mary"})
+	c.request("launch", map[string]string{"program": loop})
+	c.request("configurationDone", nil)
+	c.request("variables", map[string]int{"variablesReference": 1})
+	c.request("setBreakpoints", map[string]any{"source": map[string]string{"path": loop}, "breakpoints": []map[string]int{{"line": 2}, {"line": 3}}})
+	c.request("pause", map[string]int{"threadId": 1})
+	c.await("stopped")
+	c.request("stackTrace", map[string]int{"threadId": 1})
+	c.request("disconnect", nil)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"initialize",
+		"launch",
+		"initialized",
+		"configurationDone",
+		"variables failed: machine is running",
+		"setBreakpoints true false",
+		"pause",
+		"stopped pause",
+		"stackTrace Loop:1",
+		"disconnect",
+	}
+	if !reflect.DeepEqual(c.got, want) {
+		t.Errorf("messages:\n%s\nwant:\n%s", strings.Join(c.got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// dapClient sends requests to a DAPServer and summarizes the messages it sends back by their
+// command or event and the parts of their bodies that matter.
+type dapClient struct {
+	t    *testing.T
+	w    io.Writer
+	msgs chan []byte // the bodies of the messages received, closed at the end of the stream
+	seq  int         // of the last request
+	got  []string
+}
+
+// receive reads messages from r to c.msgs, so that the server never waits for the client to read.
+func (c *dapClient) receive(r *textproto.Reader) {
+	defer close(c.msgs)
+	for {
+		h, err := r.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(h.Get("Content-Length"))
+		body := make([]byte, n)
+		if _, err = io.ReadFull(r.R, body); err != nil {
+			return
+		}
+		c.msgs <- body
+	}
+}
+
+// request sends a request and reads messages up to its response.
+func (c *dapClient) request(command string, args any) {
+	c.seq++
+	b, err := json.Marshal(map[string]any{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(b), b)
+	for c.read() != fmt.Sprint("response ", c.seq) {
+	}
+}
+
+// await reads messages up to the event name.
+func (c *dapClient) await(name string) {
+	for c.read() != "event "+name {
+	}
+}
+
+// read reads a message, recording its summary, and returns its type and request_seq or event.
+func (c *dapClient) read() string {
+	body, ok := <-c.msgs
+	if !ok {
+		c.t.Fatalf("after %q: end of messages", c.got)
+	}
+	var msg struct {
+		Type       string
+		RequestSeq int `json:"request_seq"`
+		Command    string
+		Event      string
+		Success    bool
+		Message    string
+		Body       struct {
+			Breakpoints []struct{ Verified bool }
+			StackFrames []struct {
+				Name string
+				Line int
+			}
+			Variables []struct{ Name, Value string }
+			Result    string
+			Reason    string
+			Output    string
+		}
+	}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	b := msg.Body
+	summary := msg.Command + msg.Event
+	switch {
+	case msg.Type == "response" && !msg.Success:
+		summary += " failed: " + msg.Message
+	case len(b.Breakpoints) > 0:
+		summary += fmt.Sprintf(" %v %v", b.Breakpoints[0].Verified, b.Breakpoints[1].Verified)
+	case len(b.StackFrames) > 0:
+		summary += fmt.Sprintf(" %s:%d", b.StackFrames[0].Name, b.StackFrames[0].Line)
+	case len(b.Variables) > 0:
+		summary += fmt.Sprintf(" %s=%s", b.Variables[0].Name, b.Variables[0].Value)
+	case b.Result+b.Reason+b.Output != "":
+		summary += " " + strings.TrimSpace(b.Result+b.Reason+b.Output)
+	}
+	c.got = append(c.got, summary)
+	if msg.Type == "response" {
+		return fmt.Sprint("response ", msg.RequestSeq)
+	}
+	return "event " + msg.Event
+}
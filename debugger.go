@@ -0,0 +1,417 @@
+package mary
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Debugger is an interactive command interpreter that controls a Machine one instruction at a time.
+// Type "help" at its prompt for the list of commands.
+type Debugger struct {
+	M   *Machine
+	In  io.Reader // commands, and the program's Input if M.Stdin is nil
+	Out io.Writer
+
+	// conds holds the source of the conditions of breakpoints set with "break loc if cond",
+	// and globalConds those set with "break if cond".
+	conds       map[Word]string
+	globalConds []string
+
+	history *History
+}
+
+// debugHistoryLimit is the number of steps the debugger can step back.
+const debugHistoryLimit = 1 << 20
+
+// debugCommands maps debugger command names, and their abbreviations, to implementations.
+// A command returns true to end the session.
+var debugCommands map[string]func(d *Debugger, args []string) (bool, error)
+
+func init() {
+	debugCommands = map[string]func(d *Debugger, args []string) (bool, error){
+		"help":     (*Debugger).help,
+		"h":        (*Debugger).help,
+		"break":    (*Debugger).breakCmd,
+		"b":        (*Debugger).breakCmd,
+		"delete":   (*Debugger).delete,
+		"d":        (*Debugger).delete,
+		"step":     (*Debugger).step,
+		"s":        (*Debugger).step,
+		"back":     (*Debugger).back,
+		"continue": (*Debugger).cont,
+		"c":        (*Debugger).cont,
+		"print":    (*Debugger).print,
+		"p":        (*Debugger).print,
+		"x":        (*Debugger).examine,
+		"input":    (*Debugger).input,
+		"feed":     (*Debugger).feed,
+		"quit":     (*Debugger).quit,
+		"q":        (*Debugger).quit,
+	}
+}
+
+// Run reads and executes commands until "quit" or the end of In.
+func (d *Debugger) Run() error {
+	d.history = NewHistory(d.M)
+	d.history.Limit = debugHistoryLimit
+	defer d.history.Close()
+	sc := bufio.NewScanner(d.In)
+	if d.M.Stdin == nil {
+		// Share the command stream with Input a line at a time, so neither buffers the other's lines.
+		d.M.Stdin = lineReader{sc, isTerminal(d.In)}
+	}
+	for {
+		fmt.Fprint(d.Out, "(mary) ")
+		if !sc.Scan() {
+			fmt.Fprintln(d.Out)
+			return sc.Err()
+		}
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		cmd, ok := debugCommands[fields[0]]
+		if !ok {
+			fmt.Fprintf(d.Out, "unknown command %q; try help\n", fields[0])
+			continue
+		}
+		quit, err := cmd(d, fields[1:])
+		if err != nil {
+			fmt.Fprintln(d.Out, err)
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+func (d *Debugger) help(args []string) (bool, error) {
+	fmt.Fprint(d.Out, `break [loc]     stop before executing the instruction at loc, or list breakpoints
+break [loc] if cond
+                stop there, or before any instruction, only when cond holds
+delete loc      remove the breakpoint at loc
+delete if       remove the breakpoints without a loc
+step [n]        execute n instructions (default 1)
+back [n]        undo the last n instructions (default 1); Input and Output are not undone
+continue        run until a breakpoint or halt
+print           print the registers
+x loc [n]       examine n words of memory at loc (default 1)
+input [mode]    read Input in mode hex, dec or ascii, or print the mode
+feed value...   add values to the end of the input script, such as 1F or 'A',
+                for a program stopped at its end with -eof trap
+quit            end the session
+
+A loc is a label or a hex address. A cond is an expression such as
+"AC == 0", "M[Count] > 10" or "PC == Loop && AC < 0"; numbers in it are
+decimal unless prefixed with 0x.
+`)
+	return false, nil
+}
+
+func (d *Debugger) breakCmd(args []string) (bool, error) {
+	if len(args) == 0 {
+		for _, addr := range d.M.Breakpoints() {
+			fmt.Fprintf(d.Out, "breakpoint at %s%s\n", d.describe(addr), ifCond(d.conds[addr]))
+		}
+		for _, cond := range d.globalConds {
+			fmt.Fprintf(d.Out, "breakpoint%s\n", ifCond(cond))
+		}
+		return false, nil
+	}
+	var cond *Expr
+	loc := args
+	for i, arg := range args {
+		if arg == "if" {
+			var err error
+			cond, err = ParseExpr(strings.Join(args[i+1:], " "), d.M.Program().Symbols)
+			if err != nil {
+				return false, err
+			}
+			loc = args[:i]
+			break
+		}
+	}
+	switch {
+	case len(loc) == 0 && cond != nil:
+		d.M.AddBreakCondition(cond.True)
+		d.globalConds = append(d.globalConds, cond.String())
+		fmt.Fprintf(d.Out, "breakpoint%s\n", ifCond(cond.String()))
+		return false, nil
+	case len(loc) != 1:
+		return false, fmt.Errorf("usage: break [loc] [if cond]")
+	}
+	addr, err := d.parseLoc(loc[0])
+	if err != nil {
+		return false, err
+	}
+	if d.conds == nil {
+		d.conds = make(map[Word]string)
+	}
+	delete(d.conds, addr)
+	if cond == nil {
+		d.M.AddBreakpoint(addr)
+	} else {
+		d.M.AddBreakpointIf(addr, cond.True)
+		d.conds[addr] = cond.String()
+	}
+	fmt.Fprintf(d.Out, "breakpoint at %s%s\n", d.describe(addr), ifCond(d.conds[addr]))
+	return false, nil
+}
+
+// ifCond formats the condition of a breakpoint for display. eg., " if AC == 0".
+func ifCond(cond string) string {
+	if cond == "" {
+		return ""
+	}
+	return " if " + cond
+}
+
+func (d *Debugger) delete(args []string) (bool, error) {
+	if len(args) != 1 {
+		return false, fmt.Errorf("usage: delete loc|if")
+	}
+	if args[0] == "if" {
+		d.M.ClearBreakConditions()
+		d.globalConds = nil
+		return false, nil
+	}
+	addr, err := d.parseLoc(args[0])
+	if err != nil {
+		return false, err
+	}
+	d.M.RemoveBreakpoint(addr)
+	delete(d.conds, addr)
+	return false, nil
+}
+
+func (d *Debugger) step(args []string) (bool, error) {
+	n, err := count(args)
+	if err != nil {
+		return false, fmt.Errorf("usage: step [n]")
+	}
+	for i := 0; i < n; i++ {
+		if err := d.stepOne(true); err != nil {
+			return false, err
+		}
+		if d.M.Halted {
+			break
+		}
+	}
+	return false, nil
+}
+
+func (d *Debugger) back(args []string) (bool, error) {
+	n, err := count(args)
+	if err != nil {
+		return false, fmt.Errorf("usage: back [n]")
+	}
+	for i := 0; i < n; i++ {
+		if !d.history.Back() {
+			fmt.Fprintln(d.Out, "at the start of history")
+			break
+		}
+	}
+	fmt.Fprintf(d.Out, "at %s, step %d%s\n", d.describe(d.M.PC), d.M.Steps, d.source(d.M.PC))
+	return false, nil
+}
+
+// count parses the optional count argument of step and back.
+func count(args []string) (int, error) {
+	switch len(args) {
+	case 0:
+		return 1, nil
+	case 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("bad count: %q", args[0])
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("too many arguments")
+}
+
+func (d *Debugger) cont(args []string) (bool, error) {
+	if d.M.Halted {
+		return false, fmt.Errorf("machine is halted")
+	}
+	err := d.M.Run()
+	switch {
+	case errors.Is(err, ErrBreakpoint):
+		fmt.Fprintf(d.Out, "breakpoint at %s%s\n", d.describe(d.M.PC), d.source(d.M.PC))
+	case err != nil:
+		return false, err
+	default:
+		fmt.Fprintln(d.Out, "halted")
+	}
+	return false, nil
+}
+
+// stepOne executes one instruction, reporting it if verbose, and reports if the machine halts.
+func (d *Debugger) stepOne(verbose bool) error {
+	if d.M.Halted {
+		return fmt.Errorf("machine is halted")
+	}
+	r, err := d.M.Step()
+	if err != nil {
+		return err
+	}
+	if verbose {
+		f := d.M.Format
+		fmt.Fprintf(d.Out, "%s: %s %-8s %s  AC=%s%s\n", f.Addr(r.Addr), f.Word(d.M.IR), r.Opcode, f.Addr(r.Operand), f.Word(d.M.AC), d.source(r.Addr))
+	}
+	if r.Halted {
+		fmt.Fprintln(d.Out, "halted")
+	}
+	return nil
+}
+
+func (d *Debugger) print(args []string) (bool, error) {
+	fmt.Fprintln(d.Out, d.M.Format.Registers(d.M.Registers()))
+	return false, nil
+}
+
+func (d *Debugger) examine(args []string) (bool, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return false, fmt.Errorf("usage: x loc [n]")
+	}
+	addr, err := d.parseLoc(args[0])
+	if err != nil {
+		return false, err
+	}
+	n := 1
+	if len(args) == 2 {
+		n, err = strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return false, fmt.Errorf("bad count: %q", args[1])
+		}
+	}
+	if int(addr)+n > machineMemory {
+		n = machineMemory - int(addr)
+	}
+	words, err := d.M.ReadMemory(addr, n)
+	if err != nil {
+		return false, err
+	}
+	for i, w := range words {
+		if i%8 == 0 {
+			if i > 0 {
+				fmt.Fprintln(d.Out)
+			}
+			fmt.Fprintf(d.Out, "%s:", d.M.Format.Addr(addr+Word(i)))
+		}
+		fmt.Fprintf(d.Out, " %s", d.M.Format.Word(w))
+	}
+	fmt.Fprintln(d.Out)
+	return false, nil
+}
+
+func (d *Debugger) input(args []string) (bool, error) {
+	switch len(args) {
+	case 0:
+		fmt.Fprintf(d.Out, "input mode %s\n", d.M.InputMode)
+	case 1:
+		mode, err := ParseInputMode(args[0])
+		if err != nil {
+			return false, err
+		}
+		d.M.InputMode = mode
+	default:
+		return false, fmt.Errorf("usage: input [hex|dec|ascii]")
+	}
+	return false, nil
+}
+
+func (d *Debugger) feed(args []string) (bool, error) {
+	s, ok := d.M.Source.(*InputScript)
+	if !ok {
+		return false, fmt.Errorf("the program does not read an input script")
+	}
+	if len(args) == 0 {
+		return false, fmt.Errorf("usage: feed value...")
+	}
+	var values []Word
+	for _, arg := range args {
+		w, err := parseInputEntry(arg)
+		if err != nil {
+			return false, fmt.Errorf("feed %s: %v", arg, err)
+		}
+		values = append(values, w)
+	}
+	s.Values = append(s.Values, values...)
+	return false, nil
+}
+
+func (d *Debugger) quit(args []string) (bool, error) {
+	return true, nil
+}
+
+// parseLoc parses a memory location given as a label of the loaded program or a hex address.
+// Labels take precedence over addresses that are spelled the same, such as "Add".
+func (d *Debugger) parseLoc(s string) (Word, error) {
+	if addr, ok := d.M.Program().Symbols[s]; ok {
+		return addr, nil
+	}
+	n, err := strconv.ParseUint(s, 16, 16)
+	if err != nil || n >= machineMemory {
+		return 0, fmt.Errorf("bad address or unknown label: %q", s)
+	}
+	return Word(n), nil
+}
+
+// describe formats addr, with the label at addr if there is one. eg., "002 (start)".
+func (d *Debugger) describe(addr Word) string {
+	var labels []string
+	for label, a := range d.M.Program().Symbols {
+		if a == addr {
+			labels = append(labels, label)
+		}
+	}
+	if len(labels) == 0 {
+		return d.M.Format.Addr(addr)
+	}
+	sort.Strings(labels)
+	return fmt.Sprintf("%s (%s)", d.M.Format.Addr(addr), strings.Join(labels, ", "))
+}
+
+// source formats the source of the word at addr for display after it, or returns "" if it has none.
+// eg., "  line 5 in Main: Subt One".
+func (d *Debugger) source(addr Word) string {
+	s, ok := d.M.Program().SourceAt(addr)
+	if !ok {
+		return ""
+	}
+	in := ""
+	if s.Label != "" {
+		in = " in " + s.Label
+	}
+	return fmt.Sprintf("  %s%s: %s", s, in, s.Text)
+}
+
+// lineReader is an io.Reader that yields one scanned line per Read.
+type lineReader struct {
+	sc  *bufio.Scanner
+	tty bool // whether sc scans a terminal
+}
+
+func (r lineReader) IsTerminal() bool {
+	return r.tty
+}
+
+func (r lineReader) Read(p []byte) (int, error) {
+	if !r.sc.Scan() {
+		if err := r.sc.Err(); err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+	line := r.sc.Text() + "\n"
+	if len(line) > len(p) {
+		line = line[:len(p)]
+	}
+	return copy(p, line), nil
+}
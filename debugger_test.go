@@ -0,0 +1,105 @@
+package mary
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// debug loads the program src into m and runs a Debugger on it reading the commands of script.
+// It returns what the debugger printed.
+func debug(t *testing.T, m *Machine, src, script string) string {
+	t.Helper()
+	p, err := Assemble(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.LoadProgram(p); err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	d := &Debugger{M: m, In: strings.NewReader(script), Out: &out}
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestDebugger(t *testing.T) {
+	m := &Machine{Stdout: io.Discard}
+	out := debug(t, m, "Load X\nLoop, Add One\nSkipcond 000\nJump Loop\nOutput\nHalt\nX, HEX 7FFD\nOne, DEC 1\n", `
+break Loop
+break
+step 2
+print
+continue
+x X 2
+back 2
+delete Loop
+break 4 if AC < 0
+continue
+bogus
+step 3
+continue
+quit
+step
+`)
+	want := `(mary) (mary) breakpoint at 001 (Loop)
+(mary) breakpoint at 001 (Loop)
+(mary) 000: 1006 Load     006  AC=7FFD  line 1: Load X
+001: 3007 Add      007  AC=7FFE  line 2 in Loop: Loop, Add One
+(mary) AC=7FFE PC=002 MAR=007 MBR=0001 IR=3007 IN=0000 OUT=0000
+(mary) breakpoint at 001 (Loop)  line 2 in Loop: Loop, Add One
+(mary) 006: 7FFD 0001
+(mary) at 002, step 2  line 3 in Loop: Skipcond 000
+(mary) (mary) breakpoint at 004 if AC < 0
+(mary) breakpoint at 004  line 5 in Loop: Output
+(mary) unknown command "bogus"; try help
+(mary) 004: 6000 Output   000  AC=8000  line 5 in Loop: Output
+005: 7000 Halt     000  AC=8000  line 6 in Loop: Halt
+halted
+(mary) machine is halted
+(mary) `
+	if out != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+	if !m.Halted || m.AC != 0x8000 || m.OUT != 0x8000 || m.Steps != 11 {
+		t.Errorf("AC=%04x OUT=%04x halted %v after %d steps, want 8000 output and halted after 11", m.AC, m.OUT, m.Halted, m.Steps)
+	}
+}
+
+func TestDebuggerInput(t *testing.T) {
+	// Input reads the line of the command stream after the step that executes it.
+	m := &Machine{Stdout: io.Discard}
+	out := debug(t, m, "Input\nStore X\nInput\nAdd X\nHalt\nX, DEC 0\n", "input dec\ninput\nstep\n12\nstep\nx X\nstep 2\n-3\nfeed 1\n")
+	want := `(mary) (mary) input mode dec
+(mary) 000: 5000 Input    000  AC=000C  line 1: Input
+(mary) 001: 2005 Store    005  AC=000C  line 2: Store X
+(mary) 005: 000C
+(mary) 002: 5000 Input    000  AC=FFFD  line 3: Input
+003: 3005 Add      005  AC=0009  line 4: Add X
+(mary) the program does not read an input script
+(mary) 
+`
+	if out != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+	if m.PC != 4 || m.AC != 9 {
+		t.Errorf("PC=%03x AC=%04x, want 004 and 0009", m.PC, m.AC)
+	}
+
+	// feed adds to the input script of a program trapped at its end.
+	m = &Machine{Stdout: io.Discard, Source: &InputScript{[]Word{5}}, InputEOF: EOFTrap}
+	out = debug(t, m, "Input\nStore X\nInput\nAdd X\nHalt\nX, DEC 0\n", "continue\nfeed 1F 'A'\ncontinue\nprint\n")
+	want = `(mary) runtime: 002: 5000: input: end of file; stopped before the Input to wait for more
+(mary) (mary) halted
+(mary) AC=0024 PC=005 MAR=004 MBR=7000 IR=7000 IN=001F OUT=0000 halted
+(mary) 
+`
+	if out != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+	if s := m.Source.(*InputScript); len(s.Values) != 1 || s.Values[0] != 'A' {
+		t.Errorf("input script holds %04x after the run, want 0041", s.Values)
+	}
+}
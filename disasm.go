@@ -0,0 +1,199 @@
+package mary
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Disassemble returns Marie assembly for the machine words, taken to be loaded at address 0 and
+// run from there. Words that execution can reach are written as instructions, and the rest as
+// HEX data. Addresses that operands refer to are given labels, such as L004 for the target of a
+// Jump or V00A for a variable, so that the program can be edited and assembled again.
+func Disassemble(words []Word) string {
+	return disassemble(0, words, nil, nil)
+}
+
+// Disassemble returns Marie assembly for p, which assembles back to the same words. Its labels
+// are used where they name an address, and its Data tells code from data.
+func (p Program) Disassemble() string {
+	names := make(map[Word]string)
+	labels := make([]string, 0, len(p.Symbols))
+	for label := range p.Symbols {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		// Local labels are written qualified, as Main.loop, which cannot be defined that way.
+		if _, ok := names[p.Symbols[label]]; !ok && !strings.Contains(label, ".") {
+			names[p.Symbols[label]] = label
+		}
+	}
+	data := p.Data
+	if len(data) != len(p.Words) {
+		data = nil
+	}
+	return disassemble(p.Origin, p.Words, names, data)
+}
+
+// disassemble disassembles words loaded at origin, with the labels names and, if data is not nil,
+// the words that are data. Addresses referred to without a name are given one.
+func disassemble(origin Word, words []Word, names map[Word]string, data []bool) string {
+	if data == nil {
+		data = unreachable(origin, words)
+	}
+	inProgram := func(addr Word) bool {
+		return addr >= origin && int(addr) < int(origin)+len(words)
+	}
+
+	label := make(map[Word]string)
+	taken := make(map[string]bool)
+	for addr, name := range names {
+		if inProgram(addr) {
+			label[addr] = name
+			taken[name] = true
+		}
+	}
+	// Name the jump targets first, so that an address both jumped to and read is named as code.
+	for _, jumps := range []bool{true, false} {
+		for i, w := range words {
+			op, addr := Opcode(w>>12), w&0xFFF
+			if data[i] || !hasAddress(op) || (op == OpJump || op == OpJnS) != jumps || !inProgram(addr) {
+				continue
+			}
+			if _, ok := label[addr]; ok {
+				continue
+			}
+			prefix := "V"
+			if jumps {
+				prefix = "L"
+			}
+			name := fmt.Sprintf("%s%03X", prefix, addr)
+			for taken[name] {
+				name = prefix + name
+			}
+			label[addr], taken[name] = name, true
+		}
+	}
+
+	var b strings.Builder
+	if origin != 0 {
+		fmt.Fprintf(&b, "\tORG %s\n", hexOperand(origin, 3))
+	}
+	for i, w := range words {
+		addr := origin + Word(i)
+		if name, ok := label[addr]; ok {
+			b.WriteString(name + ",")
+		}
+		b.WriteString("\t" + disassembleWord(w, data[i], label) + "\n")
+	}
+	return b.String()
+}
+
+// disassembleWord returns the statement that assembles to w: an instruction, with its operand
+// named by label if it can be, or HEX data if w is data or not an instruction as written.
+func disassembleWord(w Word, data bool, label map[Word]string) string {
+	op, operand := Opcode(w>>12), w&0xFFF
+	switch {
+	case data:
+	case op == OpInput, op == OpOutput, op == OpHalt, op == OpClear:
+		if operand == 0 {
+			return op.String()
+		}
+	case op == OpSkipcond:
+		return fmt.Sprintf("%s %s", op, hexOperand(operand, 3))
+	default:
+		if name, ok := label[operand]; ok {
+			return fmt.Sprintf("%s %s", op, name)
+		}
+		return fmt.Sprintf("%s %s", op, hexOperand(operand, 3))
+	}
+	return "HEX " + hexOperand(w, 4)
+}
+
+// hasAddress reports whether the operand of op is an address.
+func hasAddress(op Opcode) bool {
+	switch op {
+	case OpInput, OpOutput, OpHalt, OpClear, OpSkipcond:
+		return false
+	}
+	return true
+}
+
+// hexOperand formats n in hex with at least width digits, and a leading 0 if it would otherwise
+// start with a letter and be taken for a name.
+func hexOperand(n Word, width int) string {
+	s := fmt.Sprintf("%0*X", width, n)
+	if s[0] >= 'A' {
+		s = "0" + s
+	}
+	return s
+}
+
+// unreachable reports for each of words, loaded at origin and run from the first, whether execution
+// cannot reach it, or it is the return address a JnS stores.
+func unreachable(origin Word, words []Word) []bool {
+	reached, slot := flow(origin, words, nil)
+	out := make([]bool, len(words))
+	for i := range words {
+		out[i] = !reached[i] || slot[i]
+	}
+	return out
+}
+
+// flow follows execution of words, loaded at origin, from the first. It reports for each word
+// whether execution reaches it, and whether it is the return address a JnS stores. Execution is
+// followed past Jumps and both ways past Skipcond, and stops at JumpI, whose target is only known
+// at run time, and at the words that data, if not nil, reports are data.
+func flow(origin Word, words []Word, data []bool) (reached, slot []bool) {
+	reached = make([]bool, len(words))
+	slot = make([]bool, len(words))
+	work := []int{0}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		if i < 0 || i >= len(words) || reached[i] {
+			continue
+		}
+		reached[i] = true
+		if data != nil && data[i] {
+			continue
+		}
+		op, addr := Opcode(words[i]>>12), int(words[i]&0xFFF)-int(origin)
+		switch op {
+		case OpHalt, OpJumpI:
+		case OpJump:
+			work = append(work, addr)
+		case OpJnS:
+			if addr >= 0 && addr < len(words) {
+				slot[addr] = true
+			}
+			work = append(work, i+1, addr+1)
+		case OpSkipcond:
+			work = append(work, i+1, i+2)
+		default:
+			work = append(work, i+1)
+		}
+	}
+	return reached, slot
+}
+
+// RoundTrip returns the disassembly of p, having checked that it assembles back to the same origin
+// and words, so that the program can be rewritten or relocated through its source. It returns an
+// error if it does not, which would be a bug in the disassembler.
+func RoundTrip(p Program) (string, error) {
+	src := p.Disassemble()
+	q, err := Assemble(strings.NewReader(src))
+	if err != nil {
+		return "", fmt.Errorf("round trip: %w", err)
+	}
+	if q.Origin != p.Origin || len(q.Words) != len(p.Words) {
+		return "", fmt.Errorf("round trip: %d words at %03X assemble to %d at %03X", len(p.Words), p.Origin, len(q.Words), q.Origin)
+	}
+	for i, w := range p.Words {
+		if q.Words[i] != w {
+			return "", fmt.Errorf("round trip: %04X at %03X assembles to %04X", w, int(p.Origin)+i, q.Words[i])
+		}
+	}
+	return src, nil
+}
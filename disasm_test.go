@@ -0,0 +1,100 @@
+package mary
+
+import (
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	words := []Word{
+		0x5000, // Input
+		0x200A, // Store 00A
+		0x0008, // JnS 008
+		0x8400, // Skipcond 400
+		0x9000, // Jump 000
+		0x7000, // Halt
+		0x7001, // not an instruction as written
+		0x1FFF, // unreachable
+		0x0000, // return address of the subroutine at 008
+		0xC008, // JumpI 008
+		0xFFFF, // data
+	}
+	want := `L000,	Input
+	Store V00A
+	JnS L008
+	Skipcond 400
+	Jump L000
+	Halt
+	HEX 7001
+	HEX 1FFF
+L008,	HEX 0000
+	JumpI L008
+V00A,	HEX 0FFFF
+`
+	if got := Disassemble(words); got != want {
+		t.Errorf("Disassemble:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestProgramDisassemble(t *testing.T) {
+	p, err := Assemble(strings.NewReader("ORG 0A00\nMain, Load Table+1\n.loop, Jump .loop\nTable, DEC 1\nDEC -1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `	ORG 0A00
+Main,	Load VA03
+LA01,	Jump LA01
+Table,	HEX 0001
+VA03,	HEX 0FFFF
+`
+	if got := p.Disassemble(); got != want {
+		t.Errorf("Disassemble:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	// Every word assembles back, whatever it is and wherever it is loaded.
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 50; n++ {
+		words := make([]Word, 1+r.Intn(64))
+		for i := range words {
+			words[i] = Word(r.Intn(1 << 16))
+			if r.Intn(2) == 0 {
+				// An operand within the program, so that labels are made.
+				words[i] = words[i]&0xF000 | Word(r.Intn(len(words)))
+			}
+		}
+		p := Program{Origin: Word(r.Intn(machineMemory - len(words))), Words: words}
+		if _, err := RoundTrip(p); err != nil {
+			t.Fatalf("%04X at %03X: %v\n%s", words, p.Origin, err, p.Disassemble())
+		}
+		q, err := Assemble(strings.NewReader(Disassemble(words)))
+		if err != nil || !reflect.DeepEqual(q.Words, words) {
+			t.Fatalf("Disassemble(%04X) assembles to %04X, %v", words, q.Words, err)
+		}
+	}
+
+	// So does every example program, keeping its labels.
+	files, err := filepath.Glob("*.mas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range files {
+		p, err := AssembleFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src, err := RoundTrip(p)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		for label := range p.Symbols {
+			if !strings.Contains(src, label+",") {
+				t.Errorf("%s: disassembly has no label %s", name, label)
+			}
+		}
+	}
+}
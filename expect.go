@@ -0,0 +1,46 @@
+package mary
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Expectation is an assertion about the state of a machine, such as "M[Result]=50" or "AC=0",
+// checked once a program halts. Both sides are Exprs, so values are decimal unless 0x-prefixed.
+type Expectation struct {
+	src       string
+	got, want *Expr
+}
+
+// ParseExpectation compiles src, an expression and its expected value separated by =.
+// Labels are resolved with symbols.
+func ParseExpectation(src string, symbols map[string]Word) (*Expectation, error) {
+	lhs, rhs, ok := strings.Cut(src, "=")
+	if !ok || strings.HasSuffix(lhs, "!") || strings.HasSuffix(lhs, "<") || strings.HasSuffix(lhs, ">") ||
+		strings.HasPrefix(rhs, "=") {
+		return nil, fmt.Errorf("expectation %q: want expr=value", src)
+	}
+	got, err := ParseExpr(strings.TrimSpace(lhs), symbols)
+	if err != nil {
+		return nil, err
+	}
+	want, err := ParseExpr(strings.TrimSpace(rhs), symbols)
+	if err != nil {
+		return nil, err
+	}
+	return &Expectation{src, got, want}, nil
+}
+
+// Check returns an error describing how m differs from the expectation, or nil if it holds.
+// The sides are compared as Words, so that 0xFFFE matches a word holding -2.
+func (e *Expectation) Check(m *Machine) error {
+	got, want := e.got.Eval(m), e.want.Eval(m)
+	if Word(got) != Word(want) {
+		return fmt.Errorf("%s: %s is %d, want %d", e.src, e.got, got, want)
+	}
+	return nil
+}
+
+func (e *Expectation) String() string {
+	return e.src
+}
@@ -0,0 +1,274 @@
+package mary
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a compiled expression over a machine's registers and memory,
+// such as "AC == 0", "M[Count] > 10" or "PC == Loop && AC < 0".
+//
+// Operands are decimal or 0x-prefixed hex numbers, registers, labels, M[expr] and parenthesised expressions.
+// The data registers AC, MBR, IN and OUT and memory words have their signed values;
+// PC, MAR and IR, like labels, have their unsigned values.
+// The operators, from lowest to highest precedence, are ||, &&, the comparisons == != < <= > >=,
+// binary + and -, and unary - and !. Comparisons and logical operators yield 1 or 0.
+type Expr struct {
+	src  string
+	eval func(m *Machine) int
+}
+
+// ParseExpr compiles src. Labels are resolved with symbols.
+func ParseExpr(src string, symbols map[string]Word) (*Expr, error) {
+	p := &exprParser{src: src, symbols: symbols}
+	p.next()
+	eval, err := p.or()
+	if err == nil && p.tok != "" {
+		err = fmt.Errorf("unexpected %q", p.tok)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %v", src, err)
+	}
+	return &Expr{src, eval}, nil
+}
+
+// Eval evaluates e on m.
+func (e *Expr) Eval(m *Machine) int {
+	return e.eval(m)
+}
+
+// True reports whether e evaluates to non-zero on m.
+func (e *Expr) True(m *Machine) bool {
+	return e.eval(m) != 0
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// exprParser is a recursive descent parser of Expr. It compiles the expression to closures as it parses.
+type exprParser struct {
+	src     string
+	pos     int
+	tok     string // current token, "" at the end
+	symbols map[string]Word
+}
+
+// exprOperators are the operator tokens, longest first so that "<=" is not lexed as "<".
+var exprOperators = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "+", "-", "!", "(", ")", "[", "]"}
+
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	rest := p.src[p.pos:]
+	if rest == "" {
+		p.tok = ""
+		return
+	}
+	for _, op := range exprOperators {
+		if strings.HasPrefix(rest, op) {
+			p.tok = op
+			p.pos += len(op)
+			return
+		}
+	}
+	n := 0
+	for n < len(rest) && (rest[n] == '_' || n > 0 && rest[n] == '.' || unicode.IsLetter(rune(rest[n])) || unicode.IsDigit(rune(rest[n]))) {
+		n++
+	}
+	if n == 0 {
+		n = 1
+	}
+	p.tok = rest[:n]
+	p.pos += n
+}
+
+type evalFunc = func(m *Machine) int
+
+func (p *exprParser) or() (evalFunc, error) {
+	x, err := p.and()
+	for err == nil && p.tok == "||" {
+		p.next()
+		var y evalFunc
+		y, err = p.and()
+		x = logical(x, y, false)
+	}
+	return x, err
+}
+
+func (p *exprParser) and() (evalFunc, error) {
+	x, err := p.cmp()
+	for err == nil && p.tok == "&&" {
+		p.next()
+		var y evalFunc
+		y, err = p.cmp()
+		x = logical(x, y, true)
+	}
+	return x, err
+}
+
+// logical returns the short-circuiting && (if and) or || of x and y.
+func logical(x, y evalFunc, and bool) evalFunc {
+	return func(m *Machine) int {
+		if (x(m) != 0) != and {
+			return b2i(!and)
+		}
+		return b2i(y(m) != 0)
+	}
+}
+
+func (p *exprParser) cmp() (evalFunc, error) {
+	x, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	var cmp func(a, b int) bool
+	switch p.tok {
+	case "==":
+		cmp = func(a, b int) bool { return a == b }
+	case "!=":
+		cmp = func(a, b int) bool { return a != b }
+	case "<":
+		cmp = func(a, b int) bool { return a < b }
+	case "<=":
+		cmp = func(a, b int) bool { return a <= b }
+	case ">":
+		cmp = func(a, b int) bool { return a > b }
+	case ">=":
+		cmp = func(a, b int) bool { return a >= b }
+	default:
+		return x, nil
+	}
+	p.next()
+	y, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	return func(m *Machine) int { return b2i(cmp(x(m), y(m))) }, nil
+}
+
+func (p *exprParser) sum() (evalFunc, error) {
+	x, err := p.unary()
+	for err == nil && (p.tok == "+" || p.tok == "-") {
+		op := p.tok
+		p.next()
+		var y evalFunc
+		y, err = p.unary()
+		a, b := x, y
+		if op == "+" {
+			x = func(m *Machine) int { return a(m) + b(m) }
+		} else {
+			x = func(m *Machine) int { return a(m) - b(m) }
+		}
+	}
+	return x, err
+}
+
+func (p *exprParser) unary() (evalFunc, error) {
+	switch p.tok {
+	case "-":
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(m *Machine) int { return -x(m) }, nil
+	case "!":
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(m *Machine) int { return b2i(x(m) == 0) }, nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (evalFunc, error) {
+	tok := p.tok
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end")
+	case tok == "(":
+		p.next()
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.next()
+		return x, nil
+	case tok == "M":
+		p.next()
+		if p.tok != "[" {
+			return nil, fmt.Errorf("missing [ after M")
+		}
+		p.next()
+		addr, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != "]" {
+			return nil, fmt.Errorf("missing ]")
+		}
+		p.next()
+		return func(m *Machine) int { return int(m.peek(Word(addr(m))).Signed()) }, nil
+	case unicode.IsDigit(rune(tok[0])):
+		n, err := parseNumber(tok)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q", tok)
+		}
+		p.next()
+		return func(*Machine) int { return int(n) }, nil
+	}
+	if reg := register(tok); reg != nil {
+		p.next()
+		return reg, nil
+	}
+	if addr, ok := p.symbols[tok]; ok {
+		p.next()
+		return func(*Machine) int { return int(addr) }, nil
+	}
+	return nil, fmt.Errorf("unknown name %q", tok)
+}
+
+// parseNumber parses a decimal or 0x-prefixed hex number.
+func parseNumber(s string) (int64, error) {
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return strconv.ParseInt(s[2:], 16, 32)
+	}
+	return strconv.ParseInt(s, 10, 32)
+}
+
+// register returns the evaluator of the register named name, or nil if there is none.
+func register(name string) evalFunc {
+	switch name {
+	case "AC":
+		return func(m *Machine) int { return int(m.AC.Signed()) }
+	case "MBR":
+		return func(m *Machine) int { return int(m.MBR.Signed()) }
+	case "IN":
+		return func(m *Machine) int { return int(m.IN.Signed()) }
+	case "OUT":
+		return func(m *Machine) int { return int(m.OUT.Signed()) }
+	case "PC":
+		return func(m *Machine) int { return int(m.PC) }
+	case "MAR":
+		return func(m *Machine) int { return int(m.MAR) }
+	case "IR":
+		return func(m *Machine) int { return int(m.IR) }
+	}
+	return nil
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,28 @@
+package mary
+
+import "testing"
+
+func TestExprNumbers(t *testing.T) {
+	m := new(Machine)
+	for src, want := range map[string]int{
+		"010":   10,
+		"09":    9,
+		"0x1F":  31,
+		"0XFF":  255,
+		"-0x10": -16,
+	} {
+		e, err := ParseExpr(src, nil)
+		if err != nil {
+			t.Errorf("ParseExpr(%q): %v", src, err)
+			continue
+		}
+		if got := e.Eval(m); got != want {
+			t.Errorf("%s = %d, want %d", src, got, want)
+		}
+	}
+	for _, src := range []string{"0b11", "0o17", "1_0", "0x", "1F"} {
+		if _, err := ParseExpr(src, nil); err == nil {
+			t.Errorf("ParseExpr(%q) succeeded, want error", src)
+		}
+	}
+}
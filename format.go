@@ -0,0 +1,73 @@
+package mary
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// NumberFormat is a profile for printing words and addresses, shared by Dump, the debugger,
+// traces and diagnostics so that everything a machine prints looks the same.
+type NumberFormat int
+
+const (
+	FormatDefault NumberFormat = iota // as FormatBook, but Dump prints as it always has: its registers in decimal
+	FormatBook                        // uppercase hex with leading zeros, as the book prints them: 00FF, 0FF
+	FormatSigned                      // words in signed decimal, addresses in decimal: -1, 255
+	FormatC                           // C-style hex: 0x00ff, 0x0ff
+)
+
+var numberFormatNames = []string{
+	FormatDefault: "default",
+	FormatBook:    "book",
+	FormatSigned:  "signed",
+	FormatC:       "c",
+}
+
+// ParseNumberFormat returns the format with the given name: "default", "book", "signed" or "c".
+func ParseNumberFormat(name string) (NumberFormat, error) {
+	for f, s := range numberFormatNames {
+		if s == name {
+			return NumberFormat(f), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown number format %q", name)
+}
+
+func (f NumberFormat) String() string {
+	if f < 0 || int(f) >= len(numberFormatNames) {
+		return fmt.Sprintf("NumberFormat(%d)", int(f))
+	}
+	return numberFormatNames[f]
+}
+
+// Word formats the contents of a register or memory word.
+func (f NumberFormat) Word(w Word) string {
+	switch f {
+	case FormatSigned:
+		return strconv.Itoa(int(w.Signed()))
+	case FormatC:
+		return fmt.Sprintf("0x%04x", w)
+	}
+	return fmt.Sprintf("%04X", w)
+}
+
+// Addr formats a memory address.
+func (f NumberFormat) Addr(a Word) string {
+	switch f {
+	case FormatSigned:
+		return strconv.Itoa(int(a))
+	case FormatC:
+		return fmt.Sprintf("0x%03x", a)
+	}
+	return fmt.Sprintf("%03X", a)
+}
+
+// Registers formats the register file r.
+func (f NumberFormat) Registers(r Registers) string {
+	s := fmt.Sprintf("AC=%s PC=%s MAR=%s MBR=%s IR=%s IN=%s OUT=%s",
+		f.Word(r.AC), f.Addr(r.PC), f.Addr(r.MAR), f.Word(r.MBR), f.Word(r.IR), f.Word(r.IN), f.Word(r.OUT))
+	if r.Halted {
+		s += " halted"
+	}
+	return s
+}
@@ -0,0 +1,47 @@
+package mary
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNumberFormat(t *testing.T) {
+	for _, c := range []struct {
+		f          NumberFormat
+		word, addr string
+	}{
+		{FormatDefault, "FFFE", "0FF"},
+		{FormatBook, "FFFE", "0FF"},
+		{FormatSigned, "-2", "255"},
+		{FormatC, "0xfffe", "0x0ff"},
+	} {
+		if got := c.f.Word(0xFFFE); got != c.word {
+			t.Errorf("%s: Word(FFFE) = %q, want %q", c.f, got, c.word)
+		}
+		if got := c.f.Addr(0xFF); got != c.addr {
+			t.Errorf("%s: Addr(0FF) = %q, want %q", c.f, got, c.addr)
+		}
+		if f, err := ParseNumberFormat(c.f.String()); f != c.f || err != nil {
+			t.Errorf("ParseNumberFormat(%q) = %v, %v", c.f, f, err)
+		}
+	}
+}
+
+func TestDumpFormat(t *testing.T) {
+	for f, want := range map[NumberFormat]string{
+		FormatDefault: "AC=0 PC=1 MAR=0 MBR=-4094 IR=61442 IN=0 OUT=0\n0000: F002 FFFE\n",
+		FormatBook:    "AC=0000 PC=001 MAR=000 MBR=F002 IR=F002 IN=0000 OUT=0000\n000: F002 FFFE\n",
+		FormatSigned:  "AC=0 PC=1 MAR=0 MBR=-4094 IR=-4094 IN=0 OUT=0\n0: -4094 -2\n",
+	} {
+		var out strings.Builder
+		m := &Machine{Stdout: &out, Format: f}
+		m.poke(0, Word(OpDump)<<12|2)
+		m.poke(1, 0xFFFE)
+		if _, err := m.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Errorf("%s: Dump printed %q, want %q", f, out.String(), want)
+		}
+	}
+}
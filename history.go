@@ -0,0 +1,74 @@
+package mary
+
+// History records the changes each instruction makes to a machine so that they can be undone,
+// stepping the machine backwards.
+//
+// Only the machine's registers and memory are restored: values already consumed by Input or
+// produced by Output are not taken back.
+type History struct {
+	// Limit, if positive, is the number of steps remembered. Older steps are forgotten.
+	Limit int
+
+	m      *Machine
+	hooks  *Hooks
+	deltas []delta
+	prev   Registers // registers after the last recorded step
+	steps  int       // Steps after the last recorded step
+	writes []memWrite
+}
+
+// delta is what an instruction changed: the registers before it, and the memory it overwrote.
+type delta struct {
+	regs   Registers
+	steps  int
+	writes []memWrite
+}
+
+type memWrite struct {
+	addr, old Word
+}
+
+// NewHistory starts recording the steps of m.
+func NewHistory(m *Machine) *History {
+	h := &History{m: m, prev: m.Registers(), steps: m.Steps}
+	h.hooks = &Hooks{
+		OnMemWrite: func(addr, old, _ Word) {
+			h.writes = append(h.writes, memWrite{addr, old})
+		},
+		OnStep: func(StepResult) {
+			h.deltas = append(h.deltas, delta{h.prev, h.steps, h.writes})
+			if h.Limit > 0 && len(h.deltas) > h.Limit {
+				h.deltas = h.deltas[len(h.deltas)-h.Limit:]
+			}
+			h.prev, h.steps, h.writes = h.m.Registers(), h.m.Steps, nil
+		},
+	}
+	m.AddHooks(h.hooks)
+	return h
+}
+
+// Len returns the number of steps that can be undone.
+func (h *History) Len() int {
+	return len(h.deltas)
+}
+
+// Back undoes the last recorded step. It returns false if there is none.
+func (h *History) Back() bool {
+	if len(h.deltas) == 0 {
+		return false
+	}
+	d := h.deltas[len(h.deltas)-1]
+	h.deltas = h.deltas[:len(h.deltas)-1]
+	for i := len(d.writes) - 1; i >= 0; i-- {
+		h.m.poke(d.writes[i].addr, d.writes[i].old)
+	}
+	h.m.SetRegisters(d.regs)
+	h.m.Steps = d.steps
+	h.prev, h.steps, h.writes = d.regs, d.steps, nil
+	return true
+}
+
+// Close stops recording.
+func (h *History) Close() {
+	h.m.RemoveHooks(h.hooks)
+}
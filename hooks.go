@@ -0,0 +1,53 @@
+package mary
+
+// Hooks is a set of callbacks that observe a running Machine.
+// Nil callbacks are skipped. Hooks are added to a machine with Machine.AddHooks.
+type Hooks struct {
+	// OnFetch is called after the instruction w is fetched from address pc.
+	// It is not called for an Input that EOFTrap undoes; nor are OnExecute, OnStep or the
+	// hooks of HookOpcode, so that none sees the Input until it executes.
+	OnFetch func(pc, w Word)
+
+	// OnExecute is called before an instruction is executed.
+	OnExecute func(op Opcode, operand Word)
+
+	// OnMemRead is called when an instruction reads val from addr.
+	OnMemRead func(addr, val Word)
+
+	// OnMemWrite is called when an instruction, or Machine.WriteMemory, replaces old at addr with new.
+	OnMemWrite func(addr, old, new Word)
+
+	// OnStep is called after each fetch-decode-execute cycle, including ones that fault, but not
+	// after an Input that EOFTrap undoes.
+	OnStep func(r StepResult)
+}
+
+// AddHooks registers h to be called as m runs.
+func (m *Machine) AddHooks(h *Hooks) {
+	m.hooks = append(m.hooks, h)
+}
+
+// RemoveHooks unregisters h.
+func (m *Machine) RemoveHooks(h *Hooks) {
+	for i, x := range m.hooks {
+		if x == h {
+			m.hooks = append(m.hooks[:i:i], m.hooks[i+1:]...)
+			return
+		}
+	}
+}
+
+// OpcodeHook is called before an instruction is executed, with the instruction's operand.
+type OpcodeHook func(m *Machine, operand Word)
+
+// HookOpcode registers fn to be called before every instruction with opcode op,
+// such as to count every Store or delay every Input, without observing every other instruction.
+// Under EOFTrap, the hooks of an Input run once its value has been read.
+func (m *Machine) HookOpcode(op Opcode, fn OpcodeHook) {
+	m.opHooks[op&0xF] = append(m.opHooks[op&0xF], fn)
+}
+
+// UnhookOpcode unregisters every OpcodeHook registered for op.
+func (m *Machine) UnhookOpcode(op Opcode) {
+	m.opHooks[op&0xF] = nil
+}
@@ -0,0 +1,79 @@
+package mary
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// untilEnd returns lines up to the first END directive. Whatever follows it, such as notes or
+// sample output, is ignored.
+func untilEnd(lines []string) []string {
+	for i, line := range lines {
+		tokens, err := tokenize(line)
+		if err == nil && hashTokens(tokens) == hashTokenTypes(TokenDirective) && tokens[0].str == "END" {
+			return lines[:i]
+		}
+	}
+	return lines
+}
+
+// includeFiles replaces each INCLUDE "file" directive in lines with the lines of file, read relative
+// to dir, and in turn its includes. including is the files being included, outermost first, so that a
+// file that includes itself is caught. The conditional blocks of each file are resolved by a.Defines.
+func (a *Assembler) includeFiles(lines []sourceLine, dir string, including []string) ([]sourceLine, error) {
+	var out []sourceLine
+	for _, l := range lines {
+		tokens, err := tokenize(l.text)
+		if err == nil && len(tokens) >= 3 && tokens[2].str == "INCLUDE" {
+			return nil, l.syntaxError(ErrBadDirective, "INCLUDE cannot be labelled")
+		}
+		if err != nil || len(tokens) == 0 || tokens[0].str != "INCLUDE" {
+			out = append(out, l)
+			continue
+		}
+		if hashTokens(tokens) != hashTokenTypes(TokenDirective, TokenString) {
+			return nil, l.syntaxError(ErrBadDirective, `INCLUDE needs a file name in quotes, as in INCLUDE "lib.mas"`)
+		}
+		name := strings.Trim(tokens[1].str, `"`)
+		path := name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, name)
+		}
+		for _, f := range including {
+			if sameFile(f, path) {
+				return nil, l.syntaxError(ErrBadDirective, fmt.Sprintf("%s includes itself", name))
+			}
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, l.syntaxError(err, err.Error())
+		}
+		var inc []sourceLine
+		for i, line := range untilEnd(a.split(raw)) {
+			where := append([]string{fmt.Sprintf("%s:%d", name, i+1)}, l.where...)
+			inc = append(inc, sourceLine{l.lineNo, line, where, path, i + 1, l.top})
+		}
+		inc, err = conditionals(inc, a.Defines)
+		if err != nil {
+			return nil, err
+		}
+		inc, err = a.includeFiles(inc, filepath.Dir(path), append(including[:len(including):len(including)], path))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, inc...)
+	}
+	return out, nil
+}
+
+// sameFile reports whether the paths a and b name the same file.
+func sameFile(a, b string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	return err == nil && os.SameFile(fa, fb)
+}
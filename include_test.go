@@ -0,0 +1,101 @@
+package mary
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestAssembleInclude(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.mas":       "Load X\nJnS Twice\nHalt\nINCLUDE \"lib/twice.mas\"\nX, DEC 2\n",
+		"lib/twice.mas":  "INCLUDE \"consts.mas\"\nTwice, HEX 0\nAdd X\nJumpI Twice\nEND\nnotes",
+		"lib/consts.mas": "Two EQU 2\n",
+	})
+	p, err := AssembleFile(filepath.Join(dir, "main.mas"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Word{0x1006, 0x0003, 0x7000, 0, 0x3006, 0xC003, 2}; !reflect.DeepEqual(p.Words, want) {
+		t.Errorf("Words = %04x, want %04x", p.Words, want)
+	}
+	if p.Consts["Two"] != 2 || p.Line(4) != 4 {
+		t.Errorf("Consts = %v, Line(4) = %d; want Two=2 and the included words on the INCLUDE's line 4", p.Consts, p.Line(4))
+	}
+	for addr, want := range map[Word]SourcePos{
+		1: {filepath.Join(dir, "main.mas"), 2, "", "JnS Twice"},
+		4: {filepath.Join(dir, "lib", "twice.mas"), 3, "Twice", "Add X"},
+		6: {filepath.Join(dir, "main.mas"), 5, "X", "X, DEC 2"},
+	} {
+		if got, ok := p.SourceAt(addr); !ok || got != want {
+			t.Errorf("SourceAt(%d) = %+v, want %+v", addr, got, want)
+		}
+	}
+	if _, ok := p.SourceAt(7); ok {
+		t.Errorf("SourceAt(7) found a word past the end of the program")
+	}
+}
+
+func TestAssembleIncludeErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"loop.mas":  "Halt\nINCLUDE \"again.mas\"\n",
+		"again.mas": "INCLUDE \"loop.mas\"\n",
+		"bad.mas":   "Halt\nINCLUDE \"lib.mas\"\n",
+		"lib.mas":   "Clear\nLoad\n",
+	})
+	for name, want := range map[string]string{
+		"loop.mas": `syntax: line 2: INCLUDE "loop.mas": loop.mas includes itself (in again.mas:1)`,
+		"bad.mas":  "syntax: line 2: Load: Load needs an operand (in lib.mas:2)",
+	} {
+		_, err := AssembleFile(filepath.Join(dir, name))
+		if err == nil || err.Error() != want {
+			t.Errorf("AssembleFile(%s) = %v, want %s", name, err, want)
+		}
+	}
+}
+
+func TestAssembleFiles(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.mas": "ORG 100\nLoad X\nJnS Double\nOutput\nHalt\nX, DEC 3\nEND\nnotes",
+		"lib.mas":  "/ doubles AC\nDouble, HEX 0\nStore T\nAdd T\nJumpI Double\nT, DEC 0\n",
+		"bad.mas":  "X, DEC 1\nLoad Q\n",
+	})
+	main, lib, bad := filepath.Join(dir, "main.mas"), filepath.Join(dir, "lib.mas"), filepath.Join(dir, "bad.mas")
+	p, err := AssembleFiles(main, lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Word{0x1104, 0x0105, 0x6000, 0x7000, 3, 0, 0x2109, 0x3109, 0xC105, 0}; !reflect.DeepEqual(p.Words, want) {
+		t.Errorf("Words = %04x, want %04x", p.Words, want)
+	}
+	if s, _ := p.SourceAt(0x106); p.Origin != 0x100 || p.Line(0x106) != 3 || s.File != lib {
+		t.Errorf("Origin = %03x, Line(106) = %d, SourceAt(106) = %+v; want 100, and line 3 of lib.mas", p.Origin, p.Line(0x106), s)
+	}
+
+	_, err = AssembleFiles(main, lib, bad)
+	errs, ok := err.(SyntaxErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("AssembleFiles = %v, want an error on each line of bad.mas", err)
+	}
+	if want := "syntax: " + bad + ":1: X, DEC 1: X is already defined at line 6"; errs[0].Error() != want || errs[0].File() != bad {
+		t.Errorf("errs[0] = %v in %q, want %s", errs[0], errs[0].File(), want)
+	}
+	if errs[1].Line() != 2 || errs[1].File() != bad {
+		t.Errorf("errs[1] = %v, want on line 2 of bad.mas", errs[1])
+	}
+}
@@ -0,0 +1,135 @@
+package mary
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// InputSource supplies the values read by Input instructions.
+// ReadInput returns io.EOF when there are no more values,
+// which Input handles as set by Machine.InputEOF.
+type InputSource interface {
+	ReadInput() (Word, error)
+}
+
+// InputMode is how Input parses the lines it reads from Stdin, like the input modes of MarieSim.
+// It does not apply to a Source.
+type InputMode int
+
+const (
+	InputHex     InputMode = iota // a hex word, which may be negative: 1F, -2
+	InputDecimal                  // a decimal word: 31, -2
+	InputASCII                    // a character, read as its code; an empty line is a newline, 000A
+)
+
+var inputModeNames = []string{
+	InputHex:     "hex",
+	InputDecimal: "dec",
+	InputASCII:   "ascii",
+}
+
+// ParseInputMode returns the mode with the given name: "hex", "dec" or "ascii".
+func ParseInputMode(name string) (InputMode, error) {
+	for mode, s := range inputModeNames {
+		if s == name {
+			return InputMode(mode), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown input mode %q", name)
+}
+
+func (mode InputMode) String() string {
+	if mode < 0 || int(mode) >= len(inputModeNames) {
+		return fmt.Sprintf("InputMode(%d)", int(mode))
+	}
+	return inputModeNames[mode]
+}
+
+// Parse parses a line read by Input in the mode.
+func (mode InputMode) Parse(line string) (Word, error) {
+	switch mode {
+	case InputDecimal:
+		return parseWord(line, 10)
+	case InputASCII:
+		if line == "" {
+			return '\n', nil
+		}
+		c, n := utf8.DecodeRuneInString(line)
+		if c == utf8.RuneError || c > 0xFFFF || n != len(line) {
+			return 0, fmt.Errorf("%q is not one character", line)
+		}
+		return Word(c), nil
+	}
+	return parseWord(line, 16)
+}
+
+// InputScript is an InputSource that feeds Input the values of a script, one per Input instruction.
+type InputScript struct {
+	Values []Word
+}
+
+// ParseInputScript parses a script of input values, one entry per line.
+// An entry is a hex value as typed at the Input prompt (1F, -2), DEC or HEX followed by a
+// value as in assembly (DEC 31, HEX 1F), or a character in single quotes ('A').
+// Blank lines and text after a / are ignored.
+func ParseInputScript(r io.Reader) (*InputScript, error) {
+	s := new(InputScript)
+	sc := bufio.NewScanner(r)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		line := sc.Text()
+		entry := strings.TrimSpace(line)
+		start := 0 // where a comment may begin, after the character of a quoted entry
+		if strings.HasPrefix(entry, "'") {
+			_, n := utf8.DecodeRuneInString(entry[1:])
+			start = 1 + n
+		}
+		if i := strings.Index(entry[start:], "/"); i >= 0 {
+			entry = strings.TrimSpace(entry[:start+i])
+		}
+		if entry == "" {
+			continue
+		}
+		w, err := parseInputEntry(entry)
+		if err != nil {
+			return nil, fmt.Errorf("input script: line %d: %s: %v", lineNo, line, err)
+		}
+		s.Values = append(s.Values, w)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func parseInputEntry(entry string) (Word, error) {
+	if strings.HasPrefix(entry, "'") {
+		c, n := utf8.DecodeRuneInString(entry[1:])
+		if n == 0 || c == utf8.RuneError || entry[1+n:] != "'" || c > 0xFFFF {
+			return 0, fmt.Errorf("bad character")
+		}
+		return Word(c), nil
+	}
+	fields := strings.Fields(entry)
+	switch {
+	case len(fields) == 1:
+		return parseWord(fields[0], 16)
+	case len(fields) == 2 && fields[0] == "DEC":
+		return parseWord(fields[1], 10)
+	case len(fields) == 2 && fields[0] == "HEX":
+		return parseWord(fields[1], 16)
+	}
+	return 0, fmt.Errorf("bad entry")
+}
+
+// ReadInput returns the next value of the script, or io.EOF once every value has been read.
+func (s *InputScript) ReadInput() (Word, error) {
+	if len(s.Values) == 0 {
+		return 0, io.EOF
+	}
+	w := s.Values[0]
+	s.Values = s.Values[1:]
+	return w, nil
+}
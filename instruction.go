@@ -1,9 +1,9 @@
-package main
+package mary
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
-	"os"
+	"io"
 )
 
 // Opcode is the 4-bit operation code of an instruction.
@@ -30,12 +30,24 @@ var opcode map[string]Opcode = map[string]Opcode{
 	"Dump":     OpDump,
 }
 
+// String returns the mnemonic of op. eg., "Load".
+func (op Opcode) String() string {
+	for s, o := range opcode {
+		if o == op {
+			return s
+		}
+	}
+	return fmt.Sprintf("Opcode(%d)", int(op))
+}
+
 // Instruction encodes the execute operation of an instruction.
-type Instruction func(*Machine, Word)
+// It returns an error if the instruction faults.
+type Instruction func(*Machine, Word) error
 
 // instruction maps opcode to Instruction functions.
 // It is used to decode the machine code in Machine.Run.
-var instruction map[Opcode]Instruction = map[Opcode]Instruction{
+// It is an array rather than a map so that decoding does no hashing or allocation.
+var instruction = [1 << 4]Instruction{
 	OpJnS:      JnS,
 	OpLoad:     Load,
 	OpStore:    Store,
@@ -73,62 +85,140 @@ const (
 	OpDump
 )
 
-func Load(m *Machine, x Word) {
+func Load(m *Machine, x Word) error {
 	m.MAR = x
-	m.MBR = m.M[m.MAR]
+	m.MBR = m.read(m.MAR)
 	m.AC = m.MBR
+	return nil
 }
 
-func Store(m *Machine, x Word) {
+func Store(m *Machine, x Word) error {
 	m.MAR = x
 	m.MBR = m.AC
-	m.M[m.MAR] = m.MBR
+	m.write(m.MAR, m.MBR)
+	return nil
 }
 
-func Add(m *Machine, x Word) {
+func Add(m *Machine, x Word) error {
 	m.MAR = x
-	m.MBR = m.M[m.MAR]
+	m.MBR = m.read(m.MAR)
 	m.AC += m.MBR
+	return nil
 }
 
-func Subt(m *Machine, x Word) {
+func Subt(m *Machine, x Word) error {
 	m.MAR = x
-	m.MBR = m.M[m.MAR]
+	m.MBR = m.read(m.MAR)
 	m.AC -= m.MBR
+	return nil
 }
 
-func Input(m *Machine, _ Word) {
+// ErrInputEOF is the underlying error of the RuntimeError raised when Input finds Stdin exhausted
+// and the machine's InputEOF is EOFFault.
+var ErrInputEOF = errors.New("input: end of file")
+
+// ErrInputTrap is the underlying error of the RuntimeError raised when Input finds Stdin exhausted
+// and the machine's InputEOF is EOFTrap. It wraps ErrInputEOF.
+var ErrInputTrap = fmt.Errorf("%w; stopped before the Input to wait for more", ErrInputEOF)
+
+// Input reads a word from the machine's Stdin into AC, in hex or as set by Machine.InputMode,
+// or takes the next value of its Source.
+// When Stdin is a terminal, unparsable lines are reported and prompted for again;
+// otherwise they are a fault. The behaviour at the end of input is set by Machine.InputEOF.
+func Input(m *Machine, _ Word) error {
 	var x Word
-	s := bufio.NewScanner(os.Stdin)
-	fmt.Print("> ")
-	for s.Scan() {
-		var err error
-		hex := s.Text()
-		x, err = parseWord(hex, 16)
+	var err error
+	if m.inReady {
+		x, err = m.inValue, m.inErr
+		m.inReady = false
+	} else {
+		x, err = m.readInput()
+	}
+	if err == io.EOF {
+		x, err = m.endOfInput()
+	}
+	if err != nil {
+		return err
+	}
+	m.IN = x
+	m.AC = m.IN
+	return nil
+}
+
+// readInput reads the value of an Input from the machine's Source or Stdin.
+// It returns io.EOF at the end of input.
+func (m *Machine) readInput() (Word, error) {
+	if m.Source != nil {
+		return m.Source.ReadInput()
+	}
+	s := m.scanner()
+	for {
+		fmt.Fprint(m.stdout(), m.prompt())
+		if !s.Scan() {
+			if err := s.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		x, err := m.InputMode.Parse(s.Text())
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			fmt.Print("> ")
+			if !isTerminal(m.stdin()) {
+				return 0, fmt.Errorf("input: %v", err)
+			}
+			fmt.Fprintln(m.stderr(), err)
 			continue
 		}
-		break
+		return x, nil
 	}
-	m.IN = x
-	m.AC = m.IN
 }
 
-func Output(m *Machine, _ Word) {
+// endOfInput returns the value Input reads at the end of input, or its fault, as set by InputEOF.
+// EOFTrap is raised by trapInput, before the Input executes.
+func (m *Machine) endOfInput() (Word, error) {
+	if m.InputEOF == EOFSentinel {
+		return m.Sentinel, nil
+	}
+	return 0, ErrInputEOF
+}
+
+// trapInput reads ahead the value of the instruction w, fetched from PC-1, if it is an Input and
+// the machine's InputEOF is EOFTrap, for the Input to take when it executes. At the end of input
+// it undoes the fetch, so that the machine executes the Input again when it continues, and
+// returns ErrInputTrap before any hook has seen the instruction.
+func (m *Machine) trapInput(w Word) error {
+	op, _ := Decode(w)
+	if op != OpInput || m.InputEOF != EOFTrap || m.inReady || m.forbidden(op) {
+		return nil
+	}
+	x, err := m.readInput()
+	if err == io.EOF {
+		pc := (m.PC - 1) & (machineMemory - 1)
+		m.PC = pc
+		return &RuntimeError{PC: pc, IR: w, Reason: ErrInputTrap.Error(), Err: ErrInputTrap, Format: m.Format}
+	}
+	m.inReady, m.inValue, m.inErr = true, x, err
+	return nil
+}
+
+func Output(m *Machine, _ Word) error {
 	m.OUT = m.AC
-	fmt.Printf("%04x\n", m.OUT)
+	sink := m.Sink
+	if sink == nil {
+		sink = WriterSink{m.stdout(), m.OutputMode}
+	}
+	addr := (m.PC - 1) & (machineMemory - 1)
+	return sink.WriteOutput(OutputRecord{m.OUT, m.Steps, addr, m.program.Line(addr)})
 }
 
-func Halt(m *Machine, _ Word) {
-	os.Exit(0)
+func Halt(m *Machine, _ Word) error {
+	m.Halted = true
+	return nil
 }
 
-func Skipcond(m *Machine, x Word) {
+func Skipcond(m *Machine, x Word) error {
 	switch x >> 10 & 3 {
 	case 0:
-		if m.AC < 0 {
+		if m.AC.Signed() < 0 {
 			m.PC++
 		}
 	case 1:
@@ -136,75 +226,106 @@ func Skipcond(m *Machine, x Word) {
 			m.PC++
 		}
 	case 2:
-		if m.AC > 0 {
+		if m.AC.Signed() > 0 {
 			m.PC++
 		}
 	case 3:
-		fmt.Fprintln(os.Stderr, "bad instruction:", m.IR)
-		os.Exit(1)
+		return errors.New("bad Skipcond condition")
 	}
+	return nil
 }
 
-func Jump(m *Machine, x Word) {
+func Jump(m *Machine, x Word) error {
 	m.PC = x
+	return nil
 }
 
-func JnS(m *Machine, x Word) {
+func JnS(m *Machine, x Word) error {
 	m.MAR = x
 	m.MBR = m.PC
-	m.M[m.MAR] = m.MBR
+	m.write(m.MAR, m.MBR)
 	m.MBR = x
 	m.AC = 1
 	m.AC += m.MBR
 	m.PC = m.AC
+	return nil
 }
 
-func Clear(m *Machine, x Word) {
+func Clear(m *Machine, x Word) error {
 	m.AC = 0
+	return nil
 }
 
-func AddI(m *Machine, x Word) {
+func AddI(m *Machine, x Word) error {
 	m.MAR = x
-	m.MBR = m.M[m.MAR]
+	m.MBR = m.read(m.MAR)
 	m.MAR = m.MBR
-	m.MBR = m.M[m.MAR]
+	m.MBR = m.read(m.MAR)
 	m.AC += m.MBR
+	return nil
 }
 
-func JumpI(m *Machine, x Word) {
+func JumpI(m *Machine, x Word) error {
 	m.MAR = x
-	m.MBR = m.M[m.MAR]
+	m.MBR = m.read(m.MAR)
 	m.PC = m.MBR
+	return nil
 }
 
-func LoadI(m *Machine, x Word) {
+func LoadI(m *Machine, x Word) error {
 	m.MAR = x
-	m.MBR = m.M[m.MAR]
+	m.MBR = m.read(m.MAR)
 	m.MAR = m.MBR
-	m.MBR = m.M[m.MAR]
+	m.MBR = m.read(m.MAR)
 	m.AC = m.MBR
+	return nil
 }
 
-func StoreI(m *Machine, x Word) {
+func StoreI(m *Machine, x Word) error {
 	m.MAR = x
-	m.MBR = m.M[m.MAR]
+	m.MBR = m.read(m.MAR)
 	m.MAR = m.MBR
 	m.MBR = m.AC
-	m.M[m.MAR] = m.MBR
+	m.write(m.MAR, m.MBR)
+	return nil
+}
+
+func Dump(m *Machine, x Word) error {
+	w, f := m.stdout(), m.Format
+	if f == FormatDefault {
+		dumpDefault(m, x)
+		return nil
+	}
+	fmt.Fprintln(w, f.Registers(m.Registers()))
+	rows := (int(x) + 15) / 16
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(w, "%s:", f.Addr(Word(i*16)))
+		for j := 0; j < 16; j++ {
+			if i*16+j == int(x) {
+				break
+			}
+			fmt.Fprintf(w, " %s", f.Word(m.peek(Word(i*16+j))))
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
 }
 
-func Dump(m *Machine, x Word) {
-	fmt.Printf("AC=%d PC=%d MAR=%d MBR=%d IR=%d IN=%d OUT=%d\n",
-		m.AC, m.PC, m.MAR, m.MBR, m.IR, m.IN, m.OUT)
-	rows := int((x-1)/16) + 1
+// dumpDefault is Dump without a number format chosen: the registers in decimal, and the words
+// of memory in hex, each row after its address with 4 digits.
+func dumpDefault(m *Machine, x Word) {
+	w := m.stdout()
+	fmt.Fprintf(w, "AC=%d PC=%d MAR=%d MBR=%d IR=%d IN=%d OUT=%d\n",
+		m.AC.Signed(), m.PC, m.MAR, m.MBR.Signed(), m.IR, m.IN.Signed(), m.OUT.Signed())
+	rows := (int(x) + 15) / 16
 	for i := 0; i < rows; i++ {
-		fmt.Printf("%04X:", i*16)
+		fmt.Fprintf(w, "%04X:", i*16)
 		for j := 0; j < 16; j++ {
 			if i*16+j == int(x) {
 				break
 			}
-			fmt.Printf(" %04X", m.M[i*16+j])
+			fmt.Fprintf(w, " %04X", m.peek(Word(i*16+j)))
 		}
-		fmt.Println()
+		fmt.Fprintln(w)
 	}
 }
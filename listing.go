@@ -0,0 +1,44 @@
+package mary
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// writeListing writes the listing of the assembled program p to w: for each statement, the address
+// and machine word of its first word beside its source line, with the words after the first on
+// lines of their own. stmtOf is the index in stmts of each word of p.
+// Included and expanded statements are listed with their own text, on the line of their INCLUDE
+// or macro call.
+//
+//	ADDR WORD  LINE  SOURCE
+//	100  1102     1  	Load X
+//	101  7000     2  	Halt
+//	102  0005     3  X,	DEC 5
+func writeListing(w io.Writer, p Program, stmts []sourceLine, stmtOf []int) error {
+	if _, err := fmt.Fprintln(w, "ADDR WORD  LINE  SOURCE"); err != nil {
+		return err
+	}
+	j := 0 // index in p.Words of the next word to list
+	for i, l := range stmts {
+		if i == len(stmts)-1 && l.text == "" {
+			// The empty line after the final newline.
+			break
+		}
+		line := fmt.Sprintf("%11s%4d  %s", "", l.lineNo, l.text)
+		if j < len(stmtOf) && stmtOf[j] == i {
+			line = fmt.Sprintf("%03X  %04X  %4d  %s", int(p.Origin)+j, p.Words[j], l.lineNo, l.text)
+			j++
+		}
+		_, err := fmt.Fprintln(w, strings.TrimRight(line, " \t"))
+		for err == nil && j < len(stmtOf) && stmtOf[j] == i {
+			_, err = fmt.Fprintf(w, "%03X  %04X\n", int(p.Origin)+j, p.Words[j])
+			j++
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
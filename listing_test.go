@@ -0,0 +1,32 @@
+package mary
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAssembleListing(t *testing.T) {
+	src := "/ greet\nORG 100\n\tLoad X\n\tHalt\nX,\tDEC 5\nMsg,\tASC \"hi\"\n"
+	var b strings.Builder
+	a := &Assembler{Listing: &b}
+	if _, err := a.Assemble(strings.NewReader(src)); err != nil {
+		t.Fatal(err)
+	}
+	want := `ADDR WORD  LINE  SOURCE
+              1  / greet
+              2  ORG 100
+100  1102     3  	Load X
+101  7000     4  	Halt
+102  0005     5  X,	DEC 5
+103  0068     6  Msg,	ASC "hi"
+104  0069
+`
+	if b.String() != want {
+		t.Errorf("listing:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	if _, err := a.Assemble(strings.NewReader("Load Y\n")); err == nil || b.Len() != 0 {
+		t.Errorf("listing of a program that does not assemble = %q, want none", b.String())
+	}
+}
@@ -0,0 +1,45 @@
+package mary
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// localScope qualifies local labels, written with a leading dot such as .loop, by the label above
+// them: after Main, the local .loop is Main.loop. Each label that is not local starts a new scope,
+// so every routine can have its own .loop. A qualified name such as Main.loop may be used anywhere.
+type localScope struct {
+	global string
+}
+
+// localRE matches the start of each local name of an operand, at its start or after an operator
+// or opening parenthesis, including those of debugger expressions.
+var localRE = regexp.MustCompile(`(^|[-+*(\[!<>=&|])\.([A-Za-z])`)
+
+// localNameRE matches a local name.
+var localNameRE = regexp.MustCompile(`\.[A-Za-z][A-Za-z0-9]*`)
+
+// qualify returns tokens, the tokens of the line l, with its local names qualified.
+func (s *localScope) qualify(l sourceLine, tokens []Token) ([]Token, error) {
+	if len(tokens) >= 2 && hashTokens(tokens[:2]) == hashTokenTypes(TokenIdentifier, TokenComma) &&
+		!strings.Contains(tokens[0].str, ".") {
+		s.global = tokens[0].str
+	}
+	out := make([]Token, len(tokens))
+	for i, t := range tokens {
+		out[i] = t
+		switch hashTokens([]Token{t}) {
+		case hashTokenTypes(TokenIdentifier), hashTokenTypes(TokenExpr):
+			if !localRE.MatchString(t.str) {
+				continue
+			}
+			if s.global == "" {
+				reason := fmt.Sprintf("local label %s comes before any label", localNameRE.FindString(t.str))
+				return nil, l.syntaxErrorAt(ErrUndefinedSymbol, reason, tokens, i, "")
+			}
+			out[i].str = localRE.ReplaceAllString(t.str, "${1}"+s.global+".$2")
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,78 @@
+package mary
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssembleLocalLabels(t *testing.T) {
+	p, err := Assemble(strings.NewReader(`
+Count	EQU 2
+A,	Load N
+.loop,	Subt One	/ A.loop
+	Skipcond 400
+	Jump .loop
+	Jump B.loop
+B,	Clear
+.loop,	Add One		/ B.loop
+	Jump .loop+1
+	Halt
+N,	DEC 3
+One,	DEC 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Word{0x1009, 0x400A, 0x8400, 0x9001, 0x9006, 0xA000, 0x300A, 0x9007, 0x7000, 3, 1}
+	if !reflect.DeepEqual(p.Words, want) {
+		t.Errorf("Words = %04x, want %04x", p.Words, want)
+	}
+	if p.Symbols["A.loop"] != 1 || p.Symbols["B.loop"] != 6 {
+		t.Errorf("Symbols = %v, want A.loop at 001 and B.loop at 006", p.Symbols)
+	}
+	if _, ok := p.Symbols[".loop"]; ok {
+		t.Errorf("Symbols has the unqualified .loop")
+	}
+	// Debugger expressions and expectations name local labels by their qualified names.
+	e, err := ParseExpr("B.loop+1", p.Symbols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Eval(new(Machine)); got != 7 {
+		t.Errorf("B.loop+1 = %d, want 7", got)
+	}
+}
+
+func TestAssembleLocalLabelsInParentheses(t *testing.T) {
+	p, err := Assemble(strings.NewReader(`
+Main,	Load (.tbl+1)*1
+Twice	MACRO p
+	Add p*2
+	ENDM
+	Twice .tbl+1
+	Halt
+.tbl,	DEC 5
+	DEC 6
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Main.tbl is at 003, as a macro argument too.
+	want := []Word{0x1004, 0x3008, 0x7000, 5, 6}
+	if !reflect.DeepEqual(p.Words, want) {
+		t.Errorf("Words = %04x, want %04x", p.Words, want)
+	}
+}
+
+func TestAssembleLocalLabelErrors(t *testing.T) {
+	for _, c := range []struct{ src, want string }{
+		{".loop, Jump .loop\n", "syntax: line 1: .loop, Jump .loop: local label .loop comes before any label"},
+		{"X EQU 1\nJump .end-1\n", "syntax: line 2: Jump .end-1: local label .end comes before any label"},
+	} {
+		_, err := Assemble(strings.NewReader(c.src))
+		if err == nil || err.Error() != c.want {
+			t.Errorf("Assemble(%q) = %v, want %s", c.src, err, c.want)
+		}
+	}
+}
@@ -1,12 +1,29 @@
-package main
+package mary
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
 )
 
 // Word is the machine's 16 bit data bus.
-type Word int
+// Arithmetic on words wraps around; use Signed for the two's complement value of a word.
+type Word uint16
+
+// Signed returns the two's complement interpretation of w.
+func (w Word) Signed() int16 {
+	return int16(w)
+}
 
 // machineMemory is the number of words in the machine's 12-bit addressed memory.
 const machineMemory = 1 << 12 // 4096
@@ -14,44 +31,637 @@ const machineMemory = 1 << 12 // 4096
 // Machine simulates a Marie machine. Most of the registers are not needed for the simulation,
 // but they are added to illustrate the Marie machine described in the book.
 type Machine struct {
-	AC Word
-	PC Word
+	AC  Word
+	PC  Word
 	MAR Word
 	MBR Word
-	IR Word
-	IN Word
+	IR  Word
+	IN  Word
 	OUT Word
-	M [machineMemory]Word
+
+	// M is the machine's memory. A nil M is replaced by a DenseMemory on first use.
+	M Memory
+
+	// Halted reports whether the machine has executed a Halt instruction.
+	Halted bool
+
+	// Steps is the number of instructions the machine has executed.
+	Steps int
+
+	// Stdin, Stdout and Stderr are the streams used by Input, Output and Dump,
+	// and for reporting faults. Nil streams default to os.Stdin, os.Stdout and os.Stderr.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Source, if non-nil, supplies the values read by Input instructions in place of Stdin,
+	// and no prompt is printed.
+	Source InputSource
+
+	// Sink receives the values produced by Output instructions.
+	// A nil Sink prints them to Stdout with a WriterSink in OutputMode.
+	Sink       OutputSink
+	OutputMode OutputMode
+
+	// InputMode is how Input parses the lines of Stdin: hex by default.
+	InputMode InputMode
+
+	// Prompt is printed to Stdout before Input reads a value. An empty Prompt means "> ".
+	// No prompt is printed if NoPrompt is set or Stdin is not a terminal,
+	// so that prompts don't end up mixed into captured output.
+	Prompt   string
+	NoPrompt bool
+
+	// InputEOF selects what Input does when Stdin or Source is exhausted.
+	// With EOFSentinel, Input loads Sentinel into AC instead of faulting. With EOFTrap, the machine
+	// stops before the Input, as at a breakpoint, so that it can read more once given it.
+	InputEOF EOFMode
+	Sentinel Word
+
+	// MaxSteps, if positive, is the number of instructions Run executes before giving up.
+	// It guards against programs that loop forever.
+	MaxSteps int
+
+	// Clock is the machine's source of time. A nil Clock is a RealClock.
+	// If Deadline is not zero, Run stops once the clock passes it.
+	Clock    Clock
+	Deadline time.Time
+
+	// Allow, if non-nil, is the set of opcodes the machine may execute.
+	// Deny is a set of opcodes the machine must not execute.
+	// Both are checked when an instruction is executed rather than when it is assembled,
+	// so instructions constructed at runtime are caught too.
+	Allow map[Opcode]bool
+	Deny  map[Opcode]bool
+
+	// Format is how Dump, the debugger and the errors the machine returns print numbers.
+	// The zero FormatDefault prints as FormatBook, except that Dump prints as it always has.
+	Format NumberFormat
+
+	// Assembler is how Load assembles programs.
+	Assembler Assembler
+
+	// hooks are the observers registered with AddHooks, and opHooks those registered with HookOpcode.
+	hooks   []*Hooks
+	opHooks [1 << 4][]OpcodeHook
+
+	// program is the program written by the last Load, restored by ResetKeepProgram.
+	program Program
+
+	// breakpoints maps the address of each breakpoint to its condition, nil if it is unconditional.
+	breakpoints map[Word]func(*Machine) bool
+
+	// breakConds are breakpoint conditions checked before every instruction.
+	breakConds []func(*Machine) bool
+
+	// in scans Stdin. It is kept between Input instructions so buffered input is not lost.
+	in    *bufio.Scanner
+	inSrc io.Reader
+
+	// inReady records that trapInput has read inValue, or failed with inErr, for the Input about to execute.
+	inReady bool
+	inValue Word
+	inErr   error
 }
 
 // Run starts execution of the program stored in the machine's memory.
-func (m *Machine) Run() {
-	for {
-		m.MAR = m.PC
-		m.MBR = m.M[m.PC]
-		m.IR = m.MBR
-		m.PC++
-		opcode := Opcode(m.IR >> 12)
-		operand := m.IR & 0xFFF
-		instruction[opcode](m, operand)
+// It returns when the machine halts, or with a *RuntimeError when an instruction faults.
+func (m *Machine) Run() error {
+	return m.RunContext(context.Background())
+}
+
+// ErrDeadline is returned, wrapped, by Run when the machine's clock passes its Deadline.
+var ErrDeadline = errors.New("deadline exceeded")
+
+// ErrBreakpoint is returned by Run when the machine reaches a breakpoint.
+var ErrBreakpoint = errors.New("breakpoint")
+
+// RunContext is like Run but stops early when ctx is done, returning ctx.Err() wrapped.
+// The machine is left ready to continue from the next instruction.
+//
+// Run and RunContext also stop, returning ErrBreakpoint, before executing an instruction
+// at a breakpoint other than the first one executed.
+func (m *Machine) RunContext(ctx context.Context) error {
+	done := ctx.Done()
+	for first := true; !m.Halted; first = false {
+		if !first && m.atBreakpoint() {
+			return ErrBreakpoint
+		}
+		select {
+		case <-done:
+			return fmt.Errorf("run stopped at %03x: %w", m.PC, ctx.Err())
+		default:
+		}
+		if m.MaxSteps > 0 && m.Steps >= m.MaxSteps {
+			return fmt.Errorf("run stopped at %03x: exceeded %s instructions", m.PC, commas(m.MaxSteps))
+		}
+		if !m.Deadline.IsZero() && m.clock().Now().After(m.Deadline) {
+			return fmt.Errorf("run stopped at %03x: %w", m.PC, ErrDeadline)
+		}
+		if _, err := m.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commas formats non-negative n in decimal with thousands separators. eg., "1,000,000".
+func commas(n int) string {
+	s := strconv.Itoa(n)
+	start := len(s) % 3
+	if start == 0 {
+		start = 3
+	}
+	out := s[:start]
+	for i := start; i < len(s); i += 3 {
+		out += "," + s[i:i+3]
+	}
+	return out
+}
+
+// Fork returns a copy of the machine that can run independently of m.
+// Memory is copied with M.Fork, so it is cheap when M is a SparseMemory.
+// Hooks are not copied to the fork.
+//
+// An InputScript Source is copied, so the fork reads the values m has yet to read without
+// consuming them from m. Any other Source, and Stdin, Stdout, Stderr and Sink, are shared:
+// input the fork reads from them is gone for m. Give the fork its own Stdin or Source
+// to explore alternatives that read input.
+func (m *Machine) Fork() *Machine {
+	f := *m
+	if s, ok := m.Source.(*InputScript); ok {
+		f.Source = &InputScript{append([]Word(nil), s.Values...)}
+	}
+	f.in, f.inSrc = nil, nil
+	f.hooks = nil
+	f.opHooks = [1 << 4][]OpcodeHook{}
+	f.breakpoints = make(map[Word]func(*Machine) bool, len(m.breakpoints))
+	for addr, cond := range m.breakpoints {
+		f.breakpoints[addr] = cond
+	}
+	f.breakConds = append([]func(*Machine) bool(nil), m.breakConds...)
+	if m.M != nil {
+		f.M = m.M.Fork()
+	}
+	return &f
+}
+
+// AddBreakpoint sets a breakpoint on the instruction at addr.
+func (m *Machine) AddBreakpoint(addr Word) {
+	m.AddBreakpointIf(addr, nil)
+}
+
+// AddBreakpointIf sets a breakpoint on the instruction at addr that only stops the machine when cond returns true.
+// A nil cond always stops the machine. It replaces any breakpoint already at addr.
+func (m *Machine) AddBreakpointIf(addr Word, cond func(*Machine) bool) {
+	if m.breakpoints == nil {
+		m.breakpoints = make(map[Word]func(*Machine) bool)
 	}
+	m.breakpoints[addr&(machineMemory-1)] = cond
 }
 
-// Load loads f to the machine's memory.
+// AddBreakCondition sets a breakpoint that stops the machine before any instruction when cond returns true.
+func (m *Machine) AddBreakCondition(cond func(*Machine) bool) {
+	m.breakConds = append(m.breakConds, cond)
+}
+
+// RemoveBreakpoint clears the breakpoint at addr, if any.
+func (m *Machine) RemoveBreakpoint(addr Word) {
+	delete(m.breakpoints, addr&(machineMemory-1))
+}
+
+// ClearBreakConditions removes every breakpoint added with AddBreakCondition.
+func (m *Machine) ClearBreakConditions() {
+	m.breakConds = nil
+}
+
+// atBreakpoint reports whether a breakpoint stops the machine before the instruction at PC.
+func (m *Machine) atBreakpoint() bool {
+	cond, ok := m.breakpoints[m.PC&(machineMemory-1)]
+	if ok && (cond == nil || cond(m)) {
+		return true
+	}
+	for _, cond := range m.breakConds {
+		if cond(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Breakpoints returns the addresses of the breakpoints in increasing order.
+func (m *Machine) Breakpoints() []Word {
+	var addrs []Word
+	for addr := range m.breakpoints {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// StepResult describes an instruction executed by Machine.Step.
+type StepResult struct {
+	Addr    Word // address the instruction was fetched from
+	Opcode  Opcode
+	Operand Word
+	Halted  bool  // whether the machine halted after executing the instruction
+	Err     error // the fault, if the instruction faulted
+}
+
+// Step executes exactly one fetch-decode-execute cycle.
+// It returns a *RuntimeError if the instruction faults.
+func (m *Machine) Step() (StepResult, error) {
+	m.MAR = m.PC
+	m.MBR = m.peek(m.PC)
+	m.IR = m.MBR
+	m.PC++
+	addr := m.MAR
+	opcode, operand := Decode(m.IR)
+	if err := m.trapInput(m.IR); err != nil {
+		// The Input was undone, to be executed again, so no step took place and no hook sees it.
+		return StepResult{addr, opcode, operand, m.Halted, err}, err
+	}
+	for _, h := range m.hooks {
+		if h.OnFetch != nil {
+			h.OnFetch(addr, m.IR)
+		}
+	}
+	m.Steps++
+	err := Execute(m, m.IR)
+	r := StepResult{addr, opcode, operand, m.Halted, err}
+	if m.Clock != nil {
+		m.Clock.Tick()
+	}
+	for _, h := range m.hooks {
+		if h.OnStep != nil {
+			h.OnStep(r)
+		}
+	}
+	return r, err
+}
+
+// Decode splits an instruction word into its opcode and 12-bit operand.
+func Decode(w Word) (Opcode, Word) {
+	return Opcode(w >> 12 & 0xF), w & 0xFFF
+}
+
+// Execute executes the instruction w on m as if it had just been fetched into IR from PC-1.
+// Unlike Step it does not fetch from memory or advance PC.
+// It returns a *RuntimeError if the instruction faults.
+func Execute(m *Machine, w Word) error {
+	m.IR = w
+	pc := (m.PC - 1) & (machineMemory - 1)
+	opcode, operand := Decode(w)
+	if m.forbidden(opcode) {
+		return &RuntimeError{PC: pc, IR: w, Reason: fmt.Sprintf("forbidden instruction %s", opcode), Err: ErrForbiddenInstruction, Format: m.Format}
+	}
+	if err := m.trapInput(w); err != nil {
+		return err
+	}
+	for _, h := range m.hooks {
+		if h.OnExecute != nil {
+			h.OnExecute(opcode, operand)
+		}
+	}
+	for _, fn := range m.opHooks[opcode] {
+		fn(m, operand)
+	}
+	fn := instruction[opcode]
+	if fn == nil {
+		return &RuntimeError{PC: pc, IR: w, Reason: fmt.Sprintf("illegal opcode %x", opcode), Err: ErrIllegalInstruction, Format: m.Format}
+	}
+	if err := fn(m, operand); err != nil {
+		return &RuntimeError{PC: pc, IR: w, Reason: err.Error(), Err: err, Format: m.Format}
+	}
+	return nil
+}
+
+// forbidden reports whether the machine's Allow or Deny policy does not permit op.
+func (m *Machine) forbidden(op Opcode) bool {
+	return m.Allow != nil && !m.Allow[op] || m.Deny[op]
+}
+
+// ErrIllegalInstruction is the underlying error of a RuntimeError raised by an opcode the machine does not implement.
+var ErrIllegalInstruction = errors.New("illegal instruction")
+
+// ErrForbiddenInstruction is the underlying error of a RuntimeError raised by an opcode
+// that the machine's Allow or Deny policy does not permit.
+var ErrForbiddenInstruction = errors.New("forbidden instruction")
+
+// RuntimeError is a fault raised while executing an instruction.
+type RuntimeError struct {
+	PC     Word   // address of the faulting instruction
+	IR     Word   // the faulting instruction
+	Reason string // what went wrong
+	Err    error  // underlying error, if any
+
+	Format NumberFormat // how Error prints PC and IR
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("runtime: %s: %s: %s", e.Format.Addr(e.PC), e.Format.Word(e.IR), e.Reason)
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// Load loads f to the machine's memory. f is assembled, unless it is an object file written by
+// WriteObject, which is loaded as it is.
+// A syntax error is reported with the file's name, and wraps the SyntaxError or SyntaxErrors
+// of the assembler so that its kind can be tested with errors.Is.
 func (m *Machine) Load(f *os.File) error {
-	program, err := Assemble(f)
-	switch err := err.(type) {
-	case nil:
+	if isObject(f) {
+		p, err := ReadObject(f)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name(), err)
+		}
+		return m.LoadProgram(p)
+	}
+	program, err := m.Assembler.assemble(f, filepath.Dir(f.Name()), f.Name())
+	if err != nil {
+		return newLoadError(f.Name(), err)
+	}
+	return m.LoadProgram(program)
+}
+
+// LoadFiles assembles the named files as one program, as Assembler.AssembleFiles does, and loads
+// it to the machine's memory. Syntax errors are reported as by Load, with the name of their file.
+func (m *Machine) LoadFiles(names ...string) error {
+	program, err := m.Assembler.AssembleFiles(names...)
+	if err != nil {
+		return newLoadError(names[0], err)
+	}
+	return m.LoadProgram(program)
+}
+
+// newLoadError returns the error of Load for the assembler's error err, in the program whose first
+// file is name.
+func newLoadError(name string, err error) error {
+	var errs SyntaxErrors
+	switch e := err.(type) {
 	case SyntaxError:
-		return fmt.Errorf("syntax: %s:%d: %s\n", f.Name(), err.lineNo, err.line)
+		errs = SyntaxErrors{e}
+	case SyntaxErrors:
+		errs = e
+	default:
+		return fmt.Errorf("%w", err)
+	}
+	var b strings.Builder
+	for _, s := range errs {
+		file := name
+		if s.file != "" {
+			file = s.file
+		}
+		fmt.Fprintf(&b, "syntax: %s:%d: %s\n%s", file, s.lineNo, s.detail(), s.Caret())
+	}
+	return &loadError{b.String(), err}
+}
+
+// loadError is an error of Load, with the message it prints and the assembler's error.
+type loadError struct {
+	msg string
+	err error
+}
+
+func (e *loadError) Error() string {
+	return e.msg
+}
+
+func (e *loadError) Unwrap() error {
+	return e.err
+}
+
+// LoadProgram writes the assembled program p to the machine's memory at its origin,
+// and sets PC to the origin.
+func (m *Machine) LoadProgram(p Program) error {
+	if int(p.Origin)+len(p.Words) >= machineMemory {
+		return fmt.Errorf("program too long: %d/%d instructions", int(p.Origin)+len(p.Words), machineMemory)
+	}
+	m.program = p
+	m.PC = p.Origin
+	return m.WriteMemory(p.Origin, p.Words)
+}
+
+// LoadSnippet relocates the assembled program p to origin and writes it to the machine's memory,
+// leaving PC and the loaded program alone. It returns the relocated program, whose Symbols give
+// the addresses of the snippet's labels. The snippet may not overlap the loaded program.
+func (m *Machine) LoadSnippet(p Program, origin Word) (Program, error) {
+	q, err := p.Relocate(origin)
+	if err != nil {
+		return Program{}, err
+	}
+	start, end := int(m.program.Origin), int(m.program.Origin)+len(m.program.Words)
+	if int(origin) < end && start < int(origin)+len(q.Words) {
+		return Program{}, fmt.Errorf("snippet at %03x-%03x overlaps the program at %03x-%03x",
+			origin, int(origin)+len(q.Words)-1, start, end-1)
+	}
+	return q, m.WriteMemory(origin, q.Words)
+}
+
+// SetSymbol writes w to the word labelled name in the loaded program.
+// Together with labelled DEC or HEX words it lets a program take arguments:
+// data words act as parameters whose assembled values are only defaults.
+func (m *Machine) SetSymbol(name string, w Word) error {
+	addr, ok := m.program.Symbols[name]
+	if !ok {
+		return fmt.Errorf("undefined label: %s", name)
+	}
+	return m.WriteMemory(addr, []Word{w})
+}
+
+// Program returns the program written by the last Load or LoadProgram.
+func (m *Machine) Program() Program {
+	return m.program
+}
+
+// Reset zeroes the machine's registers and memory so it can be used again.
+// Configuration such as the I/O streams, MaxSteps, Allow and Deny is kept.
+// A DenseMemory or SparseMemory is replaced by a new empty one, releasing any pages shared with forks;
+// other memories are zeroed word by word. Resetting does not call OnMemWrite hooks.
+func (m *Machine) Reset() {
+	m.resetRegisters()
+	m.program = Program{}
+	switch m.M.(type) {
+	case nil:
+	case *DenseMemory:
+		m.M = new(DenseMemory)
+	case *SparseMemory:
+		m.M = NewSparseMemory()
 	default:
-		return fmt.Errorf("%v", err)
+		for addr := Word(0); addr < machineMemory; addr++ {
+			m.poke(addr, 0)
+		}
 	}
-	if len(program) >= machineMemory {
-		return fmt.Errorf("program too long: %d/%d instructions", len(program), machineMemory)
+}
+
+// ResetKeepProgram zeroes the machine's registers and restores memory to the program loaded by the last Load,
+// undoing any writes made while it ran. PC is set to the program's origin.
+func (m *Machine) ResetKeepProgram() {
+	program := m.program
+	m.Reset()
+	m.program = program
+	m.PC = program.Origin
+	// The program fitted in memory when it was loaded, so it is written without checking its range again.
+	for i, w := range program.Words {
+		m.poke(program.Origin+Word(i), w)
+	}
+}
+
+func (m *Machine) resetRegisters() {
+	m.AC, m.PC, m.MAR, m.MBR, m.IR, m.IN, m.OUT = 0, 0, 0, 0, 0, 0, 0
+	m.Halted = false
+	m.Steps = 0
+	m.inReady = false
+}
+
+// ReadMemory returns a copy of the n words of memory starting at address start.
+func (m *Machine) ReadMemory(start Word, n int) ([]Word, error) {
+	if err := checkRange(start, n); err != nil {
+		return nil, err
 	}
-	for i, w := range program {
-		m.M[i] = w
+	out := make([]Word, n)
+	for i := range out {
+		out[i] = m.peek(start + Word(i))
+	}
+	return out, nil
+}
+
+// WriteMemory writes words to memory starting at address start.
+// Callers outside the machine should use it rather than writing M directly,
+// so that OnMemWrite hooks see the change.
+func (m *Machine) WriteMemory(start Word, words []Word) error {
+	if err := checkRange(start, len(words)); err != nil {
+		return err
+	}
+	for i, w := range words {
+		m.write(start+Word(i), w)
 	}
 	return nil
 }
+
+// read returns the word at addr on behalf of an instruction, notifying OnMemRead hooks.
+func (m *Machine) read(addr Word) Word {
+	addr &= machineMemory - 1
+	val := m.peek(addr)
+	for _, h := range m.hooks {
+		if h.OnMemRead != nil {
+			h.OnMemRead(addr, val)
+		}
+	}
+	return val
+}
+
+// write stores w at addr on behalf of an instruction, notifying OnMemWrite hooks.
+func (m *Machine) write(addr Word, w Word) {
+	addr &= machineMemory - 1
+	if len(m.hooks) > 0 {
+		old := m.peek(addr)
+		for _, h := range m.hooks {
+			if h.OnMemWrite != nil {
+				h.OnMemWrite(addr, old, w)
+			}
+		}
+	}
+	m.poke(addr, w)
+}
+
+// peek returns the word at addr without notifying hooks.
+// Only the low 12 bits of addr are used, as on the machine's address bus.
+func (m *Machine) peek(addr Word) Word {
+	return m.Memory().Read(addr & (machineMemory - 1))
+}
+
+// poke stores w at addr without notifying hooks.
+// Only the low 12 bits of addr are used, as on the machine's address bus.
+func (m *Machine) poke(addr Word, w Word) {
+	m.Memory().Write(addr&(machineMemory-1), w)
+}
+
+func checkRange(start Word, n int) error {
+	if n < 0 || int(start)+n > machineMemory {
+		return fmt.Errorf("memory range out of bounds: %03x+%d", start, n)
+	}
+	return nil
+}
+
+// EOFMode is the behaviour of Input at the end of input.
+type EOFMode int
+
+const (
+	EOFFault    EOFMode = iota // fault with ErrInputEOF
+	EOFSentinel                // load Machine.Sentinel
+	EOFTrap                    // fault with ErrInputTrap, leaving PC at the Input to execute it again
+)
+
+func (m *Machine) stdin() io.Reader {
+	if m.Stdin == nil {
+		return os.Stdin
+	}
+	return m.Stdin
+}
+
+// prompt returns the text to print before Input reads a value.
+func (m *Machine) prompt() string {
+	switch {
+	case m.NoPrompt || !isTerminal(m.stdin()):
+		return ""
+	case m.Prompt == "":
+		return "> "
+	default:
+		return m.Prompt
+	}
+}
+
+// isTerminal reports whether r is an interactive terminal.
+// Readers that stand in for a terminal may say so with an IsTerminal method.
+func isTerminal(r io.Reader) bool {
+	if t, ok := r.(interface{ IsTerminal() bool }); ok {
+		return t.IsTerminal()
+	}
+	f, ok := r.(*os.File)
+	// A character device is not necessarily a terminal: /dev/null is one too.
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// scanner returns the scanner over the machine's input stream. It scans a terminal by lines,
+// as they are typed. Other input, such as a pipe, is scanned by whitespace-separated words, or
+// by characters in InputASCII, so that all the values may be given on one line.
+func (m *Machine) scanner() *bufio.Scanner {
+	src := m.stdin()
+	if m.in == nil || m.inSrc != src {
+		m.in = bufio.NewScanner(src)
+		m.inSrc = src
+		if !isTerminal(src) {
+			// The mode is looked up for each value, as the debugger may change it while the program runs.
+			m.in.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+				if m.InputMode == InputASCII {
+					return bufio.ScanRunes(data, atEOF)
+				}
+				return bufio.ScanWords(data, atEOF)
+			})
+		}
+	}
+	return m.in
+}
+
+func (m *Machine) clock() Clock {
+	if m.Clock == nil {
+		return RealClock{}
+	}
+	return m.Clock
+}
+
+func (m *Machine) stdout() io.Writer {
+	if m.Stdout == nil {
+		return os.Stdout
+	}
+	return m.Stdout
+}
+
+func (m *Machine) stderr() io.Writer {
+	if m.Stderr == nil {
+		return os.Stderr
+	}
+	return m.Stderr
+}
@@ -0,0 +1,677 @@
+package mary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStepAllocs(t *testing.T) {
+	p, err := Assemble(strings.NewReader(`
+Loop,	Load X
+	Add One
+	Store X
+	AddI Ptr
+	StoreI Ptr
+	Skipcond 400
+	Jump Loop
+	Jump Loop
+X,	DEC 0
+One,	DEC 1
+Ptr,	HEX 100
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := new(Machine)
+	if err := m.LoadProgram(p); err != nil {
+		t.Fatal(err)
+	}
+	allocs := testing.AllocsPerRun(10000, func() {
+		if _, err := m.Step(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Step allocates %v times per instruction, want 0", allocs)
+	}
+}
+
+func TestForkInputScript(t *testing.T) {
+	m := &Machine{Source: &InputScript{[]Word{7}}}
+	m.poke(0, Word(OpInput)<<12)
+	f := m.Fork()
+	if _, err := f.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Step(); err != nil {
+		t.Fatalf("parent after fork read its input: %v", err)
+	}
+	if m.AC != 7 || f.AC != 7 {
+		t.Errorf("AC = %04x in parent, %04x in fork, want 0007", m.AC, f.AC)
+	}
+}
+
+func TestResetForkedSparseMemory(t *testing.T) {
+	m := &Machine{M: NewSparseMemory()}
+	if err := m.LoadProgram(Program{Origin: 0x100, Words: []Word{0x7000, 42}}); err != nil {
+		t.Fatal(err)
+	}
+	f := m.Fork()
+	f.ResetKeepProgram()
+	if n := len(f.M.(*SparseMemory).pages); n != 1 {
+		t.Errorf("fork has %d pages after ResetKeepProgram, want 1", n)
+	}
+	f.Reset()
+	if n := len(f.M.(*SparseMemory).pages); n != 0 {
+		t.Errorf("fork has %d pages after Reset, want 0", n)
+	}
+	if w := m.peek(0x101); w != 42 {
+		t.Errorf("parent M[101] = %04x after resetting fork, want 002a", w)
+	}
+}
+
+func TestLoadSnippet(t *testing.T) {
+	twice, err := Assemble(strings.NewReader(`
+Twice,	HEX 0
+	Load Arg
+	Add Arg
+	JumpI Twice
+Arg,	DEC 0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	main, err := Assemble(strings.NewReader("JnS 200\nHalt\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Machine{MaxSteps: 100}
+	if err := m.LoadProgram(main); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.LoadSnippet(twice, 1); err == nil {
+		t.Error("LoadSnippet over the program succeeded, want error")
+	}
+	q, err := m.LoadSnippet(twice, 0x200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Symbols["Arg"] != 0x204 {
+		t.Errorf("Arg relocated to %03x, want 204", q.Symbols["Arg"])
+	}
+	if err := m.WriteMemory(q.Symbols["Arg"], []Word{21}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if m.AC != 42 {
+		t.Errorf("AC = %d, want 42", m.AC)
+	}
+}
+
+// terminal is an io.Reader that stands in for a terminal.
+type terminal struct {
+	io.Reader
+}
+
+func (terminal) IsTerminal() bool {
+	return true
+}
+
+// readInputs executes Input n times on a machine reading stdin in mode, returning the values
+// read, the fault that stopped it, if any, and what it printed to stderr.
+func readInputs(stdin io.Reader, mode InputMode, n int) ([]Word, error, string) {
+	var stderr strings.Builder
+	m := &Machine{Stdin: stdin, Stdout: io.Discard, Stderr: &stderr, InputMode: mode}
+	var got []Word
+	for i := 0; i < n; i++ {
+		m.PC = 0
+		m.poke(0, Word(OpInput)<<12)
+		if _, err := m.Step(); err != nil {
+			return got, err, stderr.String()
+		}
+		got = append(got, m.AC)
+	}
+	return got, nil, stderr.String()
+}
+
+func TestInputModes(t *testing.T) {
+	for _, tt := range []struct {
+		mode   InputMode
+		input  string
+		want   []Word
+		stderr string // an entry reported as bad and prompted for again
+	}{
+		{InputHex, "1F\n-2\n", []Word{0x1F, 0xFFFE}, ""},
+		{InputDecimal, "31\n-2\n", []Word{31, 0xFFFE}, ""},
+		{InputASCII, "a\n\n", []Word{'a', '\n'}, ""},
+		{InputASCII, "ab\nb\n", []Word{'b'}, `"ab" is not one character` + "\n"},
+		{InputDecimal, "1F\n7\n", []Word{7}, `strconv.ParseInt: parsing "1F": invalid syntax` + "\n"},
+	} {
+		got, err, stderr := readInputs(terminal{strings.NewReader(tt.input)}, tt.mode, len(tt.want))
+		if err != nil || !reflect.DeepEqual(got, tt.want) || stderr != tt.stderr {
+			t.Errorf("%s %q: read %04x, %v, stderr %q; want %04x, stderr %q", tt.mode, tt.input, got, err, stderr, tt.want, tt.stderr)
+		}
+	}
+}
+
+func TestPipedInput(t *testing.T) {
+	for _, tt := range []struct {
+		mode  InputMode
+		input string
+		want  []Word
+		err   string
+	}{
+		{InputHex, "a 5\n  -2\n", []Word{0xA, 5, 0xFFFE}, ""},
+		{InputDecimal, "10\t5", []Word{10, 5}, ""},
+		{InputASCII, "hi\n", []Word{'h', 'i', '\n'}, ""},
+		{InputDecimal, "1 1F", []Word{1}, `runtime: 000: 5000: input: strconv.ParseInt: parsing "1F": invalid syntax`},
+	} {
+		if tt.err == "" {
+			// Reading past the values finds the end of input.
+			tt.err = "runtime: 000: 5000: input: end of file"
+		}
+		got, err, _ := readInputs(strings.NewReader(tt.input), tt.mode, len(tt.want)+1)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %q: read %04x, want %04x", tt.mode, tt.input, got, tt.want)
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s %q: %v, want %s", tt.mode, tt.input, err, tt.err)
+		}
+	}
+}
+
+func TestInputEOF(t *testing.T) {
+	p, err := Assemble(strings.NewReader("Input\nInput\nAdd X\nHalt\nX, DEC 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stdin := range []bool{false, true} {
+		newMachine := func(mode EOFMode) *Machine {
+			m := &Machine{InputEOF: mode, Sentinel: 0xFFFF, Stdout: io.Discard}
+			if stdin {
+				m.Stdin = strings.NewReader("7")
+			} else {
+				m.Source = &InputScript{[]Word{7}}
+			}
+			if err := m.LoadProgram(p); err != nil {
+				t.Fatal(err)
+			}
+			return m
+		}
+
+		m := newMachine(EOFFault)
+		if err := m.Run(); !errors.Is(err, ErrInputEOF) || errors.Is(err, ErrInputTrap) || m.PC != 2 {
+			t.Errorf("stdin %v: fault: Run = %v at PC %03x, want ErrInputEOF after the second Input", stdin, err, m.PC)
+		}
+
+		m = newMachine(EOFSentinel)
+		if err := m.Run(); err != nil || m.AC != 0 || m.IN != 0xFFFF {
+			t.Errorf("stdin %v: sentinel: Run = %v, AC=%04x IN=%04x; want FFFF read and 1 added", stdin, err, m.AC, m.IN)
+		}
+
+		m = newMachine(EOFTrap)
+		err := m.Run()
+		var rerr *RuntimeError
+		if !errors.As(err, &rerr) || !errors.Is(err, ErrInputTrap) || rerr.PC != 1 || m.PC != 1 || m.Steps != 1 {
+			t.Fatalf("stdin %v: trap: Run = %v at PC %03x after %d steps, want ErrInputTrap before the second Input", stdin, err, m.PC, m.Steps)
+		}
+		if stdin {
+			m.Stdin = strings.NewReader("2")
+		} else {
+			m.Source.(*InputScript).Values = []Word{2}
+		}
+		if err := m.Run(); err != nil || m.AC != 3 || m.Steps != 4 {
+			t.Errorf("stdin %v: trap: continued Run = %v, AC=%04x after %d steps; want 3 after 4", stdin, err, m.AC, m.Steps)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for w, want := range map[Word]struct {
+		op      Opcode
+		operand Word
+	}{
+		0x0000: {OpJnS, 0}, 0x1ABC: {OpLoad, 0xABC}, 0x7000: {OpHalt, 0}, 0xF123: {OpDump, 0x123},
+	} {
+		if op, operand := Decode(w); op != want.op || operand != want.operand {
+			t.Errorf("Decode(%04x) = %v, %03x; want %v, %03x", w, op, operand, want.op, want.operand)
+		}
+	}
+}
+
+func TestExecute(t *testing.T) {
+	p, err := Assemble(strings.NewReader("Halt\nX, DEC 5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Machine{InputEOF: EOFTrap, Source: &InputScript{}}
+	if err := m.LoadProgram(p); err != nil {
+		t.Fatal(err)
+	}
+	m.PC = 0x100
+	if err := Execute(m, 0x1001); err != nil || m.AC != 5 || m.IR != 0x1001 || m.PC != 0x100 || m.Steps != 0 {
+		t.Errorf("Execute(Load X) = %v, AC=%04x IR=%04x PC=%03x after %d steps; want AC=0005 IR=1001 PC=100 after 0", err, m.AC, m.IR, m.PC, m.Steps)
+	}
+	// A trapped Input leaves PC at the Input, but only Step counts steps.
+	err = Execute(m, 0x5000)
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) || !errors.Is(err, ErrInputTrap) || rerr.PC != 0x0FF || m.PC != 0x0FF || m.Steps != 0 {
+		t.Errorf("Execute(Input) = %v, PC=%03x after %d steps; want ErrInputTrap at 0FF after 0", err, m.PC, m.Steps)
+	}
+	m.PC = 0
+	if _, err := m.Step(); err != nil || !m.Halted || m.Steps != 1 {
+		t.Errorf("Step = %v, halted %v after %d steps; want a Halt after 1", err, m.Halted, m.Steps)
+	}
+}
+
+func TestForbiddenInstruction(t *testing.T) {
+	for _, c := range []struct {
+		src         string
+		allow, deny map[Opcode]bool
+		pc, ir      Word
+	}{
+		{"Load X\nAdd X\nHalt\nX, DEC 1\n", nil, map[Opcode]bool{OpAdd: true}, 1, 0x3003},
+		{"Load X\nOutput\nHalt\nX, DEC 1\n", map[Opcode]bool{OpLoad: true, OpHalt: true}, nil, 1, 0x6000},
+		// P holds a Halt until the Store replaces it with an Add.
+		{"Load I\nStore P\nP, HEX 7000\nI, HEX 3003\n", nil, map[Opcode]bool{OpAdd: true}, 2, 0x3003},
+		{"Load I\nStore P\nP, HEX 7000\nI, HEX 3003\n", map[Opcode]bool{OpLoad: true, OpStore: true, OpHalt: true}, nil, 2, 0x3003},
+	} {
+		p, err := Assemble(strings.NewReader(c.src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := &Machine{Allow: c.allow, Deny: c.deny, Stdout: io.Discard}
+		if err := m.LoadProgram(p); err != nil {
+			t.Fatal(err)
+		}
+		err = m.Run()
+		var rerr *RuntimeError
+		if !errors.As(err, &rerr) || !errors.Is(err, ErrForbiddenInstruction) || rerr.PC != c.pc || rerr.IR != c.ir {
+			t.Errorf("%q: Run = %v, want ErrForbiddenInstruction at %03x: %04x", c.src, err, c.pc, c.ir)
+		}
+	}
+}
+
+func TestWordSigned(t *testing.T) {
+	for w, want := range map[Word]int16{0: 0, 0x7FFF: 32767, 0x8000: -32768, 0xFFFE: -2, 0xFFFF: -1} {
+		if got := w.Signed(); got != want {
+			t.Errorf("Word(%04x).Signed() = %d, want %d", w, got, want)
+		}
+	}
+}
+
+func TestSkipcondNegative(t *testing.T) {
+	for _, tt := range []struct {
+		ac   Word
+		cond Word
+		skip bool
+	}{
+		{0xFFFF, 0x000, true},
+		{0x8000, 0x000, true},
+		{0x7FFF, 0x000, false},
+		{0xFFFF, 0x800, false},
+		{0x8000, 0x800, false},
+		{0x7FFF, 0x800, true},
+		{0xFFFF, 0x400, false},
+	} {
+		m := &Machine{AC: tt.ac}
+		m.poke(0, Word(OpSkipcond)<<12|tt.cond)
+		if _, err := m.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if skipped := m.PC == 2; skipped != tt.skip {
+			t.Errorf("Skipcond %03x with AC=%04x: PC = %03x, want skip %v", tt.cond, tt.ac, m.PC, tt.skip)
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	p, err := Assemble(strings.NewReader("Load X\nStore Y\nHalt\nX, DEC 5\nY, DEC 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := new(Machine)
+	if err := m.LoadProgram(p); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	record := func(name string) *Hooks {
+		log := func(format string, args ...any) {
+			got = append(got, name+" "+fmt.Sprintf(format, args...))
+		}
+		return &Hooks{
+			OnFetch:    func(pc, w Word) { log("fetch %03x %04x", pc, w) },
+			OnExecute:  func(op Opcode, operand Word) { log("execute %s %03x", op, operand) },
+			OnMemRead:  func(addr, val Word) { log("read %03x %04x", addr, val) },
+			OnMemWrite: func(addr, old, new Word) { log("write %03x %04x %04x", addr, old, new) },
+			OnStep:     func(r StepResult) { log("step %03x", r.Addr) },
+		}
+	}
+	a, b := record("a"), record("b")
+	m.AddHooks(a)
+	m.AddHooks(b)
+	if _, err := m.Step(); err != nil {
+		t.Fatal(err)
+	}
+	m.RemoveHooks(a)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"a fetch 000 1003", "b fetch 000 1003",
+		"a execute Load 003", "b execute Load 003",
+		"a read 003 0005", "b read 003 0005",
+		"a step 000", "b step 000",
+		"b fetch 001 2004", "b execute Store 004", "b write 004 0000 0005", "b step 001",
+		"b fetch 002 7000", "b execute Halt 000", "b step 002",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("hooks called:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestHookOpcode(t *testing.T) {
+	p, err := Assemble(strings.NewReader("Load X\nStore Y\nAdd X\nStore Y\nHalt\nX, DEC 5\nY, DEC 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := new(Machine)
+	if err := m.LoadProgram(p); err != nil {
+		t.Fatal(err)
+	}
+	var stores []string
+	m.HookOpcode(OpStore, func(m *Machine, operand Word) {
+		stores = append(stores, fmt.Sprintf("%03x AC=%04x", operand, m.AC))
+	})
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"006 AC=0005", "006 AC=000a"}; !reflect.DeepEqual(stores, want) {
+		t.Errorf("Store hook called with %q, want %q", stores, want)
+	}
+
+	m.ResetKeepProgram()
+	m.UnhookOpcode(OpStore)
+	stores = nil
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if stores != nil {
+		t.Errorf("Store hook called with %q after UnhookOpcode", stores)
+	}
+}
+
+func TestSparseMemory(t *testing.T) {
+	s := NewSparseMemory()
+	if w := s.Read(0x123); w != 0 {
+		t.Errorf("M[123] = %04x before any write, want 0000", w)
+	}
+	s.Write(0x200, 0)
+	if len(s.pages) != 0 {
+		t.Errorf("writing zero allocated %d pages, want 0", len(s.pages))
+	}
+	s.Write(0x123, 7)
+	s.Write(0xFFF, 9)
+	if s.Read(0x123) != 7 || s.Read(0xFFF) != 9 || s.Read(0x124) != 0 || len(s.pages) != 2 {
+		t.Errorf("M[123]=%04x M[FFF]=%04x M[124]=%04x in %d pages, want 7, 9 and 0 in 2", s.Read(0x123), s.Read(0xFFF), s.Read(0x124), len(s.pages))
+	}
+
+	// A fork and its parent do not see each other's writes.
+	f := s.Fork()
+	f.Write(0x123, 8)
+	s.Write(0xFFF, 10)
+	if s.Read(0x123) != 7 || f.Read(0x123) != 8 || s.Read(0xFFF) != 10 || f.Read(0xFFF) != 9 {
+		t.Errorf("after forking: parent M[123]=%04x M[FFF]=%04x, fork %04x %04x; want 7 10 and 8 9",
+			s.Read(0x123), s.Read(0xFFF), f.Read(0x123), f.Read(0xFFF))
+	}
+}
+
+func TestReadWriteMemory(t *testing.T) {
+	m := new(Machine)
+	var writes []string
+	m.AddHooks(&Hooks{OnMemWrite: func(addr, old, new Word) {
+		writes = append(writes, fmt.Sprintf("%03x %04x->%04x", addr, old, new))
+	}})
+	if err := m.WriteMemory(0xFFE, []Word{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.ReadMemory(0xFFD, 3)
+	if err != nil || !reflect.DeepEqual(got, []Word{0, 1, 2}) {
+		t.Errorf("ReadMemory(FFD, 3) = %04x, %v; want [0000 0001 0002]", got, err)
+	}
+	if want := []string{"ffe 0000->0001", "fff 0000->0002"}; !reflect.DeepEqual(writes, want) {
+		t.Errorf("OnMemWrite saw %q, want %q", writes, want)
+	}
+	got[0] = 5
+	if m.peek(0xFFD) != 0 {
+		t.Error("writing the slice ReadMemory returned changed memory")
+	}
+
+	// Ranges running off the end of memory fail without writing any of it.
+	if err := m.WriteMemory(0xFFF, []Word{3, 4}); err == nil {
+		t.Error("WriteMemory(FFF, 2 words) succeeded, want error")
+	}
+	if m.peek(0xFFF) != 2 {
+		t.Errorf("failed WriteMemory changed M[FFF] to %04x", m.peek(0xFFF))
+	}
+	for _, n := range []int{-1, 2} {
+		if _, err := m.ReadMemory(0xFFF, n); err == nil {
+			t.Errorf("ReadMemory(FFF, %d) succeeded, want error", n)
+		}
+	}
+}
+
+func TestMaxSteps(t *testing.T) {
+	p, err := Assemble(strings.NewReader("Loop, Add One\nJump Loop\nOne, DEC 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Machine{MaxSteps: 1000}
+	if err := m.LoadProgram(p); err != nil {
+		t.Fatal(err)
+	}
+	err = m.Run()
+	if err == nil || err.Error() != "run stopped at 000: exceeded 1,000 instructions" || m.Steps != 1000 || m.AC != 500 {
+		t.Errorf("Run = %v after %d steps with AC=%d, want it stopped after 1,000 with AC=500", err, m.Steps, m.AC)
+	}
+
+	// A program that halts within the limit is not stopped.
+	m = &Machine{MaxSteps: 3}
+	if err := m.LoadProgram(Program{Words: []Word{0x3002, 0x7000, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != nil || !m.Halted {
+		t.Errorf("Run = %v, halted %v; want a halt within 3 steps", err, m.Halted)
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	p, err := Assemble(strings.NewReader("Loop, Load N\nAdd One\nStore N\nSubt Ten\nSkipcond 400\nJump Loop\nHalt\nN, DEC 0\nOne, DEC 1\nTen, DEC 10\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := new(Machine)
+	if err := m.LoadProgram(p); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.HookOpcode(OpStore, func(m *Machine, _ Word) {
+		if m.AC == 3 {
+			cancel()
+		}
+	})
+	err = m.RunContext(ctx)
+	if !errors.Is(err, context.Canceled) || m.PC != 3 || m.Steps != 15 || m.peek(7) != 3 {
+		t.Fatalf("RunContext = %v at PC %03x after %d steps, M[N]=%d; want context.Canceled after the Store of 3, at 003", err, m.PC, m.Steps, m.peek(7))
+	}
+	// The run continues from the next instruction.
+	m.UnhookOpcode(OpStore)
+	if err := m.Run(); err != nil || !m.Halted || m.peek(7) != 10 {
+		t.Errorf("continued Run = %v, halted %v, M[N]=%d; want a halt with 10", err, m.Halted, m.peek(7))
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	loop := Program{Words: []Word{0x9000}} // Jump 000
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// A virtual clock passes the deadline after a number of instructions.
+	c := &VirtualClock{CycleTime: time.Millisecond, t: start}
+	m := &Machine{Clock: c, Deadline: start.Add(10 * time.Millisecond)}
+	if err := m.LoadProgram(loop); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); !errors.Is(err, ErrDeadline) || m.Steps != 11 {
+		t.Errorf("Run = %v after %d steps, want ErrDeadline after 11", err, m.Steps)
+	}
+
+	// A manual clock already past the deadline stops the run before its first instruction.
+	mc := &ManualClock{t: start}
+	m = &Machine{Clock: mc, Deadline: start.Add(time.Second)}
+	if err := m.LoadProgram(loop); err != nil {
+		t.Fatal(err)
+	}
+	mc.Advance(2 * time.Second)
+	if err := m.Run(); !errors.Is(err, ErrDeadline) || m.Steps != 0 {
+		t.Errorf("Run = %v after %d steps, want ErrDeadline before any", err, m.Steps)
+	}
+}
+
+func TestParseInputScript(t *testing.T) {
+	s, err := ParseInputScript(strings.NewReader("1F\n  -2 / negative\n\nDEC 31\nHEX 1F\n'A'\n'/' / a slash\n/ comment\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Word{0x1F, 0xFFFE, 31, 0x1F, 'A', '/'}; !reflect.DeepEqual(s.Values, want) {
+		t.Errorf("Values = %04x, want %04x", s.Values, want)
+	}
+	for i := 0; i < 6; i++ {
+		s.ReadInput()
+	}
+	if _, err := s.ReadInput(); err != io.EOF {
+		t.Errorf("ReadInput after the last value = %v, want io.EOF", err)
+	}
+	for src, want := range map[string]string{
+		"1\nxyz\n": "input script: line 2: xyz: ",
+		"DEC 1F\n": "input script: line 1: DEC 1F: ",
+		"OCT 7\n":  "input script: line 1: OCT 7: bad entry",
+		"'AB'\n":   "input script: line 1: 'AB': bad character",
+		"10000\n":  "input script: line 1: 10000: ",
+		"1 2\n":    "input script: line 1: 1 2: bad entry",
+	} {
+		if _, err := ParseInputScript(strings.NewReader(src)); err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("ParseInputScript(%q) = %v, want an error starting %q", src, err, want)
+		}
+	}
+}
+
+func TestParseExpectation(t *testing.T) {
+	p, err := Assemble(strings.NewReader("Load X\nAdd X\nStore Y\nHalt\nX, DEC 25\nY, DEC 0\nW, HEX 0FFFE\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := new(Machine)
+	if err := m.LoadProgram(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for src, want := range map[string]string{
+		"M[Y]=50":      "",
+		"M[W]=0xFFFE":  "",
+		"M[W]=-2":      "",
+		"M[W]=0xFFFD":  "M[W]=0xFFFD: M[W] is -2, want 65533",
+		"AC = 0x32":    "",
+		"M[X]+M[Y]=75": "",
+		"M[Y]=49":      "M[Y]=49: M[Y] is 50, want 49",
+		"PC=0":         "PC=0: PC is 4, want 0",
+	} {
+		e, err := ParseExpectation(src, m.Program().Symbols)
+		if err != nil {
+			t.Errorf("ParseExpectation(%q): %v", src, err)
+			continue
+		}
+		if err := e.Check(m); err == nil && want != "" || err != nil && err.Error() != want {
+			t.Errorf("%s: Check = %v, want %q", src, err, want)
+		}
+	}
+	for _, src := range []string{"AC", "AC!=1", "AC<=1", "AC>=1", "AC==1", "AC==50=1", "M[Z]=1", "AC=", "=1"} {
+		if _, err := ParseExpectation(src, m.Program().Symbols); err == nil {
+			t.Errorf("ParseExpectation(%q) succeeded, want error", src)
+		}
+	}
+}
+
+func TestInputTrapHistory(t *testing.T) {
+	p, err := Assemble(strings.NewReader("Input\nStore X\nInput\nAdd X\nHalt\nX, DEC 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &VirtualClock{CycleTime: time.Millisecond}
+	m := &Machine{Source: &InputScript{[]Word{5}}, InputEOF: EOFTrap, Clock: c}
+	if err := m.LoadProgram(p); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHistory(m)
+	defer h.Close()
+	var steps []Word
+	m.AddHooks(&Hooks{OnStep: func(r StepResult) { steps = append(steps, r.Addr) }})
+	if err := m.Run(); !errors.Is(err, ErrInputTrap) {
+		t.Fatalf("Run = %v, want ErrInputTrap", err)
+	}
+	// The trapped Input is not a step: no hook runs for it, and the clock does not tick.
+	if want := []Word{0, 1}; !reflect.DeepEqual(steps, want) || h.Len() != 2 || c.Now() != (time.Time{}).Add(2*time.Millisecond) {
+		t.Errorf("OnStep saw %03x, history has %d steps, clock at %v; want 2 steps", steps, h.Len(), c.Now().Sub(time.Time{}))
+	}
+	// Stepping back undoes the Store, not the Input that never happened.
+	if !h.Back() || m.PC != 1 || m.Steps != 1 || m.AC != 5 {
+		t.Errorf("after Back: PC=%03x AC=%04x after %d steps, want the state after the first Input", m.PC, m.AC, m.Steps)
+	}
+	if !h.Back() || m.PC != 0 || m.AC != 0 || h.Back() {
+		t.Errorf("after the second Back: PC=%03x AC=%04x, want the start", m.PC, m.AC)
+	}
+}
+
+func TestInputTrapHooks(t *testing.T) {
+	p, err := Assemble(strings.NewReader("Input\nInput\nHalt\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &InputScript{[]Word{5}}
+	m := &Machine{Source: in, InputEOF: EOFTrap}
+	if err := m.LoadProgram(p); err != nil {
+		t.Fatal(err)
+	}
+	var fetches, executes, inputs int
+	m.AddHooks(&Hooks{
+		OnFetch:   func(Word, Word) { fetches++ },
+		OnExecute: func(Opcode, Word) { executes++ },
+	})
+	m.HookOpcode(OpInput, func(*Machine, Word) { inputs++ })
+	// Each retry of the trapped Input is unseen by the hooks.
+	for i := 0; i < 2; i++ {
+		if err := m.Run(); !errors.Is(err, ErrInputTrap) {
+			t.Fatalf("Run = %v, want ErrInputTrap", err)
+		}
+		if fetches != 1 || executes != 1 || inputs != 1 {
+			t.Errorf("after trap %d: %d fetches, %d executes, %d Input hooks; want 1 each", i+1, fetches, executes, inputs)
+		}
+	}
+	in.Values = []Word{7}
+	if err := m.Run(); err != nil || m.AC != 7 {
+		t.Fatalf("resumed Run = %v, AC=%04x; want 0007", err, m.AC)
+	}
+	if fetches != 3 || executes != 3 || inputs != 2 {
+		t.Errorf("after resuming: %d fetches, %d executes, %d Input hooks; want 3, 3 and 2", fetches, executes, inputs)
+	}
+}
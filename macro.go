@@ -0,0 +1,249 @@
+package mary
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sourceLine is a line of a program once its includes and macros are expanded.
+type sourceLine struct {
+	lineNo int // line of the source; for an included or expanded line, the line of the INCLUDE or macro call
+	text   string
+
+	// where is where the line came from if it is not line lineNo of the source, innermost first.
+	where []string
+
+	// file and fileLine are the file the line is written in, "" for the source given to Assemble,
+	// and its line there.
+	file     string
+	fileLine int
+
+	// top is the file lineNo is a line of, if it is not the first file of the program.
+	top string
+}
+
+// syntaxError returns an error of the given kind, such as ErrBadOperand, in the line l.
+func (l sourceLine) syntaxError(kind error, reason string) SyntaxError {
+	return SyntaxError{lineNo: l.lineNo, file: l.top, line: l.text, reason: reason, where: l.where, kind: kind}
+}
+
+// syntaxErrorAt is like syntaxError, but the error is about the token i of tokens, the tokens of
+// the line as written, or the end of the line if i is len(tokens). expected is what should be there.
+func (l sourceLine) syntaxErrorAt(kind error, reason string, tokens []Token, i int, expected string) SyntaxError {
+	e := l.syntaxError(kind, reason)
+	e.expected = expected
+	code := strings.Split(l.text, "/")[0]
+	// Tokens are found in order, so that a token that is also part of an earlier one, such as the X
+	// of X, Load X, is found in its place.
+	off := 0
+	for j := 0; j < i && j < len(tokens); j++ {
+		if k := strings.Index(l.text[off:], tokens[j].str); k >= 0 {
+			off += k + len(tokens[j].str)
+		}
+	}
+	if i >= len(tokens) {
+		e.col = len(strings.TrimRight(code, " \t")) + 1
+		return e
+	}
+	if k := strings.Index(l.text[off:], tokens[i].str); k >= 0 {
+		e.col, e.token = off+k+1, tokens[i].str
+	}
+	return e
+}
+
+// tokenSyntaxError returns the syntax error of the line l, which tokenize could not split into tokens.
+func (l sourceLine) tokenSyntaxError(err error) SyntaxError {
+	e := l.syntaxError(ErrBadToken, err.Error())
+	if t, ok := err.(*tokenError); ok {
+		k := strings.Index(l.text, t.text)
+		if t.suffix {
+			k = strings.LastIndex(l.text, t.text)
+		}
+		if k >= 0 {
+			e.col, e.token = k+1, t.text
+		}
+	}
+	return e
+}
+
+// location describes where the line is in its file. eg., "line 3" or "lib.mas:3".
+func (l sourceLine) location() string {
+	if len(l.where) > 0 {
+		return l.where[0]
+	}
+	if l.top != "" {
+		return fmt.Sprintf("%s:%d", l.top, l.lineNo)
+	}
+	return fmt.Sprintf("line %d", l.lineNo)
+}
+
+// macro is a macro defined with MACRO and ENDM.
+type macro struct {
+	name   string
+	params []string
+	body   []sourceLine
+	def    sourceLine // the MACRO line
+}
+
+// expandMacros removes the macro definitions from lines and replaces each call with the macro's body,
+// its parameters replaced by the call's arguments. A definition is written
+//
+//	Name	MACRO param1, param2
+//		...
+//		ENDM
+//
+// and may come before or after its calls. A call names the macro followed by its arguments:
+// numbers, names, expressions or strings. A label on a call names the first line of the expansion.
+func expandMacros(lines []sourceLine) ([]sourceLine, error) {
+	macros := make(map[string]*macro)
+	var rest []sourceLine
+	var def *macro
+	for _, l := range lines {
+		tokens, err := tokenize(l.text)
+		switch {
+		case err != nil:
+			// Reported by the first pass, unless the line is part of a macro, where it is reported on expansion.
+			if def != nil {
+				def.body = append(def.body, l)
+			} else {
+				rest = append(rest, l)
+			}
+		case len(tokens) >= 2 && tokens[1].str == "MACRO":
+			if def != nil {
+				return nil, l.syntaxError(ErrBadDirective, "MACRO inside macro "+def.name)
+			}
+			if hashTokens(tokens[:1]) != hashTokenTypes(TokenIdentifier) {
+				return nil, l.syntaxError(ErrBadDirective, "a macro must be named by an identifier")
+			}
+			def = &macro{name: tokens[0].str, def: l}
+			for _, t := range tokens[2:] {
+				switch {
+				case hashTokens([]Token{t}) == hashTokenTypes(TokenComma):
+				case hashTokens([]Token{t}) != hashTokenTypes(TokenIdentifier):
+					return nil, l.syntaxError(ErrBadDirective, "bad macro parameter "+t.str)
+				default:
+					def.params = append(def.params, t.str)
+				}
+			}
+			if _, ok := macros[def.name]; ok {
+				return nil, l.syntaxError(ErrBadDirective, "macro "+def.name+" is already defined")
+			}
+			macros[def.name] = def
+		case len(tokens) > 0 && tokens[0].str == "ENDM":
+			if def == nil || len(tokens) > 1 {
+				return nil, l.syntaxError(ErrBadDirective, "ENDM without MACRO")
+			}
+			def = nil
+		case def != nil:
+			def.body = append(def.body, l)
+		default:
+			rest = append(rest, l)
+		}
+	}
+	if def != nil {
+		return nil, def.def.syntaxError(ErrBadDirective, "MACRO without ENDM")
+	}
+	return expand(rest, macros, nil)
+}
+
+// expand replaces the macro calls in lines with the macros' bodies. lines are expanded from the macros in
+// active, outermost first, so that a macro that calls itself is caught.
+func expand(lines []sourceLine, macros map[string]*macro, active []string) ([]sourceLine, error) {
+	var out []sourceLine
+	for _, l := range lines {
+		tokens, err := tokenize(l.text)
+		var label string
+		if err == nil && len(tokens) >= 2 && hashTokens(tokens[:2]) == hashTokenTypes(TokenIdentifier, TokenComma) {
+			label, tokens = tokens[0].str, tokens[2:]
+		}
+		if err != nil || len(tokens) == 0 || hashTokens(tokens[:1]) != hashTokenTypes(TokenIdentifier) || macros[tokens[0].str] == nil {
+			out = append(out, l)
+			continue
+		}
+		m := macros[tokens[0].str]
+		for _, a := range active {
+			if a == m.name {
+				return nil, l.syntaxError(ErrBadDirective, "macro "+m.name+" calls itself")
+			}
+		}
+		var args []string
+		for _, t := range tokens[1:] {
+			if hashTokens([]Token{t}) != hashTokenTypes(TokenComma) {
+				args = append(args, t.str)
+			}
+		}
+		if len(args) != len(m.params) {
+			return nil, l.syntaxError(ErrBadOperand, fmt.Sprintf("macro %s has %d parameters, given %d arguments", m.name, len(m.params), len(args)))
+		}
+		body, err := m.substitute(l, args)
+		if err != nil {
+			return nil, err
+		}
+		if label != "" {
+			if err := labelFirst(body, label); err != nil {
+				return nil, l.syntaxError(ErrBadToken, err.Error())
+			}
+		}
+		body, err = expand(body, macros, append(active[:len(active):len(active)], m.name))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, body...)
+	}
+	return out, nil
+}
+
+// substitute returns the body of m with its parameters replaced by args, as called by the line call.
+// An argument that is an expression is put in parentheses, so that p*2 called with X+1 is (X+1)*2.
+func (m *macro) substitute(call sourceLine, args []string) ([]sourceLine, error) {
+	value := make(map[string]string)
+	for i, p := range m.params {
+		value[p] = args[i]
+		if TokenExpr(args[i]) {
+			value[p] = "(" + args[i] + ")"
+		}
+	}
+	var out []sourceLine
+	for _, b := range m.body {
+		where := append([]string{"macro " + m.name + " at " + b.location()}, call.where...)
+		l := sourceLine{call.lineNo, b.text, where, b.file, b.fileLine, call.top}
+		tokens, err := tokenize(b.text)
+		if err != nil {
+			return nil, l.syntaxError(ErrBadToken, err.Error())
+		}
+		var words []string
+		for _, t := range tokens {
+			switch hashTokens([]Token{t}) {
+			case hashTokenTypes(TokenIdentifier), hashTokenTypes(TokenExpr):
+				// Local and qualified labels are never parameters.
+				words = append(words, atomRE.ReplaceAllStringFunc(t.str, func(name string) string {
+					if v, ok := value[name]; ok {
+						return v
+					}
+					return name
+				}))
+			default:
+				words = append(words, t.str)
+			}
+		}
+		l.text = strings.Join(words, " ")
+		out = append(out, l)
+	}
+	return out, nil
+}
+
+// labelFirst puts label on the first line of body with code.
+func labelFirst(body []sourceLine, label string) error {
+	for i, l := range body {
+		tokens, _ := tokenize(l.text)
+		if len(tokens) == 0 {
+			continue
+		}
+		if len(tokens) >= 2 && hashTokens(tokens[:2]) == hashTokenTypes(TokenIdentifier, TokenComma) {
+			return fmt.Errorf("label %s on a macro whose first line has a label", label)
+		}
+		body[i].text = label + ", " + l.text
+		return nil
+	}
+	return fmt.Errorf("label %s on an empty macro", label)
+}
@@ -0,0 +1,102 @@
+package mary
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAssembleMacro(t *testing.T) {
+	p, err := Assemble(strings.NewReader(`
+Inc	MACRO var
+	Load var	/ load it
+	Add One
+	Store var
+	ENDM
+Start,	Inc X
+	Swap X, Y
+	Halt
+Swap	MACRO a, b
+	Load a
+	Store T
+	Load b
+	Store a
+	Load T
+	Store b
+	ENDM
+X,	DEC 1
+Y,	DEC 2
+T,	DEC 0
+One,	DEC 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Word{0x100A, 0x300D, 0x200A, 0x100A, 0x200C, 0x100B, 0x200A, 0x100C, 0x200B, 0x7000, 1, 2, 0, 1}
+	if !reflect.DeepEqual(p.Words, want) {
+		t.Errorf("Words = %04x, want %04x", p.Words, want)
+	}
+	if p.Symbols["Start"] != 0 {
+		t.Errorf("Start = %03x, want 000", p.Symbols["Start"])
+	}
+	if p.Line(2) != 7 || p.Line(3) != 8 {
+		t.Errorf("expansions are on lines %d and %d, want the calls' lines 7 and 8", p.Line(2), p.Line(3))
+	}
+}
+
+func TestAssembleMacroExprArgument(t *testing.T) {
+	p, err := Assemble(strings.NewReader(`
+Twice	MACRO p
+	Load p*2
+	ENDM
+	Twice X+1
+	Twice -X
+	Halt
+X,	DEC 0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// X is at 3: (X+1)*2 = 8, not X+1*2 = 5, and (-X)*2 = -6.
+	want := []Word{0x1008, 0x1FFA, 0x7000, 0}
+	if !reflect.DeepEqual(p.Words, want) {
+		t.Errorf("Words = %04x, want %04x", p.Words, want)
+	}
+}
+
+func TestAssembleMacroHexParameter(t *testing.T) {
+	p, err := Assemble(strings.NewReader(`
+Put	MACRO FF, B
+	Load FF
+	Add 0FF
+	Store B+0B
+	ENDM
+	Put X, Y
+	Halt
+X,	DEC 1
+Y,	DEC 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// FF and B are only replaced as whole names, not in the numbers 0FF and 0B.
+	want := []Word{0x1004, 0x30FF, 0x2010, 0x7000, 1, 2}
+	if !reflect.DeepEqual(p.Words, want) {
+		t.Errorf("Words = %04x, want %04x", p.Words, want)
+	}
+}
+
+func TestAssembleMacroErrors(t *testing.T) {
+	for _, c := range []struct{ src, want string }{
+		{"M MACRO\nLoad\nENDM\nM\n", "syntax: line 4: Load: Load needs an operand (in macro M at line 2)"},
+		{"M MACRO x\nENDM\nM\n", "syntax: line 3: M: macro M has 1 parameters, given 0 arguments"},
+		{"M MACRO\nM\nENDM\nM\n", "syntax: line 4: M: macro M calls itself (in macro M at line 2)"},
+		{"M MACRO\nHalt\n", "syntax: line 1: M MACRO: MACRO without ENDM"},
+		{"ENDM\n", "syntax: line 1: ENDM: ENDM without MACRO"},
+	} {
+		_, err := Assemble(strings.NewReader(c.src))
+		if err == nil || err.Error() != c.want {
+			t.Errorf("Assemble(%q) = %v, want %s", c.src, err, c.want)
+		}
+	}
+}
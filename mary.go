@@ -1,28 +1,6 @@
-// Mary is a simulation of the Marie machine described in chapter 4 of
+// Package mary is an assembler and simulator for the Marie machine described in chapter 4 of
 // "Computer Organization and Architecture" by Linda Null and Julia Lobur.
-package main
-
-import (
-	"fmt"
-	"os"
-)
-
-func main() {
-	if len(os.Args) != 2 {
-		fmt.Fprintln(os.Stderr, "Usage: mary file")
-		os.Exit(1)
-	}
-	f, err := os.Open(os.Args[1])
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
-	}
-	defer f.Close()
-	m := new(Machine)
-	err = m.Load(f)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
-	}
-	m.Run()
-}
+//
+// Programs are assembled with Assemble and executed by a Machine.
+// The mary command in cmd/mary is a thin command-line interface over this package.
+package mary
@@ -0,0 +1,83 @@
+// Package marytest assembles and runs Marie programs entirely in memory, so that Go tests
+// can check programs in a few lines:
+//
+//	func TestSum(t *testing.T) {
+//		r := marytest.RunSource(t, src, []mary.Word{2, 3})
+//		if len(r.Outputs) != 1 || r.Outputs[0] != 5 {
+//			t.Errorf("outputs %v, want [5]", r.Outputs)
+//		}
+//	}
+package marytest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/bbriano/mary"
+)
+
+// MaxSteps is the number of instructions a program may execute before Run gives up on it.
+const MaxSteps = 1000000
+
+// Result is the outcome of running a program.
+type Result struct {
+	Outputs []mary.Word   // values written by Output, in order
+	Dump    string        // text printed by Dump
+	Machine *mary.Machine // the machine once it stopped, for examining registers and memory
+	Program mary.Program  // the assembled program
+	Err     error         // why the machine stopped, if it did not halt
+}
+
+// Word returns the word at label in the final memory. It panics if the program has no such label.
+func (r Result) Word(label string) mary.Word {
+	addr, ok := r.Program.Symbols[label]
+	if !ok {
+		panic(fmt.Sprintf("marytest: undefined label %s", label))
+	}
+	return r.Machine.Memory().Read(addr)
+}
+
+// Run assembles src and runs it, feeding Input the values of inputs, until it halts,
+// faults, runs out of input or executes MaxSteps instructions.
+// It returns an error only if src does not assemble; a run that does not halt is reported in Result.Err.
+func Run(src string, inputs []mary.Word) (Result, error) {
+	p, err := mary.Assemble(strings.NewReader(src))
+	if err != nil {
+		return Result{}, err
+	}
+	var dump bytes.Buffer
+	r := Result{Program: p}
+	r.Machine = &mary.Machine{
+		Stdin:    strings.NewReader(""),
+		Stdout:   &dump,
+		Stderr:   io.Discard,
+		Source:   &mary.InputScript{Values: append([]mary.Word(nil), inputs...)},
+		MaxSteps: MaxSteps,
+		Sink: mary.OutputFunc(func(o mary.OutputRecord) error {
+			r.Outputs = append(r.Outputs, o.Value)
+			return nil
+		}),
+	}
+	if err := r.Machine.LoadProgram(p); err != nil {
+		return Result{}, err
+	}
+	r.Err = r.Machine.Run()
+	r.Dump = dump.String()
+	return r, nil
+}
+
+// RunSource is like Run, but fails the test if src does not assemble or the program does not halt.
+func RunSource(t testing.TB, src string, inputs []mary.Word) Result {
+	t.Helper()
+	r, err := Run(src, inputs)
+	if err != nil {
+		t.Fatalf("assemble: %v", err)
+	}
+	if r.Err != nil {
+		t.Fatalf("run: %v", r.Err)
+	}
+	return r
+}
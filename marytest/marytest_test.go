@@ -0,0 +1,58 @@
+package marytest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/bbriano/mary"
+)
+
+const sum = `
+	Input
+	Store X
+	Input
+	Add X
+	Store X
+	Output
+	Halt
+X,	DEC 0
+`
+
+func TestRunSource(t *testing.T) {
+	for _, c := range []struct {
+		in   []mary.Word
+		want mary.Word
+	}{
+		{[]mary.Word{2, 3}, 5},
+		{[]mary.Word{0xFFFF, 1}, 0},
+	} {
+		r := RunSource(t, sum, c.in)
+		if want := []mary.Word{c.want}; !reflect.DeepEqual(r.Outputs, want) {
+			t.Errorf("inputs %v: outputs %v, want %v", c.in, r.Outputs, want)
+		}
+		if got := r.Word("X"); got != c.want {
+			t.Errorf("inputs %v: X = %d, want %d", c.in, got, c.want)
+		}
+	}
+}
+
+func TestRunStops(t *testing.T) {
+	r, err := Run(sum, []mary.Word{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(r.Err, mary.ErrInputEOF) {
+		t.Errorf("Err = %v, want ErrInputEOF", r.Err)
+	}
+	r, err = Run("Loop, Jump Loop\n", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Err == nil || r.Machine.Steps != MaxSteps {
+		t.Errorf("Err = %v after %d steps, want the step limit", r.Err, r.Machine.Steps)
+	}
+	if _, err := Run("Load\n", nil); err == nil {
+		t.Error("Run of a bad program succeeded, want error")
+	}
+}
@@ -0,0 +1,87 @@
+package mary
+
+// Memory is the machine's 12-bit addressed main memory.
+// Addresses passed to Read and Write are always in the range [0, 4096).
+type Memory interface {
+	Read(addr Word) Word
+	Write(addr Word, w Word)
+
+	// Fork returns an independent copy of the memory.
+	Fork() Memory
+}
+
+// DenseMemory is a Memory backed by an array of every word.
+// It is the default used by a Machine with a nil M.
+type DenseMemory [machineMemory]Word
+
+func (d *DenseMemory) Read(addr Word) Word {
+	return d[addr]
+}
+
+func (d *DenseMemory) Write(addr Word, w Word) {
+	d[addr] = w
+}
+
+// Fork copies every word of d.
+func (d *DenseMemory) Fork() Memory {
+	f := *d
+	return &f
+}
+
+// pageSize is the number of words in a SparseMemory page.
+const pageSize = 1 << 8 // 256
+
+type page [pageSize]Word
+
+// SparseMemory is a Memory that only allocates the pages that have been written to.
+// Reading an unallocated page yields zero words.
+// It suits configurations that keep many machines alive at once, such as servers with many sessions.
+//
+// Forking a SparseMemory is cheap: pages are shared with the fork and copied on write.
+type SparseMemory struct {
+	pages map[Word]*page
+
+	// owned is the set of pages that are not shared with a fork and may be written in place.
+	owned map[Word]bool
+}
+
+// NewSparseMemory returns an empty SparseMemory.
+func NewSparseMemory() *SparseMemory {
+	return &SparseMemory{pages: make(map[Word]*page), owned: make(map[Word]bool)}
+}
+
+func (s *SparseMemory) Read(addr Word) Word {
+	p, ok := s.pages[addr/pageSize]
+	if !ok {
+		return 0
+	}
+	return p[addr%pageSize]
+}
+
+func (s *SparseMemory) Write(addr Word, w Word) {
+	n := addr / pageSize
+	p, ok := s.pages[n]
+	switch {
+	case !ok && w == 0:
+		return
+	case !ok:
+		p = new(page)
+	case !s.owned[n]:
+		c := *p
+		p = &c
+	}
+	s.pages[n] = p
+	s.owned[n] = true
+	p[addr%pageSize] = w
+}
+
+// Fork shares every page of s with the returned memory.
+// Both s and the fork copy a shared page before writing to it.
+func (s *SparseMemory) Fork() Memory {
+	f := NewSparseMemory()
+	for n, p := range s.pages {
+		f.pages[n] = p
+	}
+	s.owned = make(map[Word]bool)
+	return f
+}
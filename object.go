@@ -0,0 +1,97 @@
+package mary
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// objectMagic begins every object file. Its last byte is the version of the format.
+var objectMagic = [4]byte{'M', 'R', 'O', 1}
+
+// WriteObject writes the assembled program p to w as an object file, which ReadObject reads back
+// without assembling it again. Symbols are written too, unless p has none; clear them for a
+// smaller file that the debugger cannot name addresses in.
+//
+// The format is the 4 bytes "MRO\x01", followed by the big-endian 16-bit origin and number of
+// words, the words, the 16-bit number of symbols, and for each symbol a byte giving the length
+// of its name, the name and its 16-bit address.
+func WriteObject(w io.Writer, p Program) error {
+	if int(p.Origin)+len(p.Words) > machineMemory {
+		return fmt.Errorf("write object: %d words do not fit at %03x", len(p.Words), p.Origin)
+	}
+	labels := make([]string, 0, len(p.Symbols))
+	for label := range p.Symbols {
+		if len(label) > 0xFF {
+			return fmt.Errorf("write object: label %.16s... is too long", label)
+		}
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	bw := bufio.NewWriter(w)
+	bw.Write(objectMagic[:])
+	binary.Write(bw, binary.BigEndian, []Word{p.Origin, Word(len(p.Words))})
+	binary.Write(bw, binary.BigEndian, p.Words)
+	binary.Write(bw, binary.BigEndian, Word(len(labels)))
+	for _, label := range labels {
+		bw.WriteByte(byte(len(label)))
+		bw.WriteString(label)
+		binary.Write(bw, binary.BigEndian, p.Symbols[label])
+	}
+	return bw.Flush()
+}
+
+// ReadObject reads a program written by WriteObject. The program has its Origin, Words and
+// Symbols; the rest, such as Lines, is known only to the assembler.
+func ReadObject(r io.Reader) (Program, error) {
+	br := bufio.NewReader(r)
+	var magic [4]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil {
+		return Program{}, fmt.Errorf("read object: %v", err)
+	}
+	if magic != objectMagic {
+		return Program{}, errors.New("read object: not a mary object file, or an unsupported version")
+	}
+	var header [2]Word
+	if err := binary.Read(br, binary.BigEndian, &header); err != nil {
+		return Program{}, fmt.Errorf("read object: %v", err)
+	}
+	p := Program{Origin: header[0], Words: make([]Word, header[1]), Symbols: make(map[string]Word)}
+	if int(p.Origin)+len(p.Words) > machineMemory {
+		return Program{}, fmt.Errorf("read object: %d words do not fit at %03x", len(p.Words), p.Origin)
+	}
+	if err := binary.Read(br, binary.BigEndian, p.Words); err != nil {
+		return Program{}, fmt.Errorf("read object: %v", err)
+	}
+	var n Word
+	if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+		return Program{}, fmt.Errorf("read object: %v", err)
+	}
+	for i := 0; i < int(n); i++ {
+		size, err := br.ReadByte()
+		name := make([]byte, size)
+		if err == nil {
+			_, err = io.ReadFull(br, name)
+		}
+		var addr Word
+		if err == nil {
+			err = binary.Read(br, binary.BigEndian, &addr)
+		}
+		if err != nil {
+			return Program{}, fmt.Errorf("read object: symbol %d: %v", i+1, err)
+		}
+		p.Symbols[string(name)] = addr
+	}
+	return p, nil
+}
+
+// isObject reports whether the file r starts like an object file.
+func isObject(r io.ReaderAt) bool {
+	var magic [4]byte
+	_, err := r.ReadAt(magic[:], 0)
+	return err == nil && magic == objectMagic
+}
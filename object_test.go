@@ -0,0 +1,59 @@
+package mary
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestObject(t *testing.T) {
+	p, err := Assemble(strings.NewReader("ORG 100\nStart, Load X\nOutput\nHalt\nX, DEC 7\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := WriteObject(&b, p); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b.Bytes(), []byte("MRO\x01\x01\x00\x00\x04")) {
+		t.Errorf("object starts % x, want the magic, origin and number of words", b.Bytes()[:8])
+	}
+	q, err := ReadObject(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Origin != p.Origin || !reflect.DeepEqual(q.Words, p.Words) || !reflect.DeepEqual(q.Symbols, p.Symbols) {
+		t.Errorf("ReadObject = %03x %04x %v, want %03x %04x %v", q.Origin, q.Words, q.Symbols, p.Origin, p.Words, p.Symbols)
+	}
+
+	for _, data := range [][]byte{
+		[]byte("MRY\x01"),
+		b.Bytes()[:10],
+		b.Bytes()[:b.Len()-1],
+	} {
+		if _, err := ReadObject(bytes.NewReader(data)); err == nil {
+			t.Errorf("ReadObject(% x) succeeded, want error", data)
+		}
+	}
+
+	// Load runs an object file as it does its source.
+	path := filepath.Join(t.TempDir(), "prog.mo")
+	if err := os.WriteFile(path, b.Bytes(), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m := new(Machine)
+	if err := m.Load(f); err != nil {
+		t.Fatal(err)
+	}
+	if m.PC != 0x100 || m.Program().Symbols["X"] != 0x103 {
+		t.Errorf("Load of an object: PC = %03x, Symbols = %v", m.PC, m.Program().Symbols)
+	}
+}
@@ -0,0 +1,84 @@
+package mary
+
+import (
+	"fmt"
+	"io"
+)
+
+// OutputRecord is a value produced by an Output instruction.
+type OutputRecord struct {
+	Value Word
+	Step  int  // the machine's Steps when the value was output, counting the Output instruction
+	Addr  Word // address of the Output instruction
+	Line  int  // source line of the Output instruction in the loaded program, 0 if unknown
+}
+
+// OutputSink receives the records produced by Output instructions.
+type OutputSink interface {
+	WriteOutput(OutputRecord) error
+}
+
+// OutputFunc adapts a function to an OutputSink.
+type OutputFunc func(OutputRecord) error
+
+func (f OutputFunc) WriteOutput(r OutputRecord) error {
+	return f(r)
+}
+
+// OutputMode is how a WriterSink prints values, like the output modes of MarieSim.
+type OutputMode int
+
+const (
+	OutputHex             OutputMode = iota // unsigned hex with 4 digits: 002a, fffe
+	OutputDecimal                           // signed decimal: 42, -2
+	OutputUnsignedDecimal                   // unsigned decimal: 42, 65534
+	OutputASCII                             // the Latin-1 character in the low byte, without a newline
+)
+
+var outputModeNames = []string{
+	OutputHex:             "hex",
+	OutputDecimal:         "dec",
+	OutputUnsignedDecimal: "udec",
+	OutputASCII:           "ascii",
+}
+
+// ParseOutputMode returns the mode with the given name: "hex", "dec", "udec" or "ascii".
+func ParseOutputMode(name string) (OutputMode, error) {
+	for mode, s := range outputModeNames {
+		if s == name {
+			return OutputMode(mode), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown output mode %q", name)
+}
+
+func (mode OutputMode) String() string {
+	if mode < 0 || int(mode) >= len(outputModeNames) {
+		return fmt.Sprintf("OutputMode(%d)", int(mode))
+	}
+	return outputModeNames[mode]
+}
+
+// WriterSink is an OutputSink that prints each value to W as set by Mode, by default a line
+// holding the word in hex with 4 digits. eg., "002a" or "fffe" for -2. Input reads
+// that format back. It is the sink of a Machine with a nil Sink, writing to the machine's Stdout
+// in the machine's OutputMode.
+type WriterSink struct {
+	W    io.Writer
+	Mode OutputMode
+}
+
+func (s WriterSink) WriteOutput(r OutputRecord) error {
+	var err error
+	switch s.Mode {
+	case OutputDecimal:
+		_, err = fmt.Fprintf(s.W, "%d\n", r.Value.Signed())
+	case OutputUnsignedDecimal:
+		_, err = fmt.Fprintf(s.W, "%d\n", r.Value)
+	case OutputASCII:
+		_, err = io.WriteString(s.W, string(rune(r.Value&0xFF)))
+	default:
+		_, err = fmt.Fprintf(s.W, "%04x\n", r.Value)
+	}
+	return err
+}
@@ -0,0 +1,51 @@
+package mary
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOutputRecordLine(t *testing.T) {
+	p, err := Assemble(strings.NewReader("ORG 100\n/ comment\nLoad X\n\nOutput\nHalt\nX, DEC -2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []OutputRecord
+	m := &Machine{Sink: OutputFunc(func(r OutputRecord) error {
+		got = append(got, r)
+		return nil
+	})}
+	if err := m.LoadProgram(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := []OutputRecord{{Value: 0xFFFE, Step: 2, Addr: 0x101, Line: 5}}
+	if len(got) != 1 || got[0] != want[0] {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestWriterSinkModes(t *testing.T) {
+	values := []Word{0x2A, 0xFFFE, 'h', 'i'}
+	for mode, want := range map[OutputMode]string{
+		OutputHex:             "002a\nfffe\n0068\n0069\n",
+		OutputDecimal:         "42\n-2\n104\n105\n",
+		OutputUnsignedDecimal: "42\n65534\n104\n105\n",
+		OutputASCII:           "*\u00fehi",
+	} {
+		var b strings.Builder
+		for _, v := range values {
+			if err := (WriterSink{&b, mode}).WriteOutput(OutputRecord{Value: v}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if b.String() != want {
+			t.Errorf("%s: got %q, want %q", mode, b.String(), want)
+		}
+		if m, err := ParseOutputMode(mode.String()); err != nil || m != mode {
+			t.Errorf("ParseOutputMode(%q) = %v, %v", mode, m, err)
+		}
+	}
+}
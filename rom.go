@@ -0,0 +1,105 @@
+package mary
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WriteMemh writes the words of p to w for Verilog's $readmemh, to initialize the memory of a
+// Marie machine built in hardware: an @ line giving the word address of the origin, then a word
+// per line in hex.
+//
+//	@100
+//	1104
+//	7000
+func WriteMemh(w io.Writer, p Program) error {
+	if int(p.Origin)+len(p.Words) > machineMemory {
+		return fmt.Errorf("write memh: %d words do not fit at %03x", len(p.Words), p.Origin)
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "@%03X\n", p.Origin)
+	for _, word := range p.Words {
+		fmt.Fprintf(bw, "%04X\n", word)
+	}
+	return bw.Flush()
+}
+
+// MemoryBlock is a run of words of a memory image, starting at Addr.
+type MemoryBlock struct {
+	Addr  Word
+	Words []Word
+}
+
+// ReadMemh reads a memory image in the format of Verilog's $readmemh, which WriteMemh writes:
+// hex words separated by whitespace, each placed after the last, starting at start. An @
+// followed by a hex address places the next word there. Comments run from // to the end of
+// the line. It returns a block for each run of words.
+func ReadMemh(r io.Reader, start Word) ([]MemoryBlock, error) {
+	var blocks []MemoryBlock
+	block := MemoryBlock{Addr: start}
+	sc := bufio.NewScanner(r)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		line, _, _ := strings.Cut(sc.Text(), "//")
+		for _, field := range strings.Fields(line) {
+			if strings.HasPrefix(field, "@") {
+				addr, err := strconv.ParseUint(field[1:], 16, 16)
+				if err != nil || addr >= machineMemory {
+					return nil, fmt.Errorf("read memh: line %d: bad address %q", lineNo, field)
+				}
+				if len(block.Words) > 0 {
+					blocks = append(blocks, block)
+				}
+				block = MemoryBlock{Addr: Word(addr)}
+				continue
+			}
+			w, err := strconv.ParseUint(field, 16, 16)
+			if err != nil {
+				return nil, fmt.Errorf("read memh: line %d: bad word %q", lineNo, field)
+			}
+			if int(block.Addr)+len(block.Words) >= machineMemory {
+				return nil, fmt.Errorf("read memh: line %d: %s is past the end of memory", lineNo, field)
+			}
+			block.Words = append(block.Words, Word(w))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(block.Words) > 0 {
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// WriteVHDL writes the words of p to w as a VHDL package declaring the constant ROM, an array of
+// the 4096 words of memory with the program at its origin and zeros elsewhere.
+//
+//	constant ROM : rom_t := (
+//		16#100# => x"1104",
+//		16#101# => x"7000",
+//		others => x"0000"
+//	);
+func WriteVHDL(w io.Writer, p Program) error {
+	if int(p.Origin)+len(p.Words) > machineMemory {
+		return fmt.Errorf("write VHDL: %d words do not fit at %03x", len(p.Words), p.Origin)
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `library ieee;
+use ieee.std_logic_1164.all;
+
+package mary_rom is
+	type rom_t is array (0 to %d) of std_logic_vector(15 downto 0);
+	constant ROM : rom_t := (
+`, machineMemory-1)
+	for i, word := range p.Words {
+		fmt.Fprintf(bw, "\t\t16#%03X# => x\"%04X\",\n", int(p.Origin)+i, word)
+	}
+	fmt.Fprint(bw, `		others => x"0000"
+	);
+end package;
+`)
+	return bw.Flush()
+}
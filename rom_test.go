@@ -0,0 +1,70 @@
+package mary
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteMemh(t *testing.T) {
+	var b strings.Builder
+	if err := WriteMemh(&b, Program{Origin: 0x100, Words: []Word{0x1102, 0x7000, 0xFFFF}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "@100\n1102\n7000\nFFFF\n"; b.String() != want {
+		t.Errorf("WriteMemh wrote %q, want %q", b.String(), want)
+	}
+}
+
+func TestWriteVHDL(t *testing.T) {
+	var b strings.Builder
+	if err := WriteVHDL(&b, Program{Origin: 0xA, Words: []Word{0x1102, 0x7000}}); err != nil {
+		t.Fatal(err)
+	}
+	want := `library ieee;
+use ieee.std_logic_1164.all;
+
+package mary_rom is
+	type rom_t is array (0 to 4095) of std_logic_vector(15 downto 0);
+	constant ROM : rom_t := (
+		16#00A# => x"1102",
+		16#00B# => x"7000",
+		others => x"0000"
+	);
+end package;
+`
+	if b.String() != want {
+		t.Errorf("WriteVHDL wrote\n%s\nwant\n%s", b.String(), want)
+	}
+	if err := WriteVHDL(&b, Program{Origin: 0xFFF, Words: []Word{1, 2}}); err == nil {
+		t.Errorf("WriteVHDL of words past the end of memory succeeded")
+	}
+}
+
+func TestReadMemh(t *testing.T) {
+	var b strings.Builder
+	if err := WriteMemh(&b, Program{Origin: 0x100, Words: []Word{0x1102, 0x7000}}); err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := ReadMemh(strings.NewReader(b.String()), 0)
+	if want := []MemoryBlock{{0x100, []Word{0x1102, 0x7000}}}; err != nil || !reflect.DeepEqual(blocks, want) {
+		t.Errorf("ReadMemh of WriteMemh = %v, %v; want %v", blocks, err, want)
+	}
+
+	blocks, err = ReadMemh(strings.NewReader("// a table\n1 2 ffff  // three words\n\n@200\n0a @300 @ffe 1 2\n"), 0x80)
+	want := []MemoryBlock{{0x80, []Word{1, 2, 0xFFFF}}, {0x200, []Word{0xA}}, {0xFFE, []Word{1, 2}}}
+	if err != nil || !reflect.DeepEqual(blocks, want) {
+		t.Errorf("ReadMemh = %v, %v; want %v", blocks, err, want)
+	}
+
+	for src, want := range map[string]string{
+		"1\n@1000\n":  `read memh: line 2: bad address "@1000"`,
+		"1 10000\n":   `read memh: line 1: bad word "10000"`,
+		"@fff 1 2\n":  `read memh: line 1: 2 is past the end of memory`,
+		"1 // ok\nzz": `read memh: line 2: bad word "zz"`,
+	} {
+		if _, err := ReadMemh(strings.NewReader(src), 0); err == nil || err.Error() != want {
+			t.Errorf("ReadMemh(%q) = %v, want %s", src, err, want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package mary
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// IOEvent is a value consumed by an Input instruction or produced by an Output instruction.
+type IOEvent struct {
+	Output bool // whether the value was output rather than input
+	Value  Word
+}
+
+func (e IOEvent) String() string {
+	if e.Output {
+		return fmt.Sprintf("out %04x", e.Value)
+	}
+	return fmt.Sprintf("in %04x", e.Value)
+}
+
+// SessionRecorder records the I/O of a machine as it runs.
+type SessionRecorder struct {
+	Events []IOEvent
+
+	m     *Machine
+	hooks *Hooks
+}
+
+// RecordSession starts recording every value m inputs and outputs.
+func RecordSession(m *Machine) *SessionRecorder {
+	r := &SessionRecorder{m: m}
+	r.hooks = &Hooks{
+		OnStep: func(s StepResult) {
+			if s.Err != nil {
+				return
+			}
+			switch s.Opcode {
+			case OpInput:
+				r.Events = append(r.Events, IOEvent{false, m.IN})
+			case OpOutput:
+				r.Events = append(r.Events, IOEvent{true, m.OUT})
+			}
+		},
+	}
+	m.AddHooks(r.hooks)
+	return r
+}
+
+// Close stops recording.
+func (r *SessionRecorder) Close() {
+	r.m.RemoveHooks(r.hooks)
+}
+
+// WriteSession writes events to w as a session file, one event per line: "in 001f" or "out 002a".
+func WriteSession(w io.Writer, events []IOEvent) error {
+	bw := bufio.NewWriter(w)
+	for _, e := range events {
+		fmt.Fprintln(bw, e)
+	}
+	return bw.Flush()
+}
+
+// ReadSession reads a session file written by WriteSession.
+func ReadSession(r io.Reader) ([]IOEvent, error) {
+	var events []IOEvent
+	sc := bufio.NewScanner(r)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		dir, value, _ := strings.Cut(line, " ")
+		w, err := parseWord(strings.TrimSpace(value), 16)
+		if err != nil || dir != "in" && dir != "out" {
+			return nil, fmt.Errorf("session: line %d: %s", lineNo, line)
+		}
+		events = append(events, IOEvent{dir == "out", w})
+	}
+	return events, sc.Err()
+}
+
+// SessionDivergence is an output at which a replayed machine did not reproduce a recorded session.
+type SessionDivergence struct {
+	N    int   // number of the output, from 1
+	Want *Word // nil if the session has no more outputs
+	Got  *Word // nil if the machine stopped before producing the output
+	Err  error // why the machine stopped, if it did
+
+	Format NumberFormat // how Error prints values
+}
+
+func (d *SessionDivergence) Error() string {
+	f := d.Format
+	switch {
+	case d.Want == nil:
+		return fmt.Sprintf("output %d: unexpected %s", d.N, f.Word(*d.Got))
+	case d.Got == nil && d.Err != nil:
+		return fmt.Sprintf("output %d: machine stopped before %s: %v", d.N, f.Word(*d.Want), d.Err)
+	case d.Got == nil:
+		return fmt.Sprintf("output %d: machine halted before %s", d.N, f.Word(*d.Want))
+	}
+	return fmt.Sprintf("output %d: %s, want %s", d.N, f.Word(*d.Got), f.Word(*d.Want))
+}
+
+// ReplaySession runs m with the inputs of a recorded session and returns a *SessionDivergence
+// for the first output that differs from the session's. Only I/O is compared, so a run that
+// produces every recorded output and then faults, like the recorded one perhaps did, matches.
+// m should be freshly loaded with the program that produced the session.
+// ReplaySession replaces its Source and Sink.
+func ReplaySession(m *Machine, events []IOEvent) error {
+	var in, want, got []Word
+	for _, e := range events {
+		if e.Output {
+			want = append(want, e.Value)
+		} else {
+			in = append(in, e.Value)
+		}
+	}
+	m.Source = &InputScript{in}
+	m.Sink = OutputFunc(func(r OutputRecord) error {
+		got = append(got, r.Value)
+		return nil
+	})
+	err := m.Run()
+	for i := 0; i < len(want) || i < len(got); i++ {
+		d := &SessionDivergence{N: i + 1, Format: m.Format}
+		if i < len(want) {
+			d.Want = &want[i]
+		}
+		if i < len(got) {
+			d.Got = &got[i]
+		} else {
+			d.Err = err
+		}
+		if d.Want == nil || d.Got == nil || *d.Want != *d.Got {
+			return d
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package mary
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sessionProgram = "Input\nOutput\nAdd X\nOutput\nHalt\nX, DEC 2\n"
+
+func TestSessionRoundTrip(t *testing.T) {
+	m := loadTraced(t, sessionProgram)
+	m.Source = &InputScript{[]Word{0xFFFE}}
+	r := RecordSession(m)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	want := []IOEvent{{false, 0xFFFE}, {true, 0xFFFE}, {true, 0}}
+	if !reflect.DeepEqual(r.Events, want) {
+		t.Fatalf("Events = %v, want %v", r.Events, want)
+	}
+
+	var b strings.Builder
+	if err := WriteSession(&b, r.Events); err != nil {
+		t.Fatal(err)
+	}
+	if want := "in fffe\nout fffe\nout 0000\n"; b.String() != want {
+		t.Errorf("session:\n%s\nwant:\n%s", b.String(), want)
+	}
+	events, err := ReadSession(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(events, r.Events) {
+		t.Errorf("ReadSession = %v, want %v", events, r.Events)
+	}
+	if err := ReplaySession(loadTraced(t, sessionProgram), events); err != nil {
+		t.Errorf("ReplaySession: %v", err)
+	}
+
+	for _, src := range []string{"in\n", "in zz\n", "inout 1\n"} {
+		if _, err := ReadSession(strings.NewReader(src)); err == nil {
+			t.Errorf("ReadSession(%q) succeeded, want error", src)
+		}
+	}
+}
+
+func TestReplaySessionDiverges(t *testing.T) {
+	for _, c := range []struct {
+		session string
+		want    string
+	}{
+		{"in 0005\nout 0005\nout 0009\n", "output 2: 0007, want 0009"},
+		{"in 0005\nout 0005\n", "output 2: unexpected 0007"},
+		{"in 0005\nout 0005\nout 0007\nout 0001\n", "output 3: machine halted before 0001"},
+	} {
+		events, err := ReadSession(strings.NewReader(c.session))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ReplaySession(loadTraced(t, sessionProgram), events)
+		var d *SessionDivergence
+		if !errors.As(err, &d) || err.Error() != c.want {
+			t.Errorf("ReplaySession(%q) = %v, want a SessionDivergence %q", c.session, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package mary
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// srecWords is the number of words in each data record written by WriteSRecord.
+const srecWords = 8
+
+// WriteSRecord writes the words of p to w as Motorola S-records, for device programmers and
+// ROM tools. Memory is addressed in bytes, each word taking two, high byte first, so the word at
+// address a is at byte 2a. The file is an S0 header, S1 data records of up to 16 bytes, an S5
+// record giving their number, and an S9 record giving the byte address of the origin to start at.
+//
+//	S00700006D6172793F
+//	S10B02001104310460007000D8
+//	S5030001FB
+//	S9030200FA
+func WriteSRecord(w io.Writer, p Program) error {
+	if int(p.Origin)+len(p.Words) > machineMemory {
+		return fmt.Errorf("write S-record: %d words do not fit at %03x", len(p.Words), p.Origin)
+	}
+	bw := bufio.NewWriter(w)
+	writeSRecord(bw, '0', 0, []byte("mary"))
+	n := 0
+	for i := 0; i < len(p.Words); i += srecWords {
+		end := i + srecWords
+		if end > len(p.Words) {
+			end = len(p.Words)
+		}
+		var data []byte
+		for _, word := range p.Words[i:end] {
+			data = append(data, byte(word>>8), byte(word))
+		}
+		writeSRecord(bw, '1', 2*(int(p.Origin)+i), data)
+		n++
+	}
+	writeSRecord(bw, '5', n, nil)
+	writeSRecord(bw, '9', 2*int(p.Origin), nil)
+	return bw.Flush()
+}
+
+// writeSRecord writes the S-record of type typ with a 16-bit address and data.
+func writeSRecord(w *bufio.Writer, typ byte, addr int, data []byte) {
+	rec := append([]byte{byte(len(data) + 3), byte(addr >> 8), byte(addr)}, data...)
+	var sum byte
+	for _, b := range rec {
+		sum += b
+	}
+	fmt.Fprintf(w, "S%c%X%02X\n", typ, rec, ^sum)
+}
@@ -0,0 +1,23 @@
+package mary
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteSRecord(t *testing.T) {
+	p := Program{Origin: 0x100, Words: []Word{0x1104, 0x3104, 0x6000, 0x7000, 1, 2, 3, 4, 0xFFFF}}
+	var b strings.Builder
+	if err := WriteSRecord(&b, p); err != nil {
+		t.Fatal(err)
+	}
+	want := `S00700006D6172793F
+S113020011043104600070000001000200030004C6
+S1050210FFFFEA
+S5030002FA
+S9030200FA
+`
+	if b.String() != want {
+		t.Errorf("WriteSRecord wrote\n%s\nwant\n%s", b.String(), want)
+	}
+}
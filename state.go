@@ -0,0 +1,66 @@
+package mary
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// stateMagic begins every saved state. Its last byte is the version of the format.
+var stateMagic = [4]byte{'M', 'R', 'Y', 1}
+
+// savedState is the layout of a saved state following the magic, in big-endian byte order.
+type savedState struct {
+	AC, PC, MAR, MBR, IR, IN, OUT Word
+	Halted                        bool   // one byte, 0 or 1
+	Steps                         uint64 // the machine's Steps
+	Mem                           [machineMemory]Word
+}
+
+// SaveState writes the machine's registers, Steps and memory to w, so that a run can be
+// suspended and resumed later with LoadState, or inspected offline.
+//
+// The format is the 4 bytes "MRY\x01", followed by the big-endian 16-bit words
+// AC, PC, MAR, MBR, IR, IN and OUT, a byte that is 1 if the machine has halted and 0 otherwise,
+// Steps as a big-endian 64-bit integer, and the 4096 words of memory, big-endian, from address 0:
+// 8219 bytes in all.
+//
+// Breakpoints, hooks, I/O streams and the loaded program are not saved.
+func (m *Machine) SaveState(w io.Writer) error {
+	s := savedState{m.AC, m.PC, m.MAR, m.MBR, m.IR, m.IN, m.OUT, m.Halted, uint64(m.Steps), [machineMemory]Word{}}
+	for addr := range s.Mem {
+		s.Mem[addr] = m.peek(Word(addr))
+	}
+	bw := bufio.NewWriter(w)
+	bw.Write(stateMagic[:])
+	if err := binary.Write(bw, binary.BigEndian, &s); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// LoadState replaces the machine's registers, Steps and memory with a state written by SaveState.
+// The machine is unchanged if r does not hold a valid state.
+func (m *Machine) LoadState(r io.Reader) error {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return fmt.Errorf("load state: %v", err)
+	}
+	if magic != stateMagic {
+		return errors.New("load state: not a mary state, or an unsupported version")
+	}
+	var s savedState
+	if err := binary.Read(r, binary.BigEndian, &s); err != nil {
+		return fmt.Errorf("load state: %v", err)
+	}
+	m.SetRegisters(Registers{s.AC, s.PC, s.MAR, s.MBR, s.IR, s.IN, s.OUT, s.Halted})
+	m.Steps = int(s.Steps)
+	for addr, w := range s.Mem {
+		if m.peek(Word(addr)) != w {
+			m.poke(Word(addr), w)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package mary
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSaveLoadState(t *testing.T) {
+	p, err := Assemble(strings.NewReader(`
+Loop,	Load N
+	Output
+	Subt One
+	Store N
+	Skipcond 400
+	Jump Loop
+	Halt
+N,	DEC 5
+One,	DEC 1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newMachine := func() (*Machine, *strings.Builder) {
+		var out strings.Builder
+		m := &Machine{Stdout: &out}
+		if err := m.LoadProgram(p); err != nil {
+			t.Fatal(err)
+		}
+		return m, &out
+	}
+
+	m, out := newMachine()
+	for i := 0; i < 9; i++ {
+		if _, err := m.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var state bytes.Buffer
+	if err := m.SaveState(&state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Len() != 8219 {
+		t.Errorf("state is %d bytes, want 8219", state.Len())
+	}
+	out.Reset()
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh machine given the state ends as the original does.
+	f, fout := new(Machine), new(strings.Builder)
+	f.Stdout = fout
+	if err := f.LoadState(&state); err != nil {
+		t.Fatal(err)
+	}
+	if f.PC != 3 || f.Steps != 9 || f.peek(7) != 4 || f.peek(0) != 0x1007 {
+		t.Errorf("loaded PC=%03x after %d steps, M[007]=%04x M[000]=%04x; want the state after 9 steps", f.PC, f.Steps, f.peek(7), f.peek(0))
+	}
+	if err := f.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if f.Registers() != m.Registers() || f.Steps != m.Steps || f.peek(7) != m.peek(7) {
+		t.Errorf("resumed to %s after %d steps, want %s after %d", f.Format.Registers(f.Registers()), f.Steps, m.Format.Registers(m.Registers()), m.Steps)
+	}
+	if want := "0003\n0002\n0001\n"; fout.String() != want || out.String() != want {
+		t.Errorf("resumed output %q, original %q; want %q for both", fout, out, want)
+	}
+
+	// A state that is not one leaves the machine as it was.
+	f, _ = newMachine()
+	for _, bad := range []string{"", "MRY\x02", "MRY\x01\x00\x01"} {
+		if err := f.LoadState(strings.NewReader(bad)); err == nil {
+			t.Errorf("LoadState(%q) succeeded, want error", bad)
+		}
+	}
+	if f.PC != 0 || f.Steps != 0 || f.peek(7) != 5 {
+		t.Errorf("failed LoadState changed the machine: PC=%03x Steps=%d M[007]=%04x", f.PC, f.Steps, f.peek(7))
+	}
+}
@@ -0,0 +1,93 @@
+package mary
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Symbol is a label or EQU constant of a program, as listed by SymbolTable.
+type Symbol struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind"`           // "label" or "const"
+	Value Word   `json:"value"`          // the address of a label, or the value of a constant
+	Line  int    `json:"line,omitempty"` // the source line defining it, as in Lines
+}
+
+// SymbolTable returns the labels and constants of p, in order of value and then of name.
+func (p Program) SymbolTable() []Symbol {
+	var out []Symbol
+	for name, addr := range p.Symbols {
+		out = append(out, Symbol{name, "label", addr, p.Line(addr)})
+	}
+	for name, v := range p.Consts {
+		out = append(out, Symbol{name, "const", v, p.Xrefs[name].Defined})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Value != out[j].Value {
+			return out[i].Value < out[j].Value
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// WriteSymbols writes the symbol table of p to w, one symbol per line: its value in hex, its kind
+// and its name.
+//
+//	100  label  Start
+//	104  label  X
+//	00A  const  Max
+func WriteSymbols(w io.Writer, p Program) error {
+	for _, s := range p.SymbolTable() {
+		if _, err := fmt.Fprintf(w, "%-4s %-5s  %s\n", symbolValue(s), s.Kind, s.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// symbolValue formats the value of s in hex, as wide as an address unless it does not fit.
+func symbolValue(s Symbol) string {
+	if s.Value >= machineMemory {
+		return fmt.Sprintf("%04X", s.Value)
+	}
+	return fmt.Sprintf("%03X", s.Value)
+}
+
+// WriteSymbolsJSON writes the symbol table of p to w as a JSON array of Symbol.
+func WriteSymbolsJSON(w io.Writer, p Program) error {
+	table := p.SymbolTable()
+	if table == nil {
+		table = []Symbol{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(table)
+}
+
+// WriteCrossReference writes the cross reference of p to w: for each label and constant, in order
+// of name, its kind, value, the line defining it and the lines using it, or "unused".
+//
+//	NAME          KIND   VALUE  DEFINED  USED
+//	Count         label  10C         12  3 7 9
+//	Spare         label  10D         13  unused
+func WriteCrossReference(w io.Writer, p Program) error {
+	table := p.SymbolTable()
+	sort.SliceStable(table, func(i, j int) bool { return table[i].Name < table[j].Name })
+	if _, err := fmt.Fprintln(w, "NAME          KIND   VALUE  DEFINED  USED"); err != nil {
+		return err
+	}
+	for _, s := range table {
+		used := "unused"
+		if lines := p.Xrefs[s.Name].Used; len(lines) > 0 {
+			used = strings.Trim(fmt.Sprint(lines), "[]")
+		}
+		if _, err := fmt.Fprintf(w, "%-13s %-5s  %-5s  %7d  %s\n", s.Name, s.Kind, symbolValue(s), s.Line, used); err != nil {
+			return err
+		}
+	}
+	return nil
+}
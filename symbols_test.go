@@ -0,0 +1,68 @@
+package mary
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSymbolTable(t *testing.T) {
+	p, err := Assemble(strings.NewReader("Max EQU 0A\nORG 100\nStart, Load X\nHalt\nX, DEC 5\nBig EQU 0FFFF\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Symbol{
+		{"Max", "const", 0x00A, 1},
+		{"Start", "label", 0x100, 3},
+		{"X", "label", 0x102, 5},
+		{"Big", "const", 0xFFFF, 6},
+	}
+	if got := p.SymbolTable(); !reflect.DeepEqual(got, want) {
+		t.Errorf("SymbolTable() = %v, want %v", got, want)
+	}
+
+	var b strings.Builder
+	if err := WriteSymbols(&b, p); err != nil {
+		t.Fatal(err)
+	}
+	text := "00A  const  Max\n100  label  Start\n102  label  X\nFFFF const  Big\n"
+	if b.String() != text {
+		t.Errorf("WriteSymbols wrote %q, want %q", b.String(), text)
+	}
+
+	b.Reset()
+	if err := WriteSymbolsJSON(&b, Program{}); err != nil || b.String() != "[]\n" {
+		t.Errorf("WriteSymbolsJSON of no symbols wrote %q, %v; want []", b.String(), err)
+	}
+}
+
+func TestCrossReference(t *testing.T) {
+	src := "Two EQU 2\nFour EQU Two*2\nLoad X\nAdd X+Two\nStore X\nHalt\nX, DEC 1\nSpare, DEC 0\n"
+	p, err := Assemble(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Xref{
+		"Two":   {1, []int{2, 4}},
+		"Four":  {2, nil},
+		"X":     {7, []int{3, 4, 5}},
+		"Spare": {8, nil},
+	}
+	if !reflect.DeepEqual(p.Xrefs, want) {
+		t.Errorf("Xrefs = %v, want %v", p.Xrefs, want)
+	}
+
+	var b strings.Builder
+	if err := WriteCrossReference(&b, p); err != nil {
+		t.Fatal(err)
+	}
+	text := `NAME          KIND   VALUE  DEFINED  USED
+Four          const  004          2  unused
+Spare         label  005          8  unused
+Two           const  002          1  2 4
+X             label  004          7  3 4 5
+`
+	if b.String() != text {
+		t.Errorf("WriteCrossReference wrote\n%s\nwant\n%s", b.String(), text)
+	}
+}
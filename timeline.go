@@ -0,0 +1,88 @@
+package mary
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// TimelineEvent is a milestone of a run, such as an Output or a fault, for tools like course
+// dashboards that follow what programs do without parsing their logs.
+type TimelineEvent struct {
+	Time  time.Time `json:"time"`
+	Kind  string    `json:"kind"` // one of the Timeline constants
+	Step  int       `json:"step"`
+	Addr  Word      `json:"addr"`            // PC, or the address of the instruction the event is about
+	Value *Word     `json:"value,omitempty"` // the value output, for TimelineOutput
+	Err   string    `json:"error,omitempty"` // the fault, for TimelineFault
+}
+
+// Kinds of TimelineEvent.
+const (
+	TimelineAssembled = "assembled" // the program was assembled and loaded
+	TimelineStarted   = "started"   // the first instruction was fetched
+	TimelineOutput    = "output"
+	TimelineFault     = "fault"
+	TimelineHalt      = "halt"
+)
+
+// TimelineRecorder records the timeline of a machine as it runs.
+type TimelineRecorder struct {
+	Events []TimelineEvent
+
+	m     *Machine
+	hooks *Hooks
+}
+
+// RecordTimeline starts recording the timeline of m, whose program should have just been loaded.
+// Its first event is TimelineAssembled. Events are timed by m's clock.
+func RecordTimeline(m *Machine) *TimelineRecorder {
+	r := &TimelineRecorder{m: m}
+	r.add(TimelineAssembled, m.PC)
+	started := false
+	r.hooks = &Hooks{
+		OnFetch: func(pc, w Word) {
+			if !started {
+				started = true
+				r.add(TimelineStarted, pc)
+			}
+		},
+		OnStep: func(s StepResult) {
+			switch {
+			case s.Err != nil:
+				r.add(TimelineFault, s.Addr)
+				r.Events[len(r.Events)-1].Err = s.Err.Error()
+			case s.Halted:
+				r.add(TimelineHalt, s.Addr)
+			case s.Opcode == OpOutput:
+				r.add(TimelineOutput, s.Addr)
+				v := m.OUT
+				r.Events[len(r.Events)-1].Value = &v
+			}
+		},
+	}
+	m.AddHooks(r.hooks)
+	return r
+}
+
+func (r *TimelineRecorder) add(kind string, addr Word) {
+	r.Events = append(r.Events, TimelineEvent{Time: r.m.clock().Now(), Kind: kind, Step: r.m.Steps, Addr: addr})
+}
+
+// Close stops recording.
+func (r *TimelineRecorder) Close() {
+	r.m.RemoveHooks(r.hooks)
+}
+
+// WriteTimeline writes events to w as JSON Lines, one event per line.
+func WriteTimeline(w io.Writer, events []TimelineEvent) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
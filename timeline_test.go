@@ -0,0 +1,37 @@
+package mary
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRecordTimeline(t *testing.T) {
+	p, err := Assemble(strings.NewReader("Load X\nOutput\nInput\nHalt\nX, DEC 7\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Machine{Source: &InputScript{}, Sink: OutputFunc(func(OutputRecord) error { return nil })}
+	if err := m.LoadProgram(p); err != nil {
+		t.Fatal(err)
+	}
+	r := RecordTimeline(m)
+	if err := m.Run(); !errors.Is(err, ErrInputEOF) {
+		t.Fatalf("Run = %v, want ErrInputEOF", err)
+	}
+	r.Close()
+	var kinds []string
+	for _, e := range r.Events {
+		kinds = append(kinds, e.Kind)
+	}
+	want := []string{TimelineAssembled, TimelineStarted, TimelineOutput, TimelineFault}
+	if strings.Join(kinds, " ") != strings.Join(want, " ") {
+		t.Fatalf("events %v, want %v", kinds, want)
+	}
+	if out := r.Events[2]; out.Value == nil || *out.Value != 7 || out.Addr != 1 || out.Step != 2 {
+		t.Errorf("output event %+v, want value 7 at 001, step 2", out)
+	}
+	if fault := r.Events[3]; fault.Addr != 2 || fault.Err == "" {
+		t.Errorf("fault event %+v, want an error at 002", fault)
+	}
+}
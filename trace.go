@@ -0,0 +1,276 @@
+package mary
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// TraceRecord describes one executed instruction. JSONTracer writes them as JSON Lines.
+type TraceRecord struct {
+	Step     int         `json:"step"` // the machine's Steps after the instruction
+	PC       Word        `json:"pc"`   // address the instruction was fetched from
+	IR       Word        `json:"ir"`
+	Op       string      `json:"op"`
+	Operand  Word        `json:"operand"`
+	ACBefore Word        `json:"ac_before"`
+	After    Registers   `json:"after"`
+	Reads    []MemRead   `json:"reads,omitempty"`
+	Writes   []MemChange `json:"writes,omitempty"`
+
+	// Source is where the instruction was assembled from, such as "prog.mas:5", if it was.
+	// It is not compared by ReplayTrace.
+	Source string `json:"source,omitempty"`
+}
+
+// MemRead is a memory read made by an instruction.
+type MemRead struct {
+	Addr Word `json:"addr"`
+	Val  Word `json:"val"`
+}
+
+// MemChange is a memory write made by an instruction.
+type MemChange struct {
+	Addr Word `json:"addr"`
+	Old  Word `json:"old"`
+	New  Word `json:"new"`
+}
+
+// JSONTracer writes a TraceRecord for every instruction a machine executes, one JSON object per line.
+type JSONTracer struct {
+	rec *traceRecorder
+	enc *json.Encoder
+	err error
+}
+
+// NewJSONTracer starts tracing m to w.
+func NewJSONTracer(m *Machine, w io.Writer) *JSONTracer {
+	t := &JSONTracer{enc: json.NewEncoder(w)}
+	t.rec = recordTrace(m, func(r *TraceRecord) {
+		if err := t.enc.Encode(r); err != nil && t.err == nil {
+			t.err = err
+		}
+	})
+	return t
+}
+
+// Close stops tracing. It returns the first error encountered writing the trace.
+func (t *JSONTracer) Close() error {
+	t.rec.close()
+	return t.err
+}
+
+// TextTracer writes a line for every instruction a machine executes, to follow a run by eye:
+// the address and word fetched, the instruction, AC and PC after it, and its source.
+//
+//	001: 3005 Add      005  AC=0007 PC=002  prog.mas:2
+type TextTracer struct {
+	rec *traceRecorder
+	err error
+}
+
+// NewTextTracer starts tracing m to w, printing numbers in m's Format.
+func NewTextTracer(m *Machine, w io.Writer) *TextTracer {
+	t := new(TextTracer)
+	t.rec = recordTrace(m, func(r *TraceRecord) {
+		f := m.Format
+		line := fmt.Sprintf("%s: %s %-8s %s  AC=%s PC=%s", f.Addr(r.PC), f.Word(r.IR), r.Op, f.Addr(r.Operand), f.Word(r.After.AC), f.Addr(r.After.PC))
+		if r.Source != "" {
+			line += "  " + r.Source
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil && t.err == nil {
+			t.err = err
+		}
+	})
+	return t
+}
+
+// Close stops tracing. It returns the first error encountered writing the trace.
+func (t *TextTracer) Close() error {
+	t.rec.close()
+	return t.err
+}
+
+// traceRecorder builds a TraceRecord for every instruction a machine executes.
+type traceRecorder struct {
+	m     *Machine
+	hooks *Hooks
+	rec   TraceRecord
+}
+
+// recordTrace starts recording m, calling done with the record of each instruction after it executes.
+func recordTrace(m *Machine, done func(*TraceRecord)) *traceRecorder {
+	t := &traceRecorder{m: m}
+	t.hooks = &Hooks{
+		OnFetch: func(pc, w Word) {
+			t.rec = TraceRecord{PC: pc, IR: w, ACBefore: m.AC}
+			if s, ok := m.program.SourceAt(pc); ok {
+				t.rec.Source = s.String()
+			}
+		},
+		OnMemRead: func(addr, val Word) {
+			t.rec.Reads = append(t.rec.Reads, MemRead{addr, val})
+		},
+		OnMemWrite: func(addr, old, new Word) {
+			t.rec.Writes = append(t.rec.Writes, MemChange{addr, old, new})
+		},
+		OnStep: func(r StepResult) {
+			t.rec.Step = m.Steps
+			t.rec.Op = r.Opcode.String()
+			t.rec.Operand = r.Operand
+			t.rec.After = m.Registers()
+			done(&t.rec)
+		},
+	}
+	m.AddHooks(t.hooks)
+	return t
+}
+
+func (t *traceRecorder) close() {
+	t.m.RemoveHooks(t.hooks)
+}
+
+// ReadTrace reads the JSON Lines trace written by a JSONTracer.
+func ReadTrace(r io.Reader) ([]TraceRecord, error) {
+	var out []TraceRecord
+	dec := json.NewDecoder(r)
+	for {
+		var rec TraceRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("trace record %d: %v", len(out)+1, err)
+		}
+		out = append(out, rec)
+	}
+}
+
+// TraceDivergence is a step at which a machine did not reproduce a recorded trace.
+type TraceDivergence struct {
+	Want TraceRecord
+	Got  *TraceRecord // nil if the machine stopped before the step
+	Err  error        // why the machine stopped, if it did
+
+	Format NumberFormat // how Error prints numbers
+}
+
+func (d *TraceDivergence) Error() string {
+	if d.Got == nil {
+		return fmt.Sprintf("step %d: machine stopped before %s: %v", d.Want.Step, d.Format.Addr(d.Want.PC), d.Err)
+	}
+	return fmt.Sprintf("step %d: %s: %s", d.Want.Step, d.Format.Addr(d.Want.PC), d.Format.diffTrace(d.Want, *d.Got))
+}
+
+// ReplayTrace executes m step by step against the recorded trace, feeding Input the values
+// the trace shows it read, and returns a *TraceDivergence for the first step whose outcome differs.
+// m should be freshly loaded with the program that produced the trace. ReplayTrace replaces
+// its Source, and discards its Output.
+func ReplayTrace(m *Machine, trace []TraceRecord) error {
+	if ds := verifyTrace(m, trace, false); len(ds) > 0 {
+		return ds[0]
+	}
+	return nil
+}
+
+// VerifyTrace is like ReplayTrace but checks every step of the trace, returning a divergence for each
+// step whose outcome differs. After a divergent step the machine is set to the recorded outcome,
+// so that each step is checked from the state the trace shows it started in.
+func VerifyTrace(m *Machine, trace []TraceRecord) []*TraceDivergence {
+	return verifyTrace(m, trace, true)
+}
+
+func verifyTrace(m *Machine, trace []TraceRecord, all bool) []*TraceDivergence {
+	m.Sink = OutputFunc(func(OutputRecord) error { return nil })
+
+	var got TraceRecord
+	t := recordTrace(m, func(r *TraceRecord) { got = *r })
+	defer t.close()
+	var ds []*TraceDivergence
+	for _, want := range trace {
+		if m.Halted {
+			ds = append(ds, &TraceDivergence{want, nil, errors.New("halted"), m.Format})
+			if !all {
+				return ds
+			}
+			m.resync(want, TraceRecord{})
+			continue
+		}
+		// Input reads the value the trace shows it read, and nothing else.
+		in := &InputScript{}
+		if want.Op == OpInput.String() {
+			in.Values = []Word{want.After.IN}
+		}
+		m.Source = in
+		got = TraceRecord{}
+		_, err := m.Step()
+		if m.Format.diffTrace(want, got) != "" {
+			got := got
+			ds = append(ds, &TraceDivergence{want, &got, err, m.Format})
+			if !all {
+				return ds
+			}
+			m.resync(want, got)
+		}
+	}
+	return ds
+}
+
+// resync sets m to the outcome recorded by want, undoing the step it executed instead, got.
+func (m *Machine) resync(want, got TraceRecord) {
+	for i := len(got.Writes) - 1; i >= 0; i-- {
+		m.poke(got.Writes[i].Addr, got.Writes[i].Old)
+	}
+	for _, w := range want.Writes {
+		m.poke(w.Addr, w.New)
+	}
+	m.SetRegisters(want.After)
+	m.Steps = want.Step
+}
+
+// diffTrace describes how got differs from want in format f. It returns "" if they match.
+func (f NumberFormat) diffTrace(want, got TraceRecord) string {
+	var diffs []string
+	field := func(name string, want, got any) {
+		if !reflect.DeepEqual(want, got) {
+			diffs = append(diffs, fmt.Sprintf("%s %s, want %s", name, f.traceValue(got), f.traceValue(want)))
+		}
+	}
+	field("step", want.Step, got.Step)
+	field("pc", want.PC, got.PC)
+	field("ir", want.IR, got.IR)
+	field("op", want.Op, got.Op)
+	field("operand", want.Operand, got.Operand)
+	field("ac before", want.ACBefore, got.ACBefore)
+	field("registers after", want.After, got.After)
+	field("reads", want.Reads, got.Reads)
+	field("writes", want.Writes, got.Writes)
+	return strings.Join(diffs, "; ")
+}
+
+// traceValue formats a field of a TraceRecord for diffTrace.
+func (f NumberFormat) traceValue(v any) string {
+	switch v := v.(type) {
+	case Word:
+		return f.Word(v)
+	case Registers:
+		return f.Registers(v)
+	case []MemRead:
+		var s []string
+		for _, r := range v {
+			s = append(s, fmt.Sprintf("M[%s]=%s", f.Addr(r.Addr), f.Word(r.Val)))
+		}
+		return "[" + strings.Join(s, " ") + "]"
+	case []MemChange:
+		var s []string
+		for _, c := range v {
+			s = append(s, fmt.Sprintf("M[%s]=%s->%s", f.Addr(c.Addr), f.Word(c.Old), f.Word(c.New)))
+		}
+		return "[" + strings.Join(s, " ") + "]"
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,138 @@
+package mary
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTextTracer(t *testing.T) {
+	p, err := Assemble(strings.NewReader("Load X\nAdd X\nHalt\nX, DEC 2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Machine{Stdout: new(strings.Builder)}
+	if err := m.LoadProgram(p); err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	tr := NewTextTracer(m, &b)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := `000: 1003 Load     003  AC=0002 PC=001  line 1
+001: 3003 Add      003  AC=0004 PC=002  line 2
+002: 7000 Halt     000  AC=0004 PC=003  line 3
+`
+	if b.String() != want {
+		t.Errorf("trace:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+// traceRun runs src, reading input, with a JSONTracer and returns the trace it wrote, read back,
+// and the machine.
+func traceRun(t *testing.T, src string, input ...Word) ([]TraceRecord, *Machine) {
+	t.Helper()
+	m := loadTraced(t, src)
+	m.Source = &InputScript{input}
+	var b strings.Builder
+	tr := NewJSONTracer(m, &b)
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	trace, err := ReadTrace(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trace, m
+}
+
+// loadTraced returns a machine with the program src loaded, discarding its output.
+func loadTraced(t *testing.T, src string) *Machine {
+	t.Helper()
+	p, err := Assemble(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Machine{Stdout: io.Discard}
+	if err := m.LoadProgram(p); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+const traceProgram = "Input\nAdd X\nStore Y\nOutput\nHalt\nX, DEC 2\nY, DEC 0\n"
+
+func TestReplayTrace(t *testing.T) {
+	trace, ran := traceRun(t, traceProgram, 5)
+	if len(trace) != 5 {
+		t.Fatalf("trace has %d steps, want 5", len(trace))
+	}
+
+	// Replaying reproduces the run, Input reading the value the trace shows it read.
+	m := loadTraced(t, traceProgram)
+	if err := ReplayTrace(m, trace); err != nil {
+		t.Fatal(err)
+	}
+	if m.Registers() != ran.Registers() || m.Steps != ran.Steps || m.peek(6) != 7 {
+		t.Errorf("replayed to %s after %d steps, M[006]=%04x; want %s after %d, M[006]=0007",
+			m.Format.Registers(m.Registers()), m.Steps, m.peek(6), ran.Format.Registers(ran.Registers()), ran.Steps)
+	}
+
+	// A program that computes something else diverges at the first step it differs.
+	m = loadTraced(t, strings.Replace(traceProgram, "DEC 2", "DEC 3", 1))
+	err := ReplayTrace(m, trace)
+	var d *TraceDivergence
+	if !errors.As(err, &d) || d.Want.Step != 2 || d.Got == nil || d.Got.After.AC != 8 {
+		t.Fatalf("ReplayTrace = %v, want a divergence at step 2", err)
+	}
+	if want := "step 2: 001: registers after AC=0008"; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("error %q, want it to start %q", err, want)
+	}
+	if m.Steps != 2 {
+		t.Errorf("replay went on to step %d after diverging at step 2", m.Steps)
+	}
+
+	// One that halts early diverges at the step it did not take.
+	m = loadTraced(t, "Input\nHalt\n")
+	err = ReplayTrace(m, trace)
+	if !errors.As(err, &d) || d.Want.Step != 2 {
+		t.Fatalf("ReplayTrace = %v, want a divergence at step 2", err)
+	}
+}
+
+func TestVerifyTrace(t *testing.T) {
+	trace, _ := traceRun(t, traceProgram, 5)
+	m := loadTraced(t, traceProgram)
+	if ds := VerifyTrace(m, trace); len(ds) != 0 {
+		t.Fatalf("VerifyTrace = %v, want no divergences", ds)
+	}
+
+	// Corrupt steps 2 and 4 of the trace. The machine is set to the recorded outcome of step 2, so
+	// step 3 still matches.
+	trace[1].Reads[0].Val = 3
+	trace[3].IR = 0x6001
+	m = loadTraced(t, traceProgram)
+	ds := VerifyTrace(m, trace)
+	var steps []int
+	for _, d := range ds {
+		steps = append(steps, d.Want.Step)
+	}
+	if !reflect.DeepEqual(steps, []int{2, 4}) {
+		t.Fatalf("VerifyTrace diverges at steps %v, want [2 4]: %v", steps, ds)
+	}
+	if want := "step 2: 001: reads [M[005]=0002], want [M[005]=0003]"; ds[0].Error() != want {
+		t.Errorf("first divergence %q, want %q", ds[0], want)
+	}
+	if !m.Halted || m.Steps != 5 || m.peek(6) != 7 {
+		t.Errorf("machine halted %v after %d steps, M[006]=%04x; want it to end as the trace does", m.Halted, m.Steps, m.peek(6))
+	}
+}
@@ -0,0 +1,92 @@
+package mary
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Diagnostic is a likely bug that Vet found in a program.
+type Diagnostic struct {
+	Addr  Word   // address of the word at fault
+	Line  int    // its source line, as in Lines
+	Check string // the check that found it, such as "unreachable"
+	Msg   string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("line %d: %03X: %s (%s)", d.Line, d.Addr, d.Msg, d.Check)
+}
+
+// Vet checks the assembled program p for likely bugs, following execution from its origin.
+// The checks are
+//
+//	unreachable  code that execution never reaches
+//	fallthrough  data that execution runs into, as if it were code
+//	skipcond     a Skipcond whose operand is not a condition: 000, 400 or 800
+//	self-modify  a Store to an address holding code
+//	halt         a program in which no Halt is reached
+//
+// A diagnostic is suppressed by naming its check in a pragma on its line, such as
+// "/ mary:allow self-modify"; "/ mary:allow" alone suppresses them all. Execution is not
+// followed through JumpI, so code reached only that way, as from a jump table, is unreachable.
+func Vet(p Program) []Diagnostic {
+	data := p.Data
+	if len(data) != len(p.Words) {
+		data = make([]bool, len(p.Words))
+	}
+	reached, slot := flow(p.Origin, p.Words, data)
+	inProgram := func(addr Word) bool {
+		return addr >= p.Origin && int(addr) < int(p.Origin)+len(p.Words)
+	}
+
+	var out []Diagnostic
+	add := func(i int, check, format string, args ...any) {
+		addr := p.Origin + Word(i)
+		d := Diagnostic{addr, p.Line(addr), check, fmt.Sprintf(format, args...)}
+		if !allowed(p, d) {
+			out = append(out, d)
+		}
+	}
+	halts := false
+	for i, w := range p.Words {
+		op, operand := Opcode(w>>12), w&0xFFF
+		switch {
+		case data[i]:
+			if reached[i] && !slot[i] {
+				add(i, "fallthrough", "execution runs into data")
+			}
+			continue
+		case !reached[i] && (i == 0 || reached[i-1] || data[i-1]):
+			// Reported once for each run of unreachable code.
+			add(i, "unreachable", "%s is never reached", op)
+		case reached[i] && op == OpHalt:
+			halts = true
+		}
+		switch {
+		case op == OpSkipcond && (operand&0x3FF != 0 || operand>>10 == 3):
+			add(i, "skipcond", "Skipcond %03X is not a condition; want 000, 400 or 800", operand)
+		case op == OpStore && inProgram(operand) && !data[int(operand-p.Origin)]:
+			add(i, "self-modify", "Store overwrites the code at %03X", operand)
+		}
+	}
+	if !halts && len(p.Words) > 0 {
+		add(0, "halt", "no Halt is reached, so the program never stops")
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Addr < out[j].Addr })
+	return out
+}
+
+// allowed reports whether a pragma on the line of d suppresses it.
+func allowed(p Program, d Diagnostic) bool {
+	for _, pr := range p.PragmasAt(d.Line, "allow") {
+		if len(pr.Args) == 0 {
+			return true
+		}
+		for _, check := range pr.Args {
+			if check == d.Check {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package mary
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVet(t *testing.T) {
+	src := `	Load X
+	Skipcond 0C00
+	Store Loop
+Loop,	Jump Loop
+	Output		/ mary:allow
+	Store X
+	Store Y		/ mary:allow self-modify
+	Skipcond 0401
+	Jump Data
+X,	DEC 1
+Y,	Halt
+Data,	DEC 2
+`
+	p, err := Assemble(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Diagnostic{
+		{0x000, 1, "halt", "no Halt is reached, so the program never stops"},
+		{0x001, 2, "skipcond", "Skipcond C00 is not a condition; want 000, 400 or 800"},
+		{0x002, 3, "self-modify", "Store overwrites the code at 003"},
+		{0x007, 8, "skipcond", "Skipcond 401 is not a condition; want 000, 400 or 800"},
+		{0x00A, 11, "unreachable", "Halt is never reached"},
+	}
+	if got := Vet(p); !reflect.DeepEqual(got, want) {
+		t.Errorf("Vet:\n%v\nwant:\n%v", got, want)
+	}
+
+	p, err = Assemble(strings.NewReader("Load X\nJump Skip\nOutput\nSkip, Skipcond 400\nJump X\nHalt\nX, DEC 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []Diagnostic{
+		{0x002, 3, "unreachable", "Output is never reached"},
+		{0x006, 7, "fallthrough", "execution runs into data"},
+	}
+	if got := Vet(p); !reflect.DeepEqual(got, want) {
+		t.Errorf("Vet:\n%v\nwant:\n%v", got, want)
+	}
+}
@@ -0,0 +1,119 @@
+package mary
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Warning is a likely mistake in a program that still assembles, such as a label that is never used.
+// Its kind, such as WarnUnusedLabel, can be tested with errors.Is.
+type Warning struct {
+	SyntaxError
+}
+
+// Error formats the warning like a SyntaxError. eg., "warning: line 3: X, DEC 1: label X is never used".
+func (w Warning) Error() string {
+	return fmt.Sprintf("warning: %s: %s", w.position(), w.detail())
+}
+
+// Kinds of Warning.
+var (
+	WarnUnusedLabel     = errors.New("unused label")           // a label no operand uses
+	WarnShadowsMnemonic = errors.New("label shadows mnemonic") // a label spelled like a mnemonic or directive in another case
+	WarnDataBeforeHalt  = errors.New("data before halt")       // data that execution runs into, as if a Halt were missing
+	WarnJumpToData      = errors.New("jump to data")           // a Jump or JnS whose target is data
+)
+
+// warnings finds the warnings of a program, and its cross reference, as it is assembled.
+type warnings struct {
+	stmts   []sourceLine
+	defined map[string]int   // index in stmts of the line defining each label and constant
+	uses    map[string][]int // source lines of the operands using each name, once per line
+	stmtOf  []int            // index in stmts of each word
+	jumps   []jump
+}
+
+// jump is a Jump or JnS, whose target must be code.
+type jump struct {
+	stmt int
+	op   Opcode
+	addr Word
+}
+
+// use records the names used by the operand tok, on the source line lineNo.
+func (w *warnings) use(tok Token, lineNo int) {
+	for _, atom := range atomRE.FindAllString(tok.str, -1) {
+		if lines := w.uses[atom]; TokenIdentifier(atom) && (len(lines) == 0 || lines[len(lines)-1] != lineNo) {
+			w.uses[atom] = append(lines, lineNo)
+		}
+	}
+}
+
+// xrefs returns the cross reference of the names defined in the program.
+func (w *warnings) xrefs() map[string]Xref {
+	out := make(map[string]Xref, len(w.defined))
+	for name, i := range w.defined {
+		out[name] = Xref{w.stmts[i].lineNo, w.uses[name]}
+	}
+	return out
+}
+
+// find returns the warnings for the assembled program p, in source order.
+func (w *warnings) find(p Program) []Warning {
+	found := make([][]Warning, len(w.stmts))
+	add := func(i int, kind error, reason string, tok int) {
+		tokens, _ := tokenize(w.stmts[i].text)
+		found[i] = append(found[i], Warning{w.stmts[i].syntaxErrorAt(kind, reason, tokens, statementStart(tokens)+tok, "")})
+	}
+
+	for name, addr := range p.Symbols {
+		i := w.defined[name]
+		if k, ok := keywords[strings.ToLower(name)]; ok {
+			add(i, WarnShadowsMnemonic, fmt.Sprintf("label %s is spelled like %s", name, k), -2)
+		}
+		// The label of the first word names the program's entry rather than something used.
+		if len(w.uses[name]) == 0 && addr != p.Origin {
+			add(i, WarnUnusedLabel, "label "+name+" is never used", -2)
+		}
+	}
+	for i, data := range p.Data {
+		switch {
+		case !data || i > 0 && p.Data[i-1]:
+		case i == 0:
+			add(w.stmtOf[i], WarnDataBeforeHalt, "execution starts in this data", 0)
+		default:
+			switch Opcode(p.Words[i-1] >> 12) {
+			case OpJump, OpJumpI, OpHalt:
+			default:
+				add(w.stmtOf[i], WarnDataBeforeHalt, "execution runs into this data; is a Halt or Jump missing above it?", 0)
+			}
+		}
+	}
+	for _, j := range w.jumps {
+		target := int(j.addr) - int(p.Origin)
+		reason := "Jump to data"
+		if j.op == OpJnS {
+			// JnS stores the return address at its operand and runs the word after it.
+			target++
+			reason = "JnS runs the word after its operand, which is data"
+		}
+		if target >= 0 && target < len(p.Data) && p.Data[target] {
+			add(j.stmt, WarnJumpToData, reason, 1)
+		}
+	}
+
+	var out []Warning
+	for _, ws := range found {
+		out = append(out, ws...)
+	}
+	return out
+}
+
+// statementStart returns the index in tokens of the mnemonic or directive: 2 if the line is labelled.
+func statementStart(tokens []Token) int {
+	if len(tokens) >= 2 && hashTokens(tokens[:2]) == hashTokenTypes(TokenIdentifier, TokenComma) {
+		return 2
+	}
+	return 0
+}
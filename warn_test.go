@@ -0,0 +1,65 @@
+package mary
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAssembleWarnings(t *testing.T) {
+	src := `Start,	Load N
+	JnS Sub
+	Jump N
+	JnS N
+Sub,	HEX 0
+	Output
+	JumpI Sub
+N,	DEC 1
+halt,	DEC 2
+Unused,	Clear
+	Halt
+Table,	DEC 3
+`
+	p, err := Assemble(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		kind error
+		msg  string
+	}{
+		{WarnJumpToData, "warning: line 3: \tJump N: Jump to data"},
+		{WarnJumpToData, "warning: line 4: \tJnS N: JnS runs the word after its operand, which is data"},
+		{WarnDataBeforeHalt, "warning: line 5: Sub,\tHEX 0: execution runs into this data; is a Halt or Jump missing above it?"},
+		{WarnShadowsMnemonic, "warning: line 9: halt,\tDEC 2: label halt is spelled like Halt"},
+		{WarnUnusedLabel, "warning: line 9: halt,\tDEC 2: label halt is never used"},
+		{WarnUnusedLabel, "warning: line 10: Unused,\tClear: label Unused is never used"},
+		{WarnUnusedLabel, "warning: line 12: Table,\tDEC 3: label Table is never used"},
+	}
+	if len(p.Warnings) != len(want) {
+		t.Fatalf("Warnings = %v, want %d", p.Warnings, len(want))
+	}
+	for i, w := range want {
+		if got := p.Warnings[i]; !errors.Is(got, w.kind) || got.Error() != w.msg {
+			t.Errorf("Warnings[%d] = %v, want %s", i, got, w.msg)
+		}
+	}
+
+	a := &Assembler{Strict: true}
+	if _, err := a.Assemble(strings.NewReader(src)); !errors.Is(err, WarnUnusedLabel) || !errors.Is(err, WarnJumpToData) {
+		t.Errorf("Assemble with Strict = %v, want the warnings as errors", err)
+	}
+	if _, err := a.Assemble(strings.NewReader("Start, Load N\nHalt\nN, DEC 1\n")); err != nil {
+		t.Errorf("Assemble with Strict = %v, want no error", err)
+	}
+}
+
+func TestAssembleWarningsStartInData(t *testing.T) {
+	p, err := Assemble(strings.NewReader("X, DEC 1\nLoad X\nHalt\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Warnings) != 1 || !errors.Is(p.Warnings[0], WarnDataBeforeHalt) {
+		t.Errorf("Warnings = %v, want execution starting in data", p.Warnings)
+	}
+}